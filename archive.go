@@ -0,0 +1,410 @@
+package exch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// tick 档案是存放单个 Symbol 的大量 tick 的二进制文件格式，
+// 它比 CSV 和逐个编码的 gob 更小，读取得也更快
+//
+// 格式的第 1 版如下，其中
+// uvarint 和 svarint 与 encoding/binary 中的 Uvarint 和 Varint 一样，
+// svarint 使用 zigzag 编码，u64 是 8 字节的小端序无符号整数
+//
+//	文件   = 文件头 数据块* 索引 文件尾
+//	文件头 = "EXTK" 版本(1 字节，值为 1)
+//	         uvarint(len(Exchange)) Exchange uvarint(len(Symbol)) Symbol
+//	数据块 = uvarint(tick 的个数) uvarint(数据的字节数) tick*
+//	tick   = svarint(Date 的增量) svarint(ID 的增量) svarint(Price 的增量)
+//	         svarint(Volume) svarint(Side)
+//	索引   = uvarint(数据块的个数) 块索引*
+//	块索引 = svarint(第一个 tick 的 Date) uvarint(数据块在文件中的偏移量) uvarint(tick 的个数)
+//	文件尾 = u64(索引在文件中的偏移量) "EXTK"
+//
+// Date 是 Unix 纳秒，Price 和 Volume 是 Decimal 的整数值，也就是实际值乘以 1e8
+// Side 的 BUY 是 -1，SELL 是 1，0 表示不知道主动方
+// 增量是与同一个数据块中的上一个 tick 的差，每个数据块的第一个 tick 与 0 相减，
+// 所以每个数据块都可以单独解码
+// 数据块中的 tick 按照 Date 排序，数据块的大小没有限制
+// 偏移量都从文件的第一个字节开始计算
+const (
+	archiveMagic   = "EXTK"
+	archiveVersion = 1
+	// archiveChunkSize 是 TickArchiveWriter 每个数据块中 tick 的个数
+	archiveChunkSize = 4096
+	// archiveTrailerSize 是文件尾的字节数
+	archiveTrailerSize = 8 + len(archiveMagic)
+)
+
+var errArchive = errors.New("tick archive: 无法解析的数据")
+
+// archiveChunk 是数据块的索引
+type archiveChunk struct {
+	begin  int64
+	offset int64
+	count  int
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// TickArchiveWriter 把 tick 写成 tick 档案
+type TickArchiveWriter struct {
+	w        io.Writer
+	exchange Name
+	symbol   string
+	// offset 是已经写入 w 的字节数
+	offset int64
+	chunk  []byte
+	count  int
+	index  []archiveChunk
+	// last 是上一个写入的 tick，新的数据块的第一个 tick 写入时，会先检查顺序，再清零
+	last     Tick
+	isClosed bool
+}
+
+// NewTickArchiveWriter 返回向 w 写入 exchange 交易所 symbol 的 tick 档案的 TickArchiveWriter
+// 写完以后，需要调用 Close
+func NewTickArchiveWriter(w io.Writer, exchange Name, symbol string) (*TickArchiveWriter, error) {
+	aw := &TickArchiveWriter{
+		w:        w,
+		exchange: exchange,
+		symbol:   symbol,
+		chunk:    make([]byte, 0, archiveChunkSize*16),
+		index:    make([]archiveChunk, 0, 1024),
+	}
+	header := append([]byte(archiveMagic), archiveVersion)
+	header = appendString(header, string(exchange))
+	header = appendString(header, symbol)
+	return aw, aw.write(header)
+}
+
+func (w *TickArchiveWriter) write(bs []byte) error {
+	n, err := w.w.Write(bs)
+	w.offset += int64(n)
+	return err
+}
+
+// Write 会把 t 写入档案
+// t 的 Exchange 和 Symbol 需要为空，或者与档案的一样
+// t 需要按照 Date 排序
+func (w *TickArchiveWriter) Write(t Tick) error {
+	if w.isClosed {
+		return errors.New("tick archive: 已经关闭了")
+	}
+	if (t.Exchange != "" && t.Exchange != w.exchange) || (t.Symbol != "" && t.Symbol != w.symbol) {
+		return fmt.Errorf("tick archive: %s 的 %s 的 tick 不能写入 %s 的 %s 的档案",
+			t.Exchange, t.Symbol, w.exchange, w.symbol)
+	}
+	// 在新的数据块重置 w.last 以前检查，这样数据块之间也是按照 Date 排序的，
+	// 索引中的 begin 才是递增的
+	if t.Date.Before(w.last.Date) {
+		return errors.New("tick archive: tick 需要按照 Date 排序")
+	}
+	date := t.Date.UnixNano()
+	if w.count == 0 {
+		w.index = append(w.index, archiveChunk{begin: date, offset: w.offset})
+		w.last = Tick{Date: time.Unix(0, 0)}
+	}
+	w.chunk = appendVarint(w.chunk, date-w.last.Date.UnixNano())
+	w.chunk = appendVarint(w.chunk, t.ID-w.last.ID)
+	w.chunk = appendVarint(w.chunk, int64(t.Price-w.last.Price))
+	w.chunk = appendVarint(w.chunk, int64(t.Volume))
+	w.chunk = appendVarint(w.chunk, int64(t.Side))
+	w.last = t
+	w.count++
+	if w.count == archiveChunkSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush 会把还没有写入的 tick 作为一个数据块写入 w
+func (w *TickArchiveWriter) flush() error {
+	if w.count == 0 {
+		return nil
+	}
+	w.index[len(w.index)-1].count = w.count
+	header := appendUvarint(nil, uint64(w.count))
+	header = appendUvarint(header, uint64(len(w.chunk)))
+	w.count = 0
+	if err := w.write(header); err != nil {
+		return err
+	}
+	err := w.write(w.chunk)
+	w.chunk = w.chunk[:0]
+	return err
+}
+
+// Close 会写入剩下的 tick、索引和文件尾，但是不会关闭底层的 io.Writer
+func (w *TickArchiveWriter) Close() error {
+	if w.isClosed {
+		return nil
+	}
+	w.isClosed = true
+	if err := w.flush(); err != nil {
+		return err
+	}
+	indexOffset := w.offset
+	buf := appendUvarint(nil, uint64(len(w.index)))
+	for _, c := range w.index {
+		buf = appendVarint(buf, c.begin)
+		buf = appendUvarint(buf, uint64(c.offset))
+		buf = appendUvarint(buf, uint64(c.count))
+	}
+	var trailer [8]byte
+	binary.LittleEndian.PutUint64(trailer[:], uint64(indexOffset))
+	buf = append(buf, trailer[:]...)
+	buf = append(buf, archiveMagic...)
+	return w.write(buf)
+}
+
+// TickArchiveReader 从 tick 档案中读取 tick，它实现了 TickReader
+type TickArchiveReader struct {
+	r        io.ReadSeeker
+	br       *bufio.Reader
+	exchange Name
+	symbol   string
+	index    []archiveChunk
+	// next 是下一个要读取的数据块
+	next int
+	// buf 是所有数据块共用的缓冲区，只有遇到更大的数据块时才会重新分配
+	buf []byte
+	// chunk 是 buf 中当前数据块还没有读取的部分
+	chunk []byte
+	// rest 是当前数据块中还没有读取的 tick 的个数
+	rest int
+	last Tick
+}
+
+// NewTickArchiveReader 会读取 r 的文件头和索引，并返回从头开始读取 tick 的 TickArchiveReader
+func NewTickArchiveReader(r io.ReadSeeker) (*TickArchiveReader, error) {
+	ar := &TickArchiveReader{r: r}
+	if err := ar.readHeader(); err != nil {
+		return nil, err
+	}
+	if err := ar.readIndex(); err != nil {
+		return nil, err
+	}
+	return ar, ar.seekChunk(0)
+}
+
+func (r *TickArchiveReader) readHeader() error {
+	if _, err := r.r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	br := bufio.NewReader(r.r)
+	head := make([]byte, len(archiveMagic)+1)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return errArchive
+	}
+	if string(head[:len(archiveMagic)]) != archiveMagic {
+		return errArchive
+	}
+	if v := head[len(archiveMagic)]; v != archiveVersion {
+		return fmt.Errorf("tick archive: 不支持第 %d 版的格式", v)
+	}
+	exchange, err := readString(br)
+	if err != nil {
+		return err
+	}
+	r.exchange = Name(exchange)
+	r.symbol, err = readString(br)
+	return err
+}
+
+func readString(br *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil || n > 1<<16 {
+		return "", errArchive
+	}
+	bs := make([]byte, n)
+	if _, err := io.ReadFull(br, bs); err != nil {
+		return "", errArchive
+	}
+	return string(bs), nil
+}
+
+func (r *TickArchiveReader) readIndex() error {
+	end, err := r.r.Seek(-int64(archiveTrailerSize), io.SeekEnd)
+	if err != nil {
+		return errArchive
+	}
+	trailer := make([]byte, archiveTrailerSize)
+	if _, err := io.ReadFull(r.r, trailer); err != nil {
+		return errArchive
+	}
+	if string(trailer[8:]) != archiveMagic {
+		return errArchive
+	}
+	offset := int64(binary.LittleEndian.Uint64(trailer[:8]))
+	if offset < 0 || offset > end {
+		return errArchive
+	}
+	if _, err := r.r.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	br := bufio.NewReader(io.LimitReader(r.r, end-offset))
+	n, err := binary.ReadUvarint(br)
+	if err != nil || n > uint64(end) {
+		return errArchive
+	}
+	r.index = make([]archiveChunk, n)
+	for i := range r.index {
+		begin, err1 := binary.ReadVarint(br)
+		off, err2 := binary.ReadUvarint(br)
+		count, err3 := binary.ReadUvarint(br)
+		if err1 != nil || err2 != nil || err3 != nil || off > uint64(offset) {
+			return errArchive
+		}
+		r.index[i] = archiveChunk{begin: begin, offset: int64(off), count: int(count)}
+	}
+	return nil
+}
+
+// Exchange 返回档案中的 tick 的交易所
+func (r *TickArchiveReader) Exchange() Name {
+	return r.exchange
+}
+
+// Symbol 返回档案中的 tick 的 Symbol
+func (r *TickArchiveReader) Symbol() string {
+	return r.symbol
+}
+
+// Len 返回档案中 tick 的总数
+func (r *TickArchiveReader) Len() int {
+	res := 0
+	for _, c := range r.index {
+		res += c.count
+	}
+	return res
+}
+
+// seekChunk 让下一次 Read 从第 i 个数据块开始读取
+func (r *TickArchiveReader) seekChunk(i int) error {
+	r.next, r.rest = i, 0
+	if i >= len(r.index) {
+		return nil
+	}
+	if _, err := r.r.Seek(r.index[i].offset, io.SeekStart); err != nil {
+		return err
+	}
+	if r.br == nil {
+		r.br = bufio.NewReaderSize(r.r, 1<<16)
+	} else {
+		r.br.Reset(r.r)
+	}
+	return nil
+}
+
+// readChunk 读取下一个数据块
+func (r *TickArchiveReader) readChunk() error {
+	if r.next >= len(r.index) {
+		return io.EOF
+	}
+	count, err := binary.ReadUvarint(r.br)
+	if err != nil {
+		return errArchive
+	}
+	size, err := binary.ReadUvarint(r.br)
+	if err != nil || size > 1<<30 {
+		return errArchive
+	}
+	if uint64(cap(r.buf)) < size {
+		r.buf = make([]byte, size)
+	}
+	r.chunk = r.buf[:size]
+	if _, err := io.ReadFull(r.br, r.chunk); err != nil {
+		return errArchive
+	}
+	r.next++
+	r.rest = int(count)
+	r.last = Tick{Date: time.Unix(0, 0)}
+	return nil
+}
+
+// Read 返回下一个 tick，读完以后返回 io.EOF
+func (r *TickArchiveReader) Read() (Tick, error) {
+	for r.rest == 0 {
+		if err := r.readChunk(); err != nil {
+			return Tick{}, err
+		}
+	}
+	var vs [5]int64
+	for i := range vs {
+		v, n := binary.Varint(r.chunk)
+		if n <= 0 {
+			return Tick{}, errArchive
+		}
+		vs[i], r.chunk = v, r.chunk[n:]
+	}
+	t := Tick{
+		Exchange: r.exchange,
+		Symbol:   r.symbol,
+		ID:       r.last.ID + vs[1],
+		Date:     time.Unix(0, r.last.Date.UnixNano()+vs[0]),
+		Price:    r.last.Price + Decimal(vs[2]),
+		Volume:   Decimal(vs[3]),
+		Side:     OrderSide(vs[4]),
+	}
+	r.last = t
+	r.rest--
+	return t, nil
+}
+
+// Seek 让下一次 Read 返回第一个 Date 不早于 date 的 tick
+// 它会利用索引直接跳到 date 所在的数据块
+func (r *TickArchiveReader) Seek(date time.Time) error {
+	d := date.UnixNano()
+	// 第一个 begin 不早于 d 的数据块的前一个，才可能包含 d 之前的 tick
+	i := sort.Search(len(r.index), func(i int) bool {
+		return r.index[i].begin >= d
+	})
+	if i > 0 {
+		i--
+	}
+	if err := r.seekChunk(i); err != nil {
+		return err
+	}
+	for {
+		if r.rest == 0 {
+			if err := r.readChunk(); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+		}
+		// 先看一看下一个 tick，不早于 date 的话，就退回去
+		chunk, last, rest := r.chunk, r.last, r.rest
+		t, err := r.Read()
+		if err != nil {
+			return err
+		}
+		if !t.Date.Before(date) {
+			r.chunk, r.last, r.rest = chunk, last, rest
+			return nil
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package exch
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var _ TickReader = (*TickArchiveReader)(nil)
+
+func Test_TickArchive(t *testing.T) {
+	Convey("TickArchiveWriter 写入的 tick 可以被 TickArchiveReader 读回来", t, func() {
+		begin := time.Date(2021, 5, 14, 8, 0, 0, 0, time.UTC)
+		n := archiveChunkSize*2 + 100
+		ticks := make([]Tick, n)
+		for i := range ticks {
+			ticks[i] = Tick{
+				Exchange: BINANCE,
+				Symbol:   "BTCUSDT",
+				ID:       int64(1000 + i),
+				Date:     begin.Add(time.Duration(i) * time.Second),
+				Price:    NewDecimal(50000 + float64(i%7) - 3.5),
+				Volume:   NewDecimal(0.001 * float64(i%5+1)),
+				Side:     []OrderSide{BUY, SELL, 0}[i%3],
+			}
+		}
+		var buf bytes.Buffer
+		w, err := NewTickArchiveWriter(&buf, BINANCE, "BTCUSDT")
+		So(err, ShouldBeNil)
+		for _, tick := range ticks {
+			So(w.Write(tick), ShouldBeNil)
+		}
+		So(w.Close(), ShouldBeNil)
+		r, err := NewTickArchiveReader(bytes.NewReader(buf.Bytes()))
+		So(err, ShouldBeNil)
+		So(r.Exchange(), ShouldEqual, BINANCE)
+		So(r.Symbol(), ShouldEqual, "BTCUSDT")
+		So(r.Len(), ShouldEqual, n)
+		Convey("按照顺序读取全部的 tick", func() {
+			for _, expected := range ticks {
+				actual, err := r.Read()
+				So(err, ShouldBeNil)
+				So(actual.Date.Equal(expected.Date), ShouldBeTrue)
+				actual.Date = expected.Date
+				So(actual, ShouldResemble, expected)
+			}
+			_, err := r.Read()
+			So(err, ShouldEqual, io.EOF)
+		})
+		Convey("所有的数据块共用一个缓冲区", func() {
+			_, err := r.Read()
+			So(err, ShouldBeNil)
+			buf := &r.buf[0]
+			for i := 1; i < n; i++ {
+				_, err := r.Read()
+				So(err, ShouldBeNil)
+			}
+			So(&r.buf[0], ShouldEqual, buf)
+		})
+		Convey("Seek 可以跳到指定的时间", func() {
+			for _, i := range []int{0, 1, archiveChunkSize - 1, archiveChunkSize, n - 1} {
+				So(r.Seek(ticks[i].Date), ShouldBeNil)
+				actual, err := r.Read()
+				So(err, ShouldBeNil)
+				So(actual.ID, ShouldEqual, ticks[i].ID)
+			}
+			So(r.Seek(begin.Add(archiveChunkSize*time.Second-time.Millisecond)), ShouldBeNil)
+			actual, err := r.Read()
+			So(err, ShouldBeNil)
+			So(actual.ID, ShouldEqual, ticks[archiveChunkSize].ID)
+			So(r.Seek(ticks[n-1].Date.Add(time.Second)), ShouldBeNil)
+			_, err = r.Read()
+			So(err, ShouldEqual, io.EOF)
+		})
+		Convey("档案比 gob 编码小得多", func() {
+			enc := EncFunc()
+			gobSize := 0
+			for _, tick := range ticks {
+				gobSize += len(enc(tick))
+			}
+			So(buf.Len()*4, ShouldBeLessThan, gobSize)
+		})
+	})
+	Convey("TickArchiveWriter 会检查写入的 tick", t, func() {
+		var buf bytes.Buffer
+		w, err := NewTickArchiveWriter(&buf, BINANCE, "BTCUSDT")
+		So(err, ShouldBeNil)
+		date := time.Now()
+		So(w.Write(NewTick(1, date, 1, 1, TickSymbol("ETHUSDT"))), ShouldNotBeNil)
+		So(w.Write(NewTick(1, date, 1, 1)), ShouldBeNil)
+		So(w.Write(NewTick(2, date.Add(-time.Second), 1, 1)), ShouldNotBeNil)
+		So(w.Close(), ShouldBeNil)
+		So(w.Write(NewTick(3, date, 1, 1)), ShouldNotBeNil)
+	})
+	Convey("数据块之间也需要按照 Date 排序", t, func() {
+		var buf bytes.Buffer
+		w, err := NewTickArchiveWriter(&buf, BINANCE, "BTCUSDT")
+		So(err, ShouldBeNil)
+		date := time.Date(2021, 5, 14, 8, 0, 0, 0, time.UTC)
+		for i := 0; i < archiveChunkSize; i++ {
+			So(w.Write(NewTick(int64(i), date.Add(time.Duration(i)*time.Second), 1, 1)), ShouldBeNil)
+		}
+		// 第一个数据块已经写满了，下一个 tick 是第二个数据块的第一个 tick
+		So(w.Write(NewTick(-1, date, 1, 1)), ShouldNotBeNil)
+		last := date.Add(archiveChunkSize * time.Second)
+		So(w.Write(NewTick(archiveChunkSize, last, 1, 1)), ShouldBeNil)
+		So(w.Close(), ShouldBeNil)
+		r, err := NewTickArchiveReader(bytes.NewReader(buf.Bytes()))
+		So(err, ShouldBeNil)
+		// 被拒绝的 tick 不会在索引中留下数据块
+		So(len(r.index), ShouldEqual, 2)
+		So(r.index[1].begin, ShouldEqual, last.UnixNano())
+		So(r.Len(), ShouldEqual, archiveChunkSize+1)
+		So(r.Seek(date.Add(time.Second)), ShouldBeNil)
+		tick, err := r.Read()
+		So(err, ShouldBeNil)
+		So(tick.ID, ShouldEqual, 1)
+	})
+	Convey("TickArchiveReader 会拒绝无法解析的数据", t, func() {
+		_, err := NewTickArchiveReader(bytes.NewReader([]byte("not an archive")))
+		So(err, ShouldNotBeNil)
+		var buf bytes.Buffer
+		w, _ := NewTickArchiveWriter(&buf, BINANCE, "BTCUSDT")
+		So(w.Close(), ShouldBeNil)
+		bs := buf.Bytes()
+		bs[len(archiveMagic)] = 2
+		_, err = NewTickArchiveReader(bytes.NewReader(bs))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "第 2 版")
+	})
+}
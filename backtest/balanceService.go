@@ -6,51 +6,62 @@ import (
 	"log"
 	"time"
 
+	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/jujili/clock"
 	"github.com/jujili/exch"
 )
 
-// BalanceService 会在每天的凌晨零点零分零秒记录 balance 的总价值
-// prices 里面需要放好各种资产的价格，不要忘记 capital 的价格是 1
-func BalanceService(ctx context.Context, ps Pubsub, prices map[string]float64, asset string) {
+// balanceService 是 BalanceService 的设置
+type balanceService struct {
+	clock *clock.Simulator
+}
+
+// WithClock 会让 BalanceService 按照 c 的时间记录每天的 balance，例如 Replayer.Clock()
+// c 由提供它的组件推进，例如 Replayer 在发送 tick 时推进它的 Clock，
+// 所以 Replayer 暂停或者跳转时，BalanceService 的时间也会随之暂停或者跳转
+// BalanceService 不会再根据收到的 tick 推进 c
+func WithClock(c *clock.Simulator) func(*balanceService) {
+	return func(svc *balanceService) {
+		svc.clock = c
+	}
+}
+
+// BalanceService 会在本地时区每天的凌晨零点零分零秒记录 balance 的总价值
+// prices 里面需要放好各种资产的价格，不要忘记 capital 的价格是 1，没有价格的资产不计入总价值
+// tick 和 balance 按照 Metadata 中记录的 exch.Codec 解码
+// 无法解码的 tick 和 balance 会被转发到 DeadLetterTopic 话题
+// 默认会以第一个 tick 的时间创建时钟，并由 BalanceService 根据收到的 tick 推进，
+// 使用 WithClock 的话，会使用别的组件推进的时钟
+func BalanceService(ctx context.Context, ps Pubsub, prices map[string]float64, asset string, options ...func(*balanceService)) {
 	log.Println("进入 BalanceService...")
+	svc := &balanceService{}
+	for _, option := range options {
+		option(svc)
+	}
 	ticks, err := ps.Subscribe(ctx, "tick")
 	if err != nil {
 		panic(err)
 	}
-	decTick := exch.DecTickFunc()
+	decTick := exch.DecMsgFunc()
 	//
 	balances, err := ps.Subscribe(ctx, "balance")
 	if err != nil {
 		panic(err)
 	}
-	decBal := exch.DecBalanceFunc()
+	decBal := exch.DecMsgFunc()
 	go func() {
 		log.Println("进入 BalanceService go func ...")
-		// 创建模拟 clock
-		msg := <-ticks
-		tick := decTick(msg.Payload)
-		msg.Ack()
-		prices[asset] = tick.Price
-		clock := clock.NewSimulator(tick.Date)
-		everyNewDay := clock.EveryDay(0, 0, 0)
-		// 另起一个 goroutine，更新 clock
-		go func() {
-			log.Println("进入 BalanceService 时钟 goroutine ...")
-			tks, _ := ps.Subscribe(ctx, "tick")
-			for msg := range tks {
-				tick := decTick(msg.Payload)
-				msg.Ack()
-				clock.SetOrPanic(tick.Date)
-				// log.Println("将 BalanceService 的本地始终设置成了", tick.Date)
-			}
-			log.Println("balance service, ticks end, not update clock")
-		}()
+		clk := svc.clock
+		if clk == nil {
+			clk = newTickClock(ctx, ps, ticks, decTick, prices, asset)
+		}
+		everyNewDay := clk.EveryDay(0, 0, 0)
 		//
 		go func() {
 			log.Println("进入 BalanceService 帐户记录 goroutine ...")
 			var bal *exch.Balance
 			bs := make([]balanceSnap, 0, 2048)
+			deadLetter := newDeadLetter(ps)
 			count := 0
 			for count < 2 {
 				// log.Println("进入 BalanceService 帐户记录 for ...")
@@ -63,17 +74,26 @@ func BalanceService(ctx context.Context, ps Pubsub, prices map[string]float64, a
 						ticks = nil
 						continue
 					}
-					tick := decTick(msg.Payload)
+					var tick exch.Tick
+					if err := decTick(msg, &tick); err != nil {
+						deadLetter("tick", msg, err)
+						continue
+					}
 					msg.Ack()
-					prices[asset] = tick.Price
+					prices[asset] = tick.Price.Float64()
 				case msg, ok := <-balances:
 					if !ok {
 						count++
 						balances = nil
 						continue
 					}
-					bal = decBal(msg.Payload)
+					var b exch.Balance
+					if err := decBal(msg, &b); err != nil {
+						deadLetter("balance", msg, err)
+						continue
+					}
 					msg.Ack()
+					bal = &b
 				case date := <-everyNewDay:
 					newBal := newBalanceSnap(date, bal, prices, asset)
 					bs = append(bs, newBal)
@@ -86,6 +106,43 @@ func BalanceService(ctx context.Context, ps Pubsub, prices map[string]float64, a
 	}()
 }
 
+// newTickClock 会以 ticks 中第一个能够解码的 tick 的时间创建时钟，并用它的价格设置 prices[asset]
+// 然后另起一个 goroutine，根据 "tick" 话题推进时钟
+func newTickClock(ctx context.Context, ps Pubsub, ticks <-chan *message.Message,
+	decTick func(*message.Message, interface{}) error, prices map[string]float64, asset string) *clock.Simulator {
+	// 跳过无法解码的 tick，直到收到第一个有效的 tick
+	deadLetter := newDeadLetter(ps)
+	var tick exch.Tick
+	for msg := range ticks {
+		if err := decTick(msg, &tick); err != nil {
+			deadLetter("tick", msg, err)
+			continue
+		}
+		msg.Ack()
+		break
+	}
+	prices[asset] = tick.Price.Float64()
+	clk := clock.NewSimulator(tick.Date)
+	// 另起一个 goroutine，更新 clock
+	go func() {
+		log.Println("进入 BalanceService 时钟 goroutine ...")
+		tks, _ := ps.Subscribe(ctx, "tick")
+		decTick := exch.DecMsgFunc()
+		for msg := range tks {
+			var tick exch.Tick
+			err := decTick(msg, &tick)
+			// 无法解码的 tick 已经由另一个订阅转发到死信话题了
+			msg.Ack()
+			if err != nil {
+				continue
+			}
+			clk.SetOrPanic(tick.Date)
+		}
+		log.Println("balance service, ticks end, not update clock")
+	}()
+	return clk
+}
+
 type balanceSnap struct {
 	date   time.Time
 	amount float64
@@ -95,7 +152,7 @@ type balanceSnap struct {
 func newBalanceSnap(date time.Time, balance *exch.Balance, prices map[string]float64, asset string) balanceSnap {
 	return balanceSnap{
 		date:   date,
-		amount: balance.Total(prices),
+		amount: total(*balance, prices),
 		price:  prices[asset],
 	}
 }
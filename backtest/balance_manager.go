@@ -1,7 +1,6 @@
 package backtest
 
 import (
-	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/jujili/exch"
 )
@@ -14,23 +13,28 @@ import (
 type balanceManager struct {
 	Balance exch.Balance
 	pub     Publisher
-	enc     func(interface{}) []byte
+	enc     func(interface{}) *message.Message
 }
 
 func newBalanceManager(pub Publisher, bal exch.Balance) *balanceManager {
 	return &balanceManager{
 		Balance: bal,
 		pub:     pub,
-		enc:     exch.EncFunc(),
+		enc:     exch.EncMsgFunc(exch.Gob),
 	}
 }
 
+// add 只会修改 bm.Balance，不会发布
+// 需要连续修改多次时，在最后调用一次 update 即可
+func (bm *balanceManager) add(as ...exch.Asset) {
+	bm.Balance = bm.Balance.Add(as...)
+}
+
 // NOTICE: 并没有核查 bm 内资产的 total，有可能 total 是负值
 func (bm *balanceManager) update(as ...exch.Asset) {
-	bm.Balance = bm.Balance.Add(as...)
-	payload := bm.enc(bm.Balance)
-	msg := message.NewMessage(watermill.NewUUID(), payload)
-	go bm.pub.Publish("balance", msg)
+	bm.add(as...)
+	msg := bm.enc(bm.Balance)
+	bm.pub.Publish("balance", msg)
 	// TODO: 为什么这里总是空的
 	// log.Println("balance:", bm.Balance)
 }
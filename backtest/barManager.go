@@ -6,7 +6,6 @@ import (
 	"log"
 	"time"
 
-	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/jujili/exch"
 )
@@ -15,45 +14,229 @@ import (
 // 生成 Bar 后，会发送数据到对应的话题中。
 // 例如，生成日 bar 线后，发送到 "24h0m0sBar" 话题中
 // 例如，生成 30 日 bar 线后，发送到 "720h0m0sBar" 话题中
-func TickBarService(ctx context.Context, ps Pubsub, interval time.Duration) {
+// begin 决定了 bar 的开始时间，例如 exch.Begin 或者 exch.CalendarBegin 返回的 BeginFunc
+// 不同 Symbol 的 tick 会分别生成 bar
+// tick 按照 Metadata 中记录的 exch.Codec 解码，bar 使用最新的 tick 的 Codec 编码
+// 无法解码的 tick 会被转发到 DeadLetterTopic 话题
+func TickBarService(ctx context.Context, ps Pubsub, begin exch.BeginFunc, interval time.Duration) {
 	topic := fmt.Sprintf("%sBar", interval)
-	log.Printf(`从 tick 生成的 bar 会发送到 "%s" 话题中`, topic)
+	tickBarService(ctx, ps, "TickBarService", topic, func() func(exch.Tick) []exch.Bar {
+		return exch.GenTickBarFunc(begin, interval)
+	})
+}
+
+// CountBarService 与 TickBarService 一样，从 "tick" 话题接收 tick，
+// 每 n 个 tick 生成一个 bar，发送到 "<n>CountBar" 话题中，例如 "100CountBar"
+func CountBarService(ctx context.Context, ps Pubsub, n int) {
+	topic := fmt.Sprintf("%dCountBar", n)
+	tickBarService(ctx, ps, "CountBarService", topic, func() func(exch.Tick) []exch.Bar {
+		return exch.GenCountBarFunc(n)
+	})
+}
+
+// VolumeBarService 与 TickBarService 一样，从 "tick" 话题接收 tick，
+// 成交量每达到 volume 生成一个 bar，发送到 "<volume>VolumeBar" 话题中，例如 "10VolumeBar"
+func VolumeBarService(ctx context.Context, ps Pubsub, volume exch.Decimal) {
+	topic := fmt.Sprintf("%sVolumeBar", volume)
+	tickBarService(ctx, ps, "VolumeBarService", topic, func() func(exch.Tick) []exch.Bar {
+		return exch.GenVolumeBarFunc(volume)
+	})
+}
+
+// DollarBarService 与 TickBarService 一样，从 "tick" 话题接收 tick，
+// 成交额每达到 dollar 生成一个 bar，发送到 "<dollar>DollarBar" 话题中，例如 "1000000DollarBar"
+func DollarBarService(ctx context.Context, ps Pubsub, dollar exch.Decimal) {
+	topic := fmt.Sprintf("%sDollarBar", dollar)
+	tickBarService(ctx, ps, "DollarBarService", topic, func() func(exch.Tick) []exch.Bar {
+		return exch.GenDollarBarFunc(dollar)
+	})
+}
+
+// RangeBarService 与 TickBarService 一样，从 "tick" 话题接收 tick，
+// 价格的波动范围每达到 size 生成一个 bar，发送到 "<size>RangeBar" 话题中，例如 "0.5RangeBar"
+func RangeBarService(ctx context.Context, ps Pubsub, size exch.Decimal) {
+	topic := fmt.Sprintf("%sRangeBar", size)
+	tickBarService(ctx, ps, "RangeBarService", topic, func() func(exch.Tick) []exch.Bar {
+		return exch.GenRangeBarFunc(size)
+	})
+}
+
+// RenkoBarService 与 TickBarService 一样，从 "tick" 话题接收 tick，
+// 生成大小为 brick 的砖，发送到 "<brick>RenkoBar" 话题中，例如 "10RenkoBar"
+func RenkoBarService(ctx context.Context, ps Pubsub, brick exch.Decimal) {
+	topic := fmt.Sprintf("%sRenkoBar", brick)
+	tickBarService(ctx, ps, "RenkoBarService", topic, func() func(exch.Tick) []exch.Bar {
+		return exch.GenRenkoBarFunc(brick)
+	})
+}
+
+// tickBarService 从 "tick" 话题接收 tick，用 newGen 为每个 Symbol 生成的闭包函数生成 bar，
+// 发送到 topic 话题中，name 是日志中服务的名称
+func tickBarService(ctx context.Context, ps Pubsub, name, topic string, newGen func() func(exch.Tick) []exch.Bar) {
+	log.Printf(`%s 从 tick 生成的 bar 会发送到 "%s" 话题中`, name, topic)
+	// 参数不对的话，在这里就会 panic，而不是在收到第一个 tick 的时候
+	newGen()
 	//
 	ticks, err := ps.Subscribe(ctx, "tick")
 	if err != nil {
 		panic(err)
 	}
-	decTick := exch.DecTickFunc()
+	decTick := exch.DecMsgFunc()
 	//
-	gtb := exch.GenTickBarFunc(exch.Begin, interval)
+	// 每个 Symbol 都有自己的 gtb
+	gtbs := make(map[string]func(exch.Tick) []exch.Bar, 64)
+	symbols := make([]string, 0, 64)
 	//
-	enc := exch.EncFunc()
+	// bar 使用与 tick 相同的 Codec 编码
+	encs := make(map[string]func(interface{}) *message.Message, 4)
+	codec := exch.Gob.Name()
 	//
 	var bars []exch.Bar
+	deadLetter := newDeadLetter(ps)
 	//
 	go func() {
 		for {
 			select {
 			case <-ctx.Done():
-				log.Fatalln("TickBarService Down: ", ctx.Err())
+				log.Fatalln(name+" Down: ", ctx.Err())
 			case msg, ok := <-ticks:
 				if !ok {
-					bars = gtb(exch.NilTick)
+					// 按照 Symbol 出现的顺序，逼出每个 Symbol 的最后一个 bar
+					bars = bars[:0]
+					for _, symbol := range symbols {
+						bars = append(bars, gtbs[symbol](exch.NilTick)...)
+					}
 				} else {
-					tick := decTick(msg.Payload)
+					var tick exch.Tick
+					if err := decTick(msg, &tick); err != nil {
+						// 零值的 tick 等于 NilTick，会提前结束 bar 的生成
+						deadLetter("tick", msg, err)
+						continue
+					}
+					gtb, has := gtbs[tick.Symbol]
+					if !has {
+						gtb = newGen()
+						gtbs[tick.Symbol] = gtb
+						symbols = append(symbols, tick.Symbol)
+					}
 					bars = gtb(tick)
+					if c := msg.Metadata.Get(exch.CodecKey); c != "" {
+						codec = c
+					}
 					msg.Ack()
 				}
+				enc, has := encs[codec]
+				if !has {
+					// 能够解码的 tick，它的 Codec 一定存在
+					c, _ := exch.CodecByName(codec)
+					enc = exch.EncMsgFunc(c)
+					encs[codec] = enc
+				}
 				msgs := make([]*message.Message, 0, len(bars))
 				for _, bar := range bars {
-					msgs = append(msgs, message.NewMessage(watermill.NewUUID(), enc(bar)))
+					msgs = append(msgs, enc(bar))
 				}
 				ps.Publish(topic, msgs...)
 				if !ok {
-					log.Println("tickBarService is over")
+					log.Println(name + " is over")
 					return
 				}
 			}
 		}
 	}()
 }
+
+// BarBarService 会从 "<from>Bar" 话题接收 bar，例如 "1m0sBar"，
+// 合并成 intervals 中的各种宽度的 bar 后，发送到对应的话题中。
+// 例如，intervals 中有 time.Hour 的话，会发送到 "1h0m0sBar" 话题中
+// intervals 中的宽度都需要是 from 的整数倍，begin 决定了 bar 的开始时间
+// 不同 Symbol 的 bar 会分别合并
+// bar 按照 Metadata 中记录的 exch.Codec 解码，合并后的 bar 使用最新的 bar 的 Codec 编码
+// 无法解码的 bar 会被转发到 DeadLetterTopic 话题
+func BarBarService(ctx context.Context, ps Pubsub, begin exch.BeginFunc, from time.Duration, intervals ...time.Duration) {
+	source := fmt.Sprintf("%sBar", from)
+	topics := make([]string, len(intervals))
+	for i, interval := range intervals {
+		if interval <= from || interval%from != 0 {
+			panic(fmt.Sprintf("BarBarService: %s 不是 %s 的整数倍", interval, from))
+		}
+		topics[i] = fmt.Sprintf("%sBar", interval)
+		log.Printf(`从 "%s" 生成的 bar 会发送到 "%s" 话题中`, source, topics[i])
+	}
+	//
+	srcBars, err := ps.Subscribe(ctx, source)
+	if err != nil {
+		panic(err)
+	}
+	decBar := exch.DecMsgFunc()
+	deadLetter := newDeadLetter(ps)
+	//
+	// 每个 Symbol 的每个 interval 都有自己的 gbb
+	gbbs := make(map[string][]func(exch.Bar) []exch.Bar, 64)
+	symbols := make([]string, 0, 64)
+	//
+	// 每个话题的每种 Codec 都有自己的编码器
+	encs := make([]map[string]func(interface{}) *message.Message, len(intervals))
+	for i := range encs {
+		encs[i] = make(map[string]func(interface{}) *message.Message, 4)
+	}
+	codec := exch.Gob.Name()
+	//
+	publish := func(i int, bars []exch.Bar) {
+		enc, has := encs[i][codec]
+		if !has {
+			// 能够解码的 bar，它的 Codec 一定存在
+			c, _ := exch.CodecByName(codec)
+			enc = exch.EncMsgFunc(c)
+			encs[i][codec] = enc
+		}
+		msgs := make([]*message.Message, 0, len(bars))
+		for _, bar := range bars {
+			msgs = append(msgs, enc(bar))
+		}
+		ps.Publish(topics[i], msgs...)
+	}
+	//
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				log.Fatalln("BarBarService Down: ", ctx.Err())
+			case msg, ok := <-srcBars:
+				if !ok {
+					// 按照 Symbol 出现的顺序，逼出每个 Symbol 的最后一个 bar
+					for i := range intervals {
+						bars := make([]exch.Bar, 0, len(symbols))
+						for _, symbol := range symbols {
+							bars = append(bars, gbbs[symbol][i](exch.NilBar)...)
+						}
+						publish(i, bars)
+					}
+					log.Println("barBarService is over")
+					return
+				}
+				var bar exch.Bar
+				if err := decBar(msg, &bar); err != nil {
+					deadLetter(source, msg, err)
+					continue
+				}
+				gbb, has := gbbs[bar.Symbol]
+				if !has {
+					gbb = make([]func(exch.Bar) []exch.Bar, len(intervals))
+					for i, interval := range intervals {
+						gbb[i] = exch.GenBarBarFunc(begin, interval)
+					}
+					gbbs[bar.Symbol] = gbb
+					symbols = append(symbols, bar.Symbol)
+				}
+				if name := msg.Metadata.Get(exch.CodecKey); name != "" {
+					codec = name
+				}
+				msg.Ack()
+				for i := range intervals {
+					publish(i, gbb[i](bar))
+				}
+			}
+		}
+	}()
+}
@@ -1,12 +1,81 @@
 package backtest
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/jujili/exch"
+	. "github.com/smartystreets/goconvey/convey"
 )
 
 func Test(t *testing.T) {
 	fmt.Println(24 * time.Hour)
 	time.Sleep(time.Second * 3)
 }
+
+func Test_BarBarService(t *testing.T) {
+	Convey("BarBarService 会把 1 分钟的 bar 合并成更宽的 bar", t, func() {
+		ctx := context.Background()
+		// 需要按照顺序收到 bar
+		config := gochannel.Config{BlockPublishUntilSubscriberAck: true}
+		ps := gochannel.NewGoChannel(config, watermill.NopLogger{})
+		fives, err := ps.Subscribe(ctx, "5m0sBar")
+		So(err, ShouldBeNil)
+		So(func() { BarBarService(ctx, ps, exch.Begin, time.Minute, 90*time.Second) }, ShouldPanic)
+		BarBarService(ctx, ps, exch.Begin, time.Minute, 5*time.Minute, time.Hour)
+		begin := time.Date(2020, 5, 1, 8, 0, 0, 0, time.UTC)
+		enc := exch.EncMsgFunc(exch.JSON)
+		for i := 0; i < 6; i++ {
+			bar := exch.Bar{
+				Symbol:   "BTCUSDT",
+				Begin:    begin.Add(time.Duration(i) * time.Minute),
+				Interval: time.Minute,
+				Open:     1, High: 2, Low: 1, Close: 2,
+				Volume: 1,
+			}
+			So(ps.Publish("1m0sBar", enc(bar)), ShouldBeNil)
+		}
+		msg := <-fives
+		msg.Ack()
+		So(msg.Metadata.Get(exch.CodecKey), ShouldEqual, "json")
+		var bar exch.Bar
+		So(exch.DecMsgFunc()(msg, &bar), ShouldBeNil)
+		So(bar.Begin.Equal(begin), ShouldBeTrue)
+		So(bar.Interval, ShouldEqual, 5*time.Minute)
+		So(bar.Volume, ShouldEqual, 5)
+	})
+}
+
+func Test_CountBarService(t *testing.T) {
+	Convey("CountBarService 每 n 个 tick 生成一个 bar", t, func() {
+		ctx := context.Background()
+		config := gochannel.Config{BlockPublishUntilSubscriberAck: true}
+		ps := gochannel.NewGoChannel(config, watermill.NopLogger{})
+		msgs, err := ps.Subscribe(ctx, "2CountBar")
+		So(err, ShouldBeNil)
+		So(func() { CountBarService(ctx, ps, 0) }, ShouldPanic)
+		CountBarService(ctx, ps, 2)
+		// 发布 bar 时会阻塞到收到 Ack，所以需要同时接收 bar
+		bars := make(chan exch.Bar, 1)
+		go func() {
+			msg := <-msgs
+			msg.Ack()
+			var bar exch.Bar
+			exch.DecMsgFunc()(msg, &bar)
+			bars <- bar
+		}()
+		enc := exch.EncMsgFunc(exch.Gob)
+		begin := time.Date(2020, 5, 1, 8, 0, 0, 0, time.UTC)
+		for i := 0; i < 3; i++ {
+			tick := exch.NewTick(int64(i), begin.Add(time.Duration(i)*time.Second), 10, 1)
+			So(ps.Publish("tick", enc(tick)), ShouldBeNil)
+		}
+		bar := <-bars
+		So(bar.Begin.Equal(begin), ShouldBeTrue)
+		So(bar.Volume, ShouldEqual, 2)
+	})
+}
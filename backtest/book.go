@@ -0,0 +1,78 @@
+package backtest
+
+import (
+	"github.com/jujili/exch"
+)
+
+// book 存放了一个 symbol 的全部订单
+type book struct {
+	symbol      string
+	buys, sells *orderList
+	// rises 和 falls 存放等待触发的订单
+	// rises 中的订单会在价格上涨到 StopPrice 时触发
+	// falls 中的订单会在价格下跌到 StopPrice 时触发
+	rises, falls *orderList
+	// lastPrice 是这个 symbol 最新的成交价，还没有收到 tick 时为 0
+	lastPrice exch.Decimal
+	// info 是这个 symbol 的交易规则，为 nil 时，不检查交易规则
+	info *exch.SymbolInfo
+}
+
+func newBook(symbol string, slippage SlippageModel, info *exch.SymbolInfo) *book {
+	b := &book{
+		symbol: symbol,
+		buys:   newOrderList(),
+		sells:  newOrderList(),
+		rises:  newStopList(true),
+		falls:  newStopList(false),
+		info:   info,
+	}
+	b.buys.slippage = slippage
+	b.sells.slippage = slippage
+	if info != nil {
+		b.buys.step = info.StepSize
+		b.sells.step = info.StepSize
+	}
+	return b
+}
+
+// list 返回 o 应该放入的 orderList
+func (b *book) list(o *order) *orderList {
+	switch {
+	case o.isStop() && o.isRising():
+		return b.rises
+	case o.isStop():
+		return b.falls
+	case o.Side == exch.BUY:
+		return b.buys
+	default:
+		return b.sells
+	}
+}
+
+// lists 返回 b 中的全部 orderList
+func (b *book) lists() []*orderList {
+	return []*orderList{b.buys, b.sells, b.rises, b.falls}
+}
+
+// crosses 返回 true 表示限价单 o 在提交时与最新价 price 交叉，会立即成交
+func crosses(o *order, price exch.Decimal) bool {
+	return price > 0 && o.canMatch(price)
+}
+
+// trigger 会从 rises 和 falls 中取出被 price 触发的订单
+func (b *book) trigger(price exch.Decimal) []*order {
+	return append(b.rises.trigger(price), b.falls.trigger(price)...)
+}
+
+// match 会用 tick 撮合 buys 和 sells 中的订单
+func (b *book) match(tick exch.Tick) []fill {
+	fills := make([]fill, 0, 32)
+	if !b.buys.isEmpty() {
+		fills = append(fills, b.buys.match(tick)...)
+	}
+	if !b.sells.isEmpty() {
+		fills = append(fills, b.sells.match(tick)...)
+	}
+	return fills
+}
@@ -0,0 +1,161 @@
+package backtest
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jujili/exch"
+)
+
+// FeeModel 会计算每笔成交的手续费
+type FeeModel interface {
+	// Fee 返回 trade 需要支付的手续费，以及手续费的资产名称
+	// balance 是成交前的帐户资产
+	Fee(trade exch.Trade, balance exch.Balance) (exch.Decimal, string)
+}
+
+// received 返回 trade 中收到的资产的数量和名称
+// BUY 收到的是 Asset，SELL 收到的是 Capital
+func received(trade exch.Trade) (exch.Decimal, string) {
+	if trade.Side == exch.BUY {
+		return trade.Quantity, trade.AssetName
+	}
+	return trade.Quantity.Mul(trade.Price), trade.CapitalName
+}
+
+// MakerTaker 按照 maker 和 taker 的费率，从收到的资产中扣除手续费
+type MakerTaker struct {
+	Maker, Taker float64
+}
+
+// Fee implements FeeModel
+func (m MakerTaker) Fee(trade exch.Trade, balance exch.Balance) (exch.Decimal, string) {
+	amount, asset := received(trade)
+	if trade.IsMaker {
+		return amount.MulFloat(m.Maker), asset
+	}
+	return amount.MulFloat(m.Taker), asset
+}
+
+// SymbolFee 会让不同的 symbol 使用不同的 FeeModel
+// Symbols 中没有的 symbol 使用 Default
+type SymbolFee struct {
+	Default FeeModel
+	Symbols map[string]FeeModel
+}
+
+// Fee implements FeeModel
+func (s SymbolFee) Fee(trade exch.Trade, balance exch.Balance) (exch.Decimal, string) {
+	if fm, ok := s.Symbols[trade.Symbol]; ok {
+		return fm.Fee(trade, balance)
+	}
+	return s.Default.Fee(trade, balance)
+}
+
+// FeeTier 是阶梯费率中的一级
+// 30 天的成交额达到 Volume 以后，使用这一级的费率
+type FeeTier struct {
+	Volume       exch.Decimal
+	Maker, Taker float64
+}
+
+// TieredFee 会根据最近 30 天的成交额，选择阶梯费率
+// 成交额以 Capital 计价，时间以成交的模拟时间为准
+// 不同 Capital 的 symbol 不要共用一个 TieredFee
+type TieredFee struct {
+	tiers  []FeeTier
+	window time.Duration
+	// dates 和 volumes 记录了 window 内每笔成交的时间和成交额
+	dates   []time.Time
+	volumes []exch.Decimal
+	volume  exch.Decimal
+}
+
+// NewTieredFee 返回一个 TieredFee
+// tiers 中需要有一个 Volume 为 0 的 FeeTier 作为起点
+func NewTieredFee(tiers ...FeeTier) *TieredFee {
+	ts := append([]FeeTier(nil), tiers...)
+	sort.Slice(ts, func(i, j int) bool {
+		return ts[i].Volume < ts[j].Volume
+	})
+	if len(ts) == 0 || ts[0].Volume != 0 {
+		panic("NewTieredFee: 需要 Volume 为 0 的 FeeTier")
+	}
+	return &TieredFee{
+		tiers:  ts,
+		window: 30 * 24 * time.Hour,
+	}
+}
+
+// Fee implements FeeModel
+// 本次成交的费率由之前 30 天的成交额决定，本次的成交额计入之后的成交
+func (t *TieredFee) Fee(trade exch.Trade, balance exch.Balance) (exch.Decimal, string) {
+	t.slide(trade.Date)
+	tier := t.tier()
+	t.dates = append(t.dates, trade.Date)
+	notional := trade.Quantity.Mul(trade.Price)
+	t.volumes = append(t.volumes, notional)
+	t.volume += notional
+	return MakerTaker{Maker: tier.Maker, Taker: tier.Taker}.Fee(trade, balance)
+}
+
+// Volume 返回截止到 now 的 30 天成交额
+func (t *TieredFee) Volume(now time.Time) exch.Decimal {
+	t.slide(now)
+	return t.volume
+}
+
+// slide 会移除 now 之前 30 天以外的成交记录
+func (t *TieredFee) slide(now time.Time) {
+	begin := now.Add(-t.window)
+	i := 0
+	for i < len(t.dates) && !t.dates[i].After(begin) {
+		t.volume -= t.volumes[i]
+		i++
+	}
+	t.dates, t.volumes = t.dates[i:], t.volumes[i:]
+	if len(t.dates) == 0 {
+		t.volume = 0
+	}
+}
+
+func (t *TieredFee) tier() FeeTier {
+	i := sort.Search(len(t.tiers), func(i int) bool {
+		return t.tiers[i].Volume > t.volume
+	})
+	return t.tiers[i-1]
+}
+
+// DiscountFee 会使用 Asset 按照 Discount 的折扣支付 Base 计算出的手续费
+// 例如，在 binance 使用 BNB 支付手续费，可以享受 25% 的折扣
+// Price 需要返回 asset 以 capital 计价的价格
+// 无法获取价格，或者 Asset 的可用余额不足时，按照 Base 的方式支付手续费
+type DiscountFee struct {
+	Base     FeeModel
+	Asset    string
+	Discount float64
+	Price    func(asset, capital string) (float64, bool)
+}
+
+// Fee implements FeeModel
+func (d DiscountFee) Fee(trade exch.Trade, balance exch.Balance) (exch.Decimal, string) {
+	fee, asset := d.Base.Fee(trade, balance)
+	// 把手续费换算成 Capital
+	value := fee
+	switch asset {
+	case trade.CapitalName:
+	case trade.AssetName:
+		value = fee.Mul(trade.Price)
+	default:
+		return fee, asset
+	}
+	price, ok := d.Price(d.Asset, trade.CapitalName)
+	if !ok || price <= 0 {
+		return fee, asset
+	}
+	amount := value.MulFloat((1 - d.Discount) / price)
+	if balance[d.Asset].Free < amount {
+		return fee, asset
+	}
+	return amount, d.Asset
+}
@@ -0,0 +1,146 @@
+package backtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jujili/exch"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestTrade(side exch.OrderSide, isMaker bool) exch.Trade {
+	return exch.Trade{
+		Symbol:      "BTCUSDT",
+		AssetName:   "BTC",
+		CapitalName: "USDT",
+		Side:        side,
+		Price:       exch.NewDecimal(10000),
+		Quantity:    exch.NewDecimal(2),
+		Date:        time.Now(),
+		IsMaker:     isMaker,
+	}
+}
+
+func Test_MakerTaker(t *testing.T) {
+	Convey("MakerTaker 从收到的资产中扣除手续费", t, func() {
+		fm := MakerTaker{Maker: 0.001, Taker: 0.002}
+		Convey("BUY 收到的是 Asset", func() {
+			fee, asset := fm.Fee(newTestTrade(exch.BUY, true), nil)
+			So(fee, ShouldEqual, exch.NewDecimal(0.002))
+			So(asset, ShouldEqual, "BTC")
+		})
+		Convey("SELL 收到的是 Capital", func() {
+			fee, asset := fm.Fee(newTestTrade(exch.SELL, false), nil)
+			So(fee, ShouldEqual, exch.NewDecimal(40))
+			So(asset, ShouldEqual, "USDT")
+		})
+	})
+}
+
+func Test_SymbolFee(t *testing.T) {
+	Convey("SymbolFee 会按照 symbol 选择 FeeModel", t, func() {
+		fm := SymbolFee{
+			Default: MakerTaker{Maker: 0.001, Taker: 0.001},
+			Symbols: map[string]FeeModel{
+				"BTCUSDT": MakerTaker{},
+			},
+		}
+		trade := newTestTrade(exch.BUY, true)
+		fee, _ := fm.Fee(trade, nil)
+		So(fee, ShouldEqual, exch.NewDecimal(0))
+		trade.Symbol = "ETHUSDT"
+		fee, _ = fm.Fee(trade, nil)
+		So(fee, ShouldEqual, exch.NewDecimal(0.002))
+	})
+}
+
+func Test_TieredFee(t *testing.T) {
+	Convey("TieredFee 会根据 30 天的成交额选择费率", t, func() {
+		Convey("没有 Volume 为 0 的 FeeTier 会 panic", func() {
+			So(func() { NewTieredFee(FeeTier{Volume: exch.NewDecimal(1)}) }, ShouldPanic)
+		})
+		fm := NewTieredFee(
+			FeeTier{Volume: exch.NewDecimal(50000), Maker: 0.0005, Taker: 0.0005},
+			FeeTier{Volume: 0, Maker: 0.001, Taker: 0.001},
+		)
+		trade := newTestTrade(exch.SELL, true)
+		date := trade.Date
+		fee, _ := fm.Fee(trade, nil)
+		So(fee, ShouldEqual, exch.NewDecimal(20))
+		So(fm.Volume(date), ShouldEqual, exch.NewDecimal(20000))
+		trade.Quantity = exch.NewDecimal(3)
+		fee, _ = fm.Fee(trade, nil)
+		So(fee, ShouldEqual, exch.NewDecimal(30))
+		So(fm.Volume(date), ShouldEqual, exch.NewDecimal(50000))
+		Convey("成交额达到下一级后，使用下一级的费率", func() {
+			fee, _ = fm.Fee(trade, nil)
+			So(fee, ShouldEqual, exch.NewDecimal(15))
+		})
+		Convey("30 天以前的成交额不再计算在内", func() {
+			trade.Date = date.Add(31 * 24 * time.Hour)
+			fee, _ = fm.Fee(trade, nil)
+			So(fee, ShouldEqual, exch.NewDecimal(30))
+			So(fm.Volume(trade.Date), ShouldEqual, exch.NewDecimal(30000))
+		})
+	})
+}
+
+func Test_DiscountFee(t *testing.T) {
+	Convey("DiscountFee 会使用第三种资产支付手续费", t, func() {
+		fm := DiscountFee{
+			Base:     MakerTaker{Maker: 0.001, Taker: 0.001},
+			Asset:    "BNB",
+			Discount: 0.25,
+			Price: func(asset, capital string) (float64, bool) {
+				if asset == "BNB" && capital == "USDT" {
+					return 15, true
+				}
+				return 0, false
+			},
+		}
+		balance := exch.NewBalances(exch.NewAsset("BNB", 100, 0))
+		Convey("BUY 时，手续费从 Asset 换算成 BNB", func() {
+			fee, asset := fm.Fee(newTestTrade(exch.BUY, true), balance)
+			So(asset, ShouldEqual, "BNB")
+			So(fee, ShouldEqual, exch.NewDecimal(20*0.75/15))
+		})
+		Convey("SELL 时，手续费从 Capital 换算成 BNB", func() {
+			fee, asset := fm.Fee(newTestTrade(exch.SELL, true), balance)
+			So(asset, ShouldEqual, "BNB")
+			So(fee, ShouldEqual, exch.NewDecimal(20*0.75/15))
+		})
+		Convey("BNB 不足时，按照 Base 支付", func() {
+			balance["BNB"] = exch.NewAsset("BNB", 0.1, 0)
+			fee, asset := fm.Fee(newTestTrade(exch.SELL, true), balance)
+			So(asset, ShouldEqual, "USDT")
+			So(fee, ShouldEqual, exch.NewDecimal(20))
+		})
+		Convey("没有 BNB 价格时，按照 Base 支付", func() {
+			trade := newTestTrade(exch.SELL, true)
+			trade.CapitalName = "BUSD"
+			_, asset := fm.Fee(trade, balance)
+			So(asset, ShouldEqual, "BUSD")
+		})
+	})
+}
+
+func Test_BackTest_fee(t *testing.T) {
+	Convey("BackTest 只对收到的资产收取手续费", t, func() {
+		rec := newRecorder()
+		balance := exch.NewBalances(
+			exch.NewAsset("BTC", 10, 0),
+			exch.NewAsset("USDT", 100000, 0),
+		)
+		bt := newBackTest(rec, balance, WithFeeModel(MakerTaker{Maker: 0.001, Taker: 0.002}))
+		BtcUsdtOrder := exch.NewOrder("BTCUSDT", "BTC", "USDT")
+		bt.onTick(exch.NewTick(1, time.Now(), 100, 10))
+		lb := de(BtcUsdtOrder.With(exch.Limit(exch.BUY, 10, 90)))
+		bt.onOrder(lb)
+		bt.onTick(exch.NewTick(2, time.Now(), 90, 10))
+		So(bt.bm.Balance["USDT"], ShouldResemble, exch.NewAsset("USDT", 99100, 0))
+		So(bt.bm.Balance["BTC"], ShouldResemble, exch.NewAsset("BTC", 19.99, 0))
+		trade := exch.DecTradeFunc()(rec.topic("traded")[0].Payload)
+		So(trade.Fee, ShouldEqual, exch.NewDecimal(0.01))
+		So(trade.FeeAsset, ShouldEqual, "BTC")
+	})
+}
@@ -0,0 +1,379 @@
+package backtest
+
+import (
+	"io"
+	"sort"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/jujili/exch"
+)
+
+// Strategy 是在 Kernel 中运行的策略
+// Kernel 在同一个 goroutine 中依次调用 Strategy 的方法，所以 Strategy 不需要加锁
+// 在这些方法中，可以通过 k 提交和撤销订单
+type Strategy interface {
+	// OnBar 会在 bar 结束以后被调用
+	OnBar(k *Kernel, bar exch.Bar)
+	// OnOrder 会在订单的状态发生变化后被调用
+	OnOrder(k *Kernel, order exch.Order)
+	// OnTrade 会在订单每次成交后被调用
+	OnTrade(k *Kernel, trade exch.Trade)
+	// OnCancelResult 会在收到撤单回报后被调用
+	OnCancelResult(k *Kernel, result exch.CancelResult)
+	// OnTick 会在 tick 处理完毕后被调用
+	OnTick(k *Kernel, tick exch.Tick)
+}
+
+// NopStrategy 的方法什么也不做
+// 嵌入 NopStrategy 以后，只需要实现自己关心的方法
+type NopStrategy struct{}
+
+// OnBar 什么也不做
+func (NopStrategy) OnBar(*Kernel, exch.Bar) {}
+
+// OnOrder 什么也不做
+func (NopStrategy) OnOrder(*Kernel, exch.Order) {}
+
+// OnTrade 什么也不做
+func (NopStrategy) OnTrade(*Kernel, exch.Trade) {}
+
+// OnCancelResult 什么也不做
+func (NopStrategy) OnCancelResult(*Kernel, exch.CancelResult) {}
+
+// OnTick 什么也不做
+func (NopStrategy) OnTick(*Kernel, exch.Tick) {}
+
+// Equity 是 Kernel 记录的 balance 的总价值
+type Equity struct {
+	Date time.Time
+	// Total 是 balance 按照当时的价格计算的总价值
+	Total float64
+	// Price 是当时 asset 的价格
+	Price float64
+}
+
+// event 是 BackTest 发布的一条 message
+type event struct {
+	topic string
+	msg   *message.Message
+}
+
+// eventQueue 是 Kernel 交给 BackTest 的 Publisher
+// 它只会把 message 按照发布的顺序存起来，等待 Kernel 处理
+type eventQueue struct {
+	events []event
+}
+
+func (q *eventQueue) Publish(topic string, msgs ...*message.Message) error {
+	for _, msg := range msgs {
+		q.events = append(q.events, event{topic: topic, msg: msg})
+	}
+	return nil
+}
+
+func (q *eventQueue) Close() error {
+	return nil
+}
+
+// pop 返回最早的 event，没有的话，ok 为 false
+func (q *eventQueue) pop() (e event, ok bool) {
+	if len(q.events) == 0 {
+		return event{}, false
+	}
+	e = q.events[0]
+	q.events[0] = event{}
+	q.events = q.events[1:]
+	return e, true
+}
+
+// Kernel 是单线程的回测内核，不需要 Pubsub，也不会启动 goroutine
+// NewBackTest、TickBarService 和 BalanceService 分别在自己的 goroutine 中订阅 "tick"，
+// 订单、成交、balance 和 bar 的先后顺序每次运行都可能不同
+// Kernel 按照固定的顺序处理每一个 tick，相同的输入总是得到相同的结果：
+//  1. 生成这个 tick 结束的 bar
+//  2. 与 BackTest 一样撮合订单
+//  3. 在每天的开始记录 balance 的总价值，再用 tick 的价格更新 asset 的价格
+//  4. 依次调用策略的 OnBar，按照发生的顺序调用 OnOrder、OnTrade 和 OnCancelResult，最后调用 OnTick
+//
+// 策略在回调中提交的订单，会立即被受理，并从下一个 tick 开始撮合
+// 受理和撤单的回报，会在这个回调返回后，继续回调给策略
+type Kernel struct {
+	bt       *BackTest
+	queue    *eventQueue
+	strategy Strategy
+	// decs 是每个话题的解码函数
+	decs map[string]func(*message.Message, interface{}) error
+	//
+	begin     exch.BeginFunc
+	intervals []time.Duration
+	// 每个 Symbol 的每个 interval 都有自己的 gtb
+	gtbs    map[string][]func(exch.Tick) []exch.Bar
+	symbols []string
+	//
+	prices map[string]float64
+	asset  string
+	// total 是处理完最新的 tick 以后，balance 的总价值
+	total float64
+	// dayBegin 决定了每天的开始时间，day 是最新的 tick 所在的那一天的开始时间
+	dayBegin exch.BeginFunc
+	day      time.Time
+	equity   []Equity
+	// newID 不为 nil 时，会为提交的订单重新生成 ID
+	newID func() int64
+	//
+	options []func(*BackTest)
+}
+
+// WithBars 会让 Kernel 为每个 Symbol 生成 intervals 中各种宽度的 bar，
+// 并回调策略的 OnBar，begin 决定了 bar 的开始时间
+// 默认不生成 bar
+func WithBars(begin exch.BeginFunc, intervals ...time.Duration) func(*Kernel) {
+	return func(k *Kernel) {
+		k.begin = begin
+		k.intervals = intervals
+	}
+}
+
+// WithBackTest 会用 options 修改 Kernel 中 BackTest 的默认设置，例如 WithFeeModel 和 WithSlippage
+func WithBackTest(options ...func(*BackTest)) func(*Kernel) {
+	return func(k *Kernel) {
+		k.options = append(k.options, options...)
+	}
+}
+
+// WithEquityBegin 会让 Kernel 在 begin 计算的每天的开始时间记录 balance 的总价值
+// 默认与 BalanceService 的时钟一样，在本地时区的零点记录
+// 例如 WithEquityBegin(exch.CalendarBegin(time.UTC, 0, time.Monday)) 会在 UTC 的零点记录
+func WithEquityBegin(begin exch.BeginFunc) func(*Kernel) {
+	return func(k *Kernel) {
+		k.dayBegin = begin
+	}
+}
+
+// WithIDFunc 会让 Kernel 用 newID 为策略提交的订单重新生成 ID
+// 例如 WithIDFunc(NextIDFunc()) 会让订单 ID 从 1 开始连续递增，
+// 每个 Kernel 都有自己的 newID，不需要替换 exch.NewOrderID
+// 默认使用订单自己的 ID
+func WithIDFunc(newID func() int64) func(*Kernel) {
+	return func(k *Kernel) {
+		k.newID = newID
+	}
+}
+
+// NewKernel 返回一个运行 strategy 的 Kernel，balance 是初始的帐户
+// 与 BalanceService 一样，prices 里面需要放好各种资产的价格，不要忘记 capital 的价格是 1，
+// tick 的价格会作为 asset 的价格，没有价格的资产不计入总价值。Kernel 会复制 balance 和 prices，不会修改它们
+func NewKernel(balance exch.Balance, prices map[string]float64, asset string, strategy Strategy, options ...func(*Kernel)) *Kernel {
+	k := &Kernel{
+		queue:    &eventQueue{},
+		strategy: strategy,
+		decs:     make(map[string]func(*message.Message, interface{}) error, 4),
+		gtbs:     make(map[string][]func(exch.Tick) []exch.Bar, 64),
+		prices:   make(map[string]float64, len(prices)),
+		asset:    asset,
+		dayBegin: exch.CalendarBegin(time.Local, 0, time.Monday),
+	}
+	for name, price := range prices {
+		k.prices[name] = price
+	}
+	for _, option := range options {
+		option(k)
+	}
+	k.bt = newBackTest(k.queue, cloneBalance(balance), k.options...)
+	return k
+}
+
+// Run 会按照顺序处理 r 中的全部 tick，r 读完以后，会调用 Finish
+// r 返回 io.EOF 以外的错误时，会直接返回这个错误
+func (k *Kernel) Run(r exch.TickReader) error {
+	for {
+		tick, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		k.Feed(tick)
+	}
+	k.Finish()
+	return nil
+}
+
+// Feed 会处理一个 tick，tick 需要按照时间的顺序输入
+func (k *Kernel) Feed(tick exch.Tick) {
+	// 在 Feed 以前提交的订单的回报
+	k.dispatch()
+	bars := k.bars(tick)
+	k.bt.onTick(tick)
+	k.account(tick)
+	for _, bar := range bars {
+		k.strategy.OnBar(k, bar)
+		k.dispatch()
+	}
+	k.dispatch()
+	k.strategy.OnTick(k, tick)
+	k.dispatch()
+}
+
+// Finish 会逼出每个 Symbol 的最后一个 bar，并记录最后的 balance 的总价值
+func (k *Kernel) Finish() {
+	k.dispatch()
+	for _, bar := range k.bars(exch.NilTick) {
+		k.strategy.OnBar(k, bar)
+		k.dispatch()
+	}
+	if !k.bt.now.IsZero() {
+		k.equity = append(k.equity, Equity{Date: k.bt.now, Total: k.total, Price: k.prices[k.asset]})
+	}
+}
+
+// bars 返回 tick 结束的 bar，tick 为 exch.NilTick 时，返回每个 Symbol 的最后一个 bar
+// 按照 Symbol 出现的顺序和 intervals 的顺序排列
+func (k *Kernel) bars(tick exch.Tick) []exch.Bar {
+	if len(k.intervals) == 0 {
+		return nil
+	}
+	if tick == exch.NilTick {
+		res := make([]exch.Bar, 0, len(k.symbols)*len(k.intervals))
+		for _, symbol := range k.symbols {
+			for _, gtb := range k.gtbs[symbol] {
+				res = append(res, gtb(tick)...)
+			}
+		}
+		return res
+	}
+	gtb, has := k.gtbs[tick.Symbol]
+	if !has {
+		gtb = make([]func(exch.Tick) []exch.Bar, len(k.intervals))
+		for i, interval := range k.intervals {
+			gtb[i] = exch.GenTickBarFunc(k.begin, interval)
+		}
+		k.gtbs[tick.Symbol] = gtb
+		k.symbols = append(k.symbols, tick.Symbol)
+	}
+	var res []exch.Bar
+	for _, g := range gtb {
+		res = append(res, g(tick)...)
+	}
+	return res
+}
+
+// account 会在 tick 跨过一天的开始时，记录那时的 balance 的总价值，
+// 那时的价值，就是处理完上一个 tick 以后的价值
+// 然后，用 tick 的价格更新 asset 的价格，并重新计算总价值
+func (k *Kernel) account(tick exch.Tick) {
+	day := k.dayBegin(tick.Date, exch.Day)
+	if k.day.IsZero() {
+		k.day = day
+	}
+	for k.day.Before(day) {
+		// 切换夏令时的那一天有 23 或者 25 个小时，所以从一天半以后找下一天的开始
+		k.day = k.dayBegin(k.day.Add(exch.Day+exch.Day/2), exch.Day)
+		k.equity = append(k.equity, Equity{Date: k.day, Total: k.total, Price: k.prices[k.asset]})
+	}
+	k.prices[k.asset] = tick.Price.Float64()
+	k.total = total(k.bt.bm.Balance, k.prices)
+}
+
+// total 返回 b 按照 prices 计算的总价值
+// 与 exch.Balance.Total 不同，没有价格的资产会被跳过，而不是 panic
+// 按照资产名称的顺序累加，让浮点数的结果是确定的
+func total(b exch.Balance, prices map[string]float64) float64 {
+	names := make([]string, 0, len(b))
+	for name := range b {
+		if _, ok := prices[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var res float64
+	for _, name := range names {
+		res += b[name].Total().Float64() * prices[name]
+	}
+	return res
+}
+
+// dispatch 会按照发布的顺序，把 BackTest 的回报回调给策略
+// 策略在回调中产生的新回报，也会在这里处理完
+func (k *Kernel) dispatch() {
+	for {
+		e, ok := k.queue.pop()
+		if !ok {
+			return
+		}
+		if e.topic == "balance" {
+			// balance 的变化可以通过 Balance 方法查询
+			continue
+		}
+		dec, has := k.decs[e.topic]
+		if !has {
+			dec = exch.DecMsgFunc()
+			k.decs[e.topic] = dec
+		}
+		// message 是 BackTest 自己编码的，不会解码失败
+		switch e.topic {
+		case "orderUpdate":
+			var o exch.Order
+			dec(e.msg, &o)
+			k.strategy.OnOrder(k, o)
+		case "traded":
+			var t exch.Trade
+			dec(e.msg, &t)
+			k.strategy.OnTrade(k, t)
+		case "cancelResult":
+			var r exch.CancelResult
+			dec(e.msg, &r)
+			k.strategy.OnCancelResult(k, r)
+		}
+	}
+}
+
+// Submit 会提交订单 o，并返回 o 在交易中心的 ID
+func (k *Kernel) Submit(o exch.Order) int64 {
+	if k.newID != nil {
+		o.ID = k.newID()
+	}
+	k.bt.onOrder(&order{Order: o})
+	return o.ID
+}
+
+// Cancel 会撤销 ID 为 id 的订单
+func (k *Kernel) Cancel(id int64) {
+	k.bt.cancelOrder(id)
+}
+
+// CancelSymbol 会撤销 symbol 的全部订单
+func (k *Kernel) CancelSymbol(symbol string) {
+	k.bt.cancelSymbolOrders(symbol)
+}
+
+// CancelAll 会撤销全部的订单
+func (k *Kernel) CancelAll() {
+	k.bt.cancelAllOrders()
+}
+
+// Now 返回最新的 tick 的时间，也就是回测中的当前时间
+func (k *Kernel) Now() time.Time {
+	return k.bt.now
+}
+
+// Balance 返回当前 balance 的副本
+func (k *Kernel) Balance() exch.Balance {
+	return cloneBalance(k.bt.bm.Balance)
+}
+
+// cloneBalance 返回 b 的副本，因为 exch.Balance.Add 会修改 b 自己
+func cloneBalance(b exch.Balance) exch.Balance {
+	res := make(exch.Balance, len(b))
+	for name, asset := range b {
+		res[name] = asset
+	}
+	return res
+}
+
+// Equity 返回记录下来的 balance 的总价值
+func (k *Kernel) Equity() []Equity {
+	return k.equity
+}
@@ -0,0 +1,132 @@
+package backtest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jujili/exch"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// barTrader 会在每个 bar 结束时，交替地提交数量为 1 的 MARKET 买单和卖单
+// logs 按照回调的顺序记录了收到的内容
+type barTrader struct {
+	NopStrategy
+	count int
+	logs  []string
+	// ids 是 Submit 返回的订单 ID
+	ids []int64
+}
+
+func (s *barTrader) OnBar(k *Kernel, bar exch.Bar) {
+	s.logs = append(s.logs, fmt.Sprintf("bar %s %v", bar.Begin.Format(time.RFC3339), bar.Close))
+	side := exch.BUY
+	if s.count%2 == 1 {
+		side = exch.SELL
+	}
+	s.count++
+	id := k.Submit(*exch.NewOrder("BTCUSDT", "BTC", "USDT").With(exch.Market(side, 1)))
+	s.ids = append(s.ids, id)
+}
+
+func (s *barTrader) OnOrder(k *Kernel, o exch.Order) {
+	s.logs = append(s.logs, fmt.Sprintf("order %d %s", o.ID, o.Status))
+}
+
+func (s *barTrader) OnTrade(k *Kernel, t exch.Trade) {
+	s.logs = append(s.logs, fmt.Sprintf("trade %d %s %s", t.OrderID, t.Price, t.Quantity))
+}
+
+func (s *barTrader) OnTick(k *Kernel, tick exch.Tick) {
+	s.logs = append(s.logs, fmt.Sprintf("tick %d", tick.ID))
+}
+
+func Test_Kernel(t *testing.T) {
+	Convey("Kernel 会按照固定的顺序处理 tick", t, func() {
+		balance := exch.NewBalances(
+			exch.NewAsset("BTC", 10, 0),
+			exch.NewAsset("USDT", 1000000, 0),
+		)
+		prices := map[string]float64{"USDT": 1}
+		begin := time.Date(2021, 5, 14, 0, 0, 0, 0, time.UTC)
+		// 3 天的 tick，每 10 分钟一个
+		ticks := make([]exch.Tick, 3*144)
+		for i := range ticks {
+			price := float64(10000 + i%7*10)
+			ticks[i] = exch.NewTick(int64(i), begin.Add(time.Duration(i)*10*time.Minute), price, 1, exch.TickSymbol("BTCUSDT"))
+		}
+		run := func() (*Kernel, *barTrader) {
+			s := &barTrader{}
+			source := make(sliceTicks, len(ticks))
+			copy(source, ticks)
+			k := NewKernel(balance, prices, "BTC", s, WithBars(exch.Begin, time.Hour), WithIDFunc(NextIDFunc()),
+				WithEquityBegin(exch.CalendarBegin(time.UTC, 0, time.Monday)))
+			So(k.Run(&source), ShouldBeNil)
+			return k, s
+		}
+		k, s := run()
+		Convey("bar 先于撮合的回报，策略提交的订单从下一个 tick 开始撮合", func() {
+			// 第 6 个 tick 结束了第一个 bar
+			So(s.logs[:9], ShouldResemble, []string{
+				"tick 0", "tick 1", "tick 2", "tick 3", "tick 4", "tick 5",
+				"bar 2021-05-14T00:00:00Z 10050",
+				"order 1 NEW",
+				"tick 6",
+			})
+			So(s.logs[9:13], ShouldResemble, []string{
+				"trade 1 10000 0.0001",
+				"order 1 FILLED",
+				"tick 7",
+				"tick 8",
+			})
+		})
+		Convey("WithIDFunc 会为提交的订单重新生成 ID", func() {
+			So(s.ids[:3], ShouldResemble, []int64{1, 2, 3})
+		})
+		Convey("每天的凌晨和最后一个 tick 都会记录 balance 的总价值", func() {
+			es := k.Equity()
+			So(len(es), ShouldEqual, 3)
+			So(es[0].Date, ShouldEqual, begin.Add(exch.Day))
+			So(es[1].Date, ShouldEqual, begin.Add(2*exch.Day))
+			So(es[2].Date, ShouldEqual, ticks[len(ticks)-1].Date)
+			bal := k.Balance()
+			So(es[2].Total, ShouldEqual, bal.Total(map[string]float64{"USDT": 1, "BTC": es[2].Price}))
+		})
+		Convey("WithEquityBegin 决定了每天的开始时间", func() {
+			cst := time.FixedZone("CST", 8*60*60)
+			source := make(sliceTicks, len(ticks))
+			copy(source, ticks)
+			k := NewKernel(balance, prices, "BTC", NopStrategy{}, WithEquityBegin(exch.CalendarBegin(cst, 0, time.Monday)))
+			So(k.Run(&source), ShouldBeNil)
+			es := k.Equity()
+			So(len(es), ShouldEqual, 4)
+			for i, e := range es[:3] {
+				So(e.Date.Equal(time.Date(2021, 5, 15+i, 0, 0, 0, 0, cst)), ShouldBeTrue)
+			}
+		})
+		Convey("没有价格的资产不计入总价值", func() {
+			withETH := cloneBalance(balance)
+			withETH["ETH"] = exch.NewAsset("ETH", 100, 0)
+			source := make(sliceTicks, len(ticks))
+			copy(source, ticks)
+			k := NewKernel(withETH, prices, "BTC", NopStrategy{}, WithEquityBegin(exch.CalendarBegin(time.UTC, 0, time.Monday)))
+			So(k.Run(&source), ShouldBeNil)
+			es := k.Equity()
+			last := es[len(es)-1]
+			So(last.Total, ShouldEqual, 1000000+10*last.Price)
+		})
+		Convey("不会修改输入的 balance 和 prices", func() {
+			So(balance["BTC"].Free, ShouldEqual, exch.NewDecimal(10))
+			So(len(prices), ShouldEqual, 1)
+		})
+		Convey("相同的输入，总是得到相同的结果", func() {
+			for i := 0; i < 3; i++ {
+				k2, s2 := run()
+				So(s2.logs, ShouldResemble, s.logs)
+				So(k2.Equity(), ShouldResemble, k.Equity())
+				So(k2.Balance(), ShouldResemble, k.Balance())
+			}
+		})
+	})
+}
@@ -0,0 +1,98 @@
+package backtest
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/jujili/exch"
+)
+
+// LatencyModel 会计算订单从提交到被交易中心受理的延迟
+// 延迟是模拟的时间
+type LatencyModel interface {
+	Delay(o exch.Order) time.Duration
+}
+
+// FixedLatency 让所有的订单都有相同的延迟
+type FixedLatency time.Duration
+
+// Delay implements LatencyModel
+func (f FixedLatency) Delay(o exch.Order) time.Duration {
+	return time.Duration(f)
+}
+
+// RandomLatency 的延迟在 [Min, Max) 中均匀分布
+// 随机数来自 seed，相同的 seed 会得到相同的回测结果
+type RandomLatency struct {
+	Min, Max time.Duration
+	rand     *rand.Rand
+}
+
+// NewRandomLatency 返回一个 RandomLatency
+func NewRandomLatency(min, max time.Duration, seed int64) *RandomLatency {
+	if max < min {
+		panic("NewRandomLatency: max 不能小于 min")
+	}
+	return &RandomLatency{
+		Min:  min,
+		Max:  max,
+		rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Delay implements LatencyModel
+func (r *RandomLatency) Delay(o exch.Order) time.Duration {
+	if r.Max == r.Min {
+		return r.Min
+	}
+	return r.Min + time.Duration(r.rand.Int63n(int64(r.Max-r.Min)))
+}
+
+// delayed 是还在路上的订单
+// 模拟时间到达 live 以后，订单才会被受理
+type delayed struct {
+	order *order
+	live  time.Time
+}
+
+// delayQueue 中的订单按照 live 升序排列
+// live 相同的订单，按照提交的先后排列
+type delayQueue struct {
+	items []delayed
+}
+
+func (q *delayQueue) push(o *order, live time.Time) {
+	i := len(q.items)
+	for i > 0 && q.items[i-1].live.After(live) {
+		i--
+	}
+	q.items = append(q.items, delayed{})
+	copy(q.items[i+1:], q.items[i:])
+	q.items[i] = delayed{order: o, live: live}
+}
+
+// popUntil 会取出所有 live 不晚于 date 的订单
+func (q *delayQueue) popUntil(date time.Time) []delayed {
+	i := 0
+	for i < len(q.items) && !q.items[i].live.After(date) {
+		i++
+	}
+	res := q.items[:i:i]
+	q.items = q.items[i:]
+	return res
+}
+
+// removeIf 会从 q 中移除所有让 fn 返回 true 的订单，并返回这些订单
+func (q *delayQueue) removeIf(fn func(*order) bool) []*order {
+	res := make([]*order, 0, 4)
+	items := q.items[:0]
+	for _, d := range q.items {
+		if fn(d.order) {
+			res = append(res, d.order)
+			continue
+		}
+		items = append(items, d)
+	}
+	q.items = items
+	return res
+}
@@ -0,0 +1,96 @@
+package backtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jujili/exch"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_LatencyModel(t *testing.T) {
+	Convey("LatencyModel", t, func() {
+		o := exch.NewOrder("BTCUSDT", "BTC", "USDT")
+		Convey("FixedLatency 总是返回相同的延迟", func() {
+			So(FixedLatency(time.Second).Delay(o), ShouldEqual, time.Second)
+		})
+		Convey("RandomLatency 的延迟在 [Min, Max) 中", func() {
+			delays := func(seed int64) []time.Duration {
+				rl := NewRandomLatency(time.Second, 2*time.Second, seed)
+				res := make([]time.Duration, 0, 10)
+				for i := 0; i < 10; i++ {
+					d := rl.Delay(o)
+					So(d, ShouldBeGreaterThanOrEqualTo, time.Second)
+					So(d, ShouldBeLessThan, 2*time.Second)
+					res = append(res, d)
+				}
+				return res
+			}
+			So(delays(1), ShouldResemble, delays(1))
+			So(delays(1), ShouldNotResemble, delays(2))
+			Convey("max 小于 min 会 panic", func() {
+				So(func() { NewRandomLatency(time.Second, 0, 1) }, ShouldPanic)
+			})
+		})
+	})
+}
+
+func Test_delayQueue(t *testing.T) {
+	Convey("delayQueue 按照 live 的先后排列", t, func() {
+		var q delayQueue
+		now := time.Now()
+		o1, o2, o3 := &order{}, &order{}, &order{}
+		o1.ID, o2.ID, o3.ID = 1, 2, 3
+		q.push(o1, now.Add(2*time.Second))
+		q.push(o2, now.Add(time.Second))
+		q.push(o3, now.Add(2*time.Second))
+		ds := q.popUntil(now.Add(time.Second))
+		So(len(ds), ShouldEqual, 1)
+		So(ds[0].order, ShouldEqual, o2)
+		So(q.removeIf(func(o *order) bool { return o.ID == 3 }), ShouldResemble, []*order{o3})
+		ds = q.popUntil(now.Add(time.Hour))
+		So(len(ds), ShouldEqual, 1)
+		So(ds[0].order, ShouldEqual, o1)
+	})
+}
+
+func Test_BackTest_latency(t *testing.T) {
+	Convey("BackTest 的订单延迟", t, func() {
+		rec := newRecorder()
+		balance := exch.NewBalances(
+			exch.NewAsset("BTC", 10, 0),
+			exch.NewAsset("USDT", 100000, 0),
+		)
+		bt := newBackTest(rec, balance, WithLatency(FixedLatency(time.Second)))
+		BtcUsdtOrder := exch.NewOrder("BTCUSDT", "BTC", "USDT")
+		begin := time.Now()
+		bt.onTick(exch.NewTick(1, begin, 100, 10))
+		ms := de(BtcUsdtOrder.With(exch.Market(exch.SELL, 1)))
+		bt.onOrder(ms)
+		So(rec.orderUpdates(), ShouldBeEmpty)
+		So(bt.bm.Balance["BTC"], ShouldResemble, exch.NewAsset("BTC", 10, 0))
+		Convey("延迟时间内的 tick 不会撮合订单", func() {
+			bt.onTick(exch.NewTick(2, begin.Add(500*time.Millisecond), 100, 10))
+			So(rec.topic("traded"), ShouldBeEmpty)
+			Convey("tick 的时间达到延迟以后，订单才被受理并撮合", func() {
+				bt.onTick(exch.NewTick(3, begin.Add(time.Second), 90, 10))
+				us := rec.orderUpdates()
+				So(len(us), ShouldEqual, 2)
+				So(us[0].Status, ShouldEqual, exch.NEW)
+				So(us[0].UpdateTime, ShouldEqual, begin.Add(time.Second))
+				So(us[1].Status, ShouldEqual, exch.FILLED)
+				trade := exch.DecTradeFunc()(rec.topic("traded")[0].Payload)
+				So(trade.Price, ShouldEqual, exch.NewDecimal(90))
+			})
+		})
+		Convey("还没有被受理的订单也可以撤销", func() {
+			bt.cancelOrder(ms.ID)
+			rs := rec.cancelResults()
+			So(len(rs), ShouldEqual, 1)
+			So(rs[0].IsCanceled, ShouldBeTrue)
+			So(bt.bm.Balance["BTC"], ShouldResemble, exch.NewAsset("BTC", 10, 0))
+			bt.onTick(exch.NewTick(2, begin.Add(time.Hour), 100, 10))
+			So(rec.topic("traded"), ShouldBeEmpty)
+		})
+	})
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/gob"
 	"fmt"
-	"math"
 
 	"github.com/jujili/exch"
 )
@@ -15,22 +14,35 @@ type order struct {
 	exch.Order
 	// 指向下一个挂单
 	next *order
+	// isTaker 为 true 表示 LIMIT 订单在提交时就已经与最新价交叉了，
+	// 它的第一次成交是 taker
+	isTaker bool
 }
 
 func (o order) String() string {
 	return fmt.Sprintf("%s->%s", o.Order, o.next)
 }
 
-// DecOrderFunc 返回的函数会把序列化成 []byte 的 Order 值转换回来
-func decOrderFunc() func(bs []byte) *order {
+// decOrderErrFunc 返回的函数会把序列化成 []byte 的 Order 值转换回来
+// bs 无法转换时，返回错误
+func decOrderErrFunc() func(bs []byte) (*order, error) {
 	var buf bytes.Buffer
 	dec := gob.NewDecoder(&buf)
-	return func(bs []byte) *order {
+	return func(bs []byte) (*order, error) {
 		buf.Reset()
 		buf.Write(bs)
 		var order order
-		dec.Decode(&order)
-		return &order
+		err := dec.Decode(&order)
+		return &order, err
+	}
+}
+
+// decOrderFunc 与 decOrderErrFunc 一样，但是会忽略错误
+func decOrderFunc() func(bs []byte) *order {
+	dec := decOrderErrFunc()
+	return func(bs []byte) *order {
+		o, _ := dec(bs)
+		return o
 	}
 }
 
@@ -43,10 +55,10 @@ func (o *order) isLessThan(a *order) bool {
 	if o.Side != a.Side {
 		panic("only compare with the same side")
 	}
-	if o.Type != a.Type {
-		return o.Type < a.Type
+	if o.bookType() != a.bookType() {
+		return o.bookType() < a.bookType()
 	}
-	switch o.Type {
+	switch o.bookType() {
 	case exch.MARKET:
 		return o.ID < a.ID
 	case exch.LIMIT:
@@ -59,31 +71,95 @@ func (o *order) isLessThan(a *order) bool {
 
 // canMatch return true if o < a
 // otherwise return false
-func (o *order) canMatch(price float64) bool {
+func (o *order) canMatch(price exch.Decimal) bool {
 	if o == nil {
 		return false
 	}
-	switch o.Type {
+	switch o.bookType() {
 	case exch.MARKET:
 		// MARKET 总是可以撮合上
 		return true
 	case exch.LIMIT:
-		return o.sidePrice() <= float64(o.Side)*price
+		return o.sidePrice() <= exch.Decimal(o.Side)*price
 	default:
 		panic("现在只能处理 limit 和 market 类型。")
 	}
 }
 
-func (o *order) sidePrice() float64 {
-	return float64(o.Side) * o.AssetPrice
+// canBeHitBy 返回 false 表示挂单 o 与 tick 的主动方在同一边，
+// 例如，主动买入的 tick 是买方吃掉了卖方的挂单，不会与挂着的 BUY 订单成交
+// 不知道主动方的 tick 可以与任何订单成交，taker 订单也总是可以成交
+func (o *order) canBeHitBy(tick exch.Tick) bool {
+	isMaker := o.bookType() == exch.LIMIT && !o.isTaker
+	return !isMaker || tick.Side == 0 || tick.Side != o.Side
+}
+
+func (o *order) sidePrice() exch.Decimal {
+	return exch.Decimal(o.Side) * o.AssetPrice
+}
+
+// bookType 返回 o 在 orderList 中排序和撮合时使用的类型
+// LIMIT_MAKER 订单与 LIMIT 订单一起，按照价格排序和撮合
+func (o *order) bookType() exch.OrderType {
+	if o.Type == exch.LIMITmaker {
+		return exch.LIMIT
+	}
+	return o.Type
+}
+
+// baseType 返回 o 被触发后的订单类型
+// 不是触发类的订单，返回 o.bookType()
+func (o *order) baseType() exch.OrderType {
+	switch o.Type {
+	case exch.STOPloss, exch.TAKEprofit:
+		return exch.MARKET
+	case exch.STOPlossLIMIT, exch.TAKEprofitLIMIT:
+		return exch.LIMIT
+	default:
+		return o.bookType()
+	}
+}
+
+// isStop 返回 true 表示 o 需要等待价格触及 StopPrice 才会被触发
+func (o *order) isStop() bool {
+	switch o.Type {
+	case exch.STOPloss, exch.STOPlossLIMIT, exch.TAKEprofit, exch.TAKEprofitLIMIT:
+		return true
+	default:
+		return false
+	}
+}
+
+// isRising 返回 true 表示 o 会在价格上涨到 StopPrice 时触发
+// 否则，o 会在价格下跌到 StopPrice 时触发
+func (o *order) isRising() bool {
+	switch o.Type {
+	case exch.STOPloss, exch.STOPlossLIMIT:
+		return o.Side == exch.BUY
+	case exch.TAKEprofit, exch.TAKEprofitLIMIT:
+		return o.Side == exch.SELL
+	default:
+		panic("只有 stop loss 和 take profit 类型的订单才能触发")
+	}
+}
+
+// canTrigger 返回 true 表示 price 触及了 o 的触发价格
+func (o *order) canTrigger(price exch.Decimal) bool {
+	if o == nil {
+		return false
+	}
+	if o.isRising() {
+		return price >= o.StopPrice
+	}
+	return price <= o.StopPrice
 }
 
 // 对于每个 tick 总是认为可以撮合成功，形成交易的。
-// 这里没有考虑手续费和滑点。
+// 这里没有考虑手续费和滑点，滑点由 orderList.slip 处理。
 // match 前需要使用 canMatch 进行检查， match 内就不再检查了
 // TODO: 测试空订单
 func (o order) match(tick exch.Tick) (order, exch.Tick, []exch.Asset) {
-	switch o.Type {
+	switch o.bookType() {
 	case exch.MARKET:
 		return matchMarket(o, tick)
 	case exch.LIMIT:
@@ -93,6 +169,65 @@ func (o order) match(tick exch.Tick) (order, exch.Tick, []exch.Asset) {
 	}
 }
 
+// fillQuantity 返回 o 与 t 撮合时，预计成交的 Asset 数量
+// MARKET BUY 订单的数量是 Capital，按照 t.Price 换算
+func (o *order) fillQuantity(t exch.Tick) exch.Decimal {
+	quantity := o.AssetQuantity
+	if o.bookType() == exch.MARKET && o.Side == exch.BUY {
+		quantity = o.CapitalQuantity.Div(t.Price)
+	}
+	return exch.MinDecimal(quantity, t.Volume)
+}
+
+// fill 是一次撮合的结果
+type fill struct {
+	trade  exch.Trade
+	assets []exch.Asset
+	// order 是成交后订单的状态
+	order exch.Order
+}
+
+// newTrade 根据撮合后的 order 和撮合时的 tick，生成成交记录
+// as 是撮合时 Asset 和 Capital 的变化量
+func newTrade(o order, t exch.Tick, as []exch.Asset) exch.Trade {
+	asset := as[0]
+	// BUY 时 asset.Free 增加，SELL 时 asset.Locked 减少
+	quantity := asset.Free - asset.Locked
+	price := t.Price
+	if o.bookType() == exch.LIMIT {
+		// LIMIT 订单总是以 o.AssetPrice 成交
+		price = o.AssetPrice
+	}
+	return exch.Trade{
+		OrderID:     o.ID,
+		Symbol:      o.Symbol,
+		AssetName:   o.AssetName,
+		CapitalName: o.CapitalName,
+		Side:        o.Side,
+		Price:       price,
+		Quantity:    quantity,
+		Date:        t.Date,
+		// LIMIT 订单挂在 orderList 中等待 tick 来成交，所以是 maker
+		// 除非它在提交时就与最新价交叉了
+		// LIMIT_MAKER 订单不可能交叉，所以总是 maker
+		IsMaker: o.bookType() == exch.LIMIT && !o.isTaker,
+	}
+}
+
+// fillWith 会根据 trade 更新 o 的成交数量、成交均价和状态
+func (o *order) fillWith(trade exch.Trade) {
+	amount := o.FilledQuantity.Mul(o.AvgPrice) + trade.Quantity.Mul(trade.Price)
+	o.FilledQuantity += trade.Quantity
+	o.AvgPrice = amount.Div(o.FilledQuantity)
+	o.Status = exch.PARTIALLYfilled
+	if o.IsEmpty() {
+		o.Status = exch.FILLED
+	}
+	o.UpdateTime = trade.Date
+	// 第一次成交以后，剩下的部分就是挂在 orderList 中的 maker 了
+	o.isTaker = false
+}
+
 var matchMarket = func(o order, t exch.Tick) (order, exch.Tick, []exch.Asset) {
 	var asset, capital exch.Asset
 	asset.Name = o.AssetName
@@ -101,16 +236,21 @@ var matchMarket = func(o order, t exch.Tick) (order, exch.Tick, []exch.Asset) {
 		panic("order.Type should be exch.MARKET")
 	}
 	if o.Side == exch.SELL {
-		diff := math.Min(o.AssetQuantity, t.Volume)
+		diff := exch.MinDecimal(o.AssetQuantity, t.Volume)
 		asset.Locked = -diff
-		capital.Free = t.Price * diff
+		capital.Free = t.Price.Mul(diff)
 		t.Volume -= diff
 		o.AssetQuantity -= diff
 	} else {
-		diff := math.Min(o.CapitalQuantity, t.Volume*t.Price)
-		asset.Free = diff / t.Price
+		diff := t.Volume.Mul(t.Price)
+		asset.Free = t.Volume
+		if o.CapitalQuantity < diff {
+			diff = o.CapitalQuantity
+			// 四舍五入后，也不能超过 t.Volume
+			asset.Free = exch.MinDecimal(diff.Div(t.Price), t.Volume)
+		}
 		capital.Locked = -diff
-		t.Volume -= diff / t.Price
+		t.Volume -= asset.Free
 		o.CapitalQuantity -= diff
 	}
 	return o, t, []exch.Asset{asset, capital}
@@ -120,41 +260,45 @@ var matchLimit = func(o order, t exch.Tick) (order, exch.Tick, []exch.Asset) {
 	var asset, capital exch.Asset
 	asset.Name = o.AssetName
 	capital.Name = o.CapitalName
-	if o.Type != exch.LIMIT {
+	if o.bookType() != exch.LIMIT {
 		panic("order.Type should be exch.LIMIT")
 	}
-	if float64(o.Side)*t.Price < o.sidePrice() {
+	if exch.Decimal(o.Side)*t.Price < o.sidePrice() {
 		return o, t, []exch.Asset{asset, capital}
 	}
 	// 处于谨慎的态度，以 o.AssetPrice 的价格成交
-	var diff float64
-	diff = math.Min(o.AssetQuantity, t.Volume)
+	diff := exch.MinDecimal(o.AssetQuantity, t.Volume)
+	// 成交金额是成交前后剩余金额的差值，
+	// 这样多次部分成交的总金额，与挂单时冻结的金额完全一致
+	amount := o.AssetQuantity.Mul(o.AssetPrice) - (o.AssetQuantity - diff).Mul(o.AssetPrice)
 	if o.Side == exch.SELL {
 		asset.Locked = -diff
-		capital.Free = diff * o.AssetPrice
+		capital.Free = amount
 	} else {
 		asset.Free = diff
-		capital.Locked = -diff * o.AssetPrice
+		capital.Locked = -amount
 	}
 	t.Volume -= diff
 	o.AssetQuantity -= diff
 	return o, t, []exch.Asset{asset, capital}
 }
 
+// pend2Lock 返回挂单时需要冻结的资金
+// 触发类订单在挂单时，就按照触发后的类型冻结资金
 func (o *order) pend2Lock() exch.Asset {
-	switch o.Type {
+	switch o.baseType() {
 	case exch.MARKET:
 		return pendMarket(*o)
 	case exch.LIMIT:
 		return pendLimit(*o)
 	default:
-		panic("现在只能处理 limit 和 market 类型")
+		panic("无法处理的订单类型")
 	}
 }
 
 var pendMarket = func(o order) exch.Asset {
 	var res exch.Asset
-	if o.Type != exch.MARKET {
+	if o.baseType() != exch.MARKET {
 		panic("pendMarket 应该输入 MARKET 类型的 order")
 	}
 	if o.Side == exch.BUY {
@@ -171,12 +315,12 @@ var pendMarket = func(o order) exch.Asset {
 
 var pendLimit = func(o order) exch.Asset {
 	var res exch.Asset
-	if o.Type != exch.LIMIT {
+	if o.baseType() != exch.LIMIT {
 		panic("pendLimit 应该输入 LIMIT 类型的 order")
 	}
 	if o.Side == exch.BUY {
 		res.Name = o.CapitalName
-		total := o.AssetQuantity * o.AssetPrice
+		total := o.AssetQuantity.Mul(o.AssetPrice)
 		res.Free = -total
 		res.Locked = total
 	} else { // o.Side == exch.SELL
@@ -187,20 +331,21 @@ var pendLimit = func(o order) exch.Asset {
 	return res
 }
 
+// cancel2Free 返回撤单时需要释放的资金
 func (o *order) cancel2Free() exch.Asset {
-	switch o.Type {
+	switch o.baseType() {
 	case exch.MARKET:
 		return cancelMarket(*o)
 	case exch.LIMIT:
 		return cancelLimit(*o)
 	default:
-		panic("现在只能处理 limit 和 market 类型")
+		panic("无法处理的订单类型")
 	}
 }
 
 var cancelMarket = func(o order) exch.Asset {
 	var res exch.Asset
-	if o.Type != exch.MARKET {
+	if o.baseType() != exch.MARKET {
 		panic("cancelMarket 应该输入 MARKET 类型的 order")
 	}
 	if o.Side == exch.BUY {
@@ -217,12 +362,12 @@ var cancelMarket = func(o order) exch.Asset {
 
 var cancelLimit = func(o order) exch.Asset {
 	var res exch.Asset
-	if o.Type != exch.LIMIT {
+	if o.baseType() != exch.LIMIT {
 		panic("cancelLimit 应该输入 LIMIT 类型的 order")
 	}
 	if o.Side == exch.BUY {
 		res.Name = o.CapitalName
-		total := o.AssetQuantity * o.AssetPrice
+		total := o.AssetQuantity.Mul(o.AssetPrice)
 		res.Free = total
 		res.Locked = -total
 	} else { // o.Side == exch.SELL
@@ -1,13 +1,17 @@
 package backtest
 
 import (
-	"log"
-
 	"github.com/jujili/exch"
 )
 
 type orderList struct {
 	head *order
+	// less 决定了 l 中订单的排列顺序
+	less func(*order, *order) bool
+	// slippage 不为 nil 时，match 会用它调整每个订单的成交价
+	slippage SlippageModel
+	// step 不为 0 时，每次成交的数量都是 step 的整数倍
+	step exch.Decimal
 }
 
 func (l orderList) String() string {
@@ -22,28 +26,66 @@ func newOrderList() *orderList {
 		// 因为根本不会查看 head 内部的数据
 		// head 完全可以是一个空的
 		head: &order{},
+		less: (*order).isLessThan,
+	}
+}
+
+// newStopList 返回的 orderList 用于存放等待触发的订单
+// 订单按照触发的先后顺序排列，所以
+// rising 为 true 时，StopPrice 低的订单在前面
+// rising 为 false 时，StopPrice 高的订单在前面
+// StopPrice 相同时，按照 ID 升序排列
+func newStopList(rising bool) *orderList {
+	side := exch.Decimal(1)
+	if !rising {
+		side = -1
+	}
+	return &orderList{
+		head: &order{},
+		less: func(o, a *order) bool {
+			return side*o.StopPrice < side*a.StopPrice ||
+				(o.StopPrice == a.StopPrice && o.ID < a.ID)
+		},
 	}
 }
 
 func (l *orderList) push(a *order) exch.Asset {
 	curr, next := l.head, l.head.next
-	for next.isLessThan(a) {
+	for next != nil && l.less(next, a) {
 		curr, next = next, next.next
 	}
 	curr.next, a.next = a, next
 	return a.pend2Lock()
 }
 
-func (l *orderList) remove(a *order) exch.Asset {
+// remove 会从 l 中移除 ID 为 id 的订单，并返回这个订单
+// l 中没有这个订单的话，返回 nil
+func (l *orderList) remove(id int64) *order {
 	curr, next := l.head, l.head.next
-	for next != nil && next.ID != a.ID {
+	for next != nil && next.ID != id {
 		curr, next = next, next.next
 	}
 	if next == nil {
-		return exch.NewAsset(a.AssetName, 0, 0)
+		return nil
 	}
-	curr.next = next.next
-	return next.cancel2Free()
+	curr.next, next.next = next.next, nil
+	return next
+}
+
+// removeIf 会从 l 中移除所有让 fn 返回 true 的订单，并返回这些订单
+func (l *orderList) removeIf(fn func(*order) bool) []*order {
+	res := make([]*order, 0, 8)
+	curr, next := l.head, l.head.next
+	for next != nil {
+		if !fn(next) {
+			curr, next = next, next.next
+			continue
+		}
+		curr.next, next.next = next.next, nil
+		res = append(res, next)
+		next = curr.next
+	}
+	return res
 }
 
 func (l *orderList) pop() *order {
@@ -59,7 +101,7 @@ func (l *orderList) isEmpty() bool {
 	return l.head.next == nil
 }
 
-func (l *orderList) canMatch(price float64) bool {
+func (l *orderList) canMatch(price exch.Decimal) bool {
 	if l.head.next == nil {
 		return false
 	}
@@ -67,19 +109,107 @@ func (l *orderList) canMatch(price float64) bool {
 	return order.canMatch(price)
 }
 
-func (l *orderList) match(tick exch.Tick) []exch.Asset {
-	res := make([]exch.Asset, 0, 16)
-	var as []exch.Asset
-	var order order
+// trigger 会从 newStopList 生成的 l 中，
+// 取出所有被 price 触发了的订单
+func (l *orderList) trigger(price exch.Decimal) []*order {
+	res := make([]*order, 0, 8)
+	for l.head.next.canTrigger(price) {
+		res = append(res, l.pop())
+	}
+	return res
+}
+
+// match 会用 tick 撮合 l 中的订单，并返回每一次成交的结果
+func (l *orderList) match(tick exch.Tick) []fill {
+	res := make([]fill, 0, 16)
+	// 无法全部成交的 FOK 订单，tick 的主动方碰不到的挂单，
+	// 以及买不到一个 l.step 的 MARKET BUY 订单，不参与撮合
+	skipped := make([]*order, 0, 4)
 	for tick.Volume != 0 && l.canMatch(tick.Price) {
-		order, tick, as = l.pop().match(tick)
-		res = append(res, as...)
+		o := l.pop()
+		if !o.canBeHitBy(tick) {
+			skipped = append(skipped, o)
+			continue
+		}
+		t := l.snap(o, l.slip(o, tick))
+		if l.starved(o, t.Price) {
+			// 留给 BackTest.sweep 结束，不能挡住后面的订单
+			skipped = append(skipped, o)
+			continue
+		}
+		if o.TimeInForce == exch.FOK && !l.canFillAll(o, t) {
+			skipped = append(skipped, o)
+			continue
+		}
+		var as []exch.Asset
+		var rest exch.Tick
+		*o, rest, as = o.match(t)
+		tick.Volume -= t.Volume - rest.Volume
+		if trade := newTrade(*o, t, as); trade.Quantity != 0 {
+			l.release(o, t, as)
+			o.fillWith(trade)
+			res = append(res, fill{trade: trade, assets: as, order: o.Order})
+		}
+		if !o.IsEmpty() {
+			// 没有完全成交的订单，需要放回 l 中
+			// 此时 o 的资金已经冻结过了，不需要再冻结一次
+			l.push(o)
+			break
+		}
 	}
-	// 防止把 for 循环前的 order 添加进来了
-	// if order.Type != 0 {
-	if !order.IsEmpty() {
-		log.Println("order is NOT Empty, push in", order)
-		l.push(&order) // order 此时有可能是空订单
+	for _, o := range skipped {
+		l.push(o)
 	}
 	return res
 }
+
+// canFillAll 返回 true 表示 o 与滑点和取整以后实际撮合的 t 撮合后，会全部成交
+// 用 o 的副本试撮合一次，所以与 match 的结果总是一致的
+func (l *orderList) canFillAll(o *order, t exch.Tick) bool {
+	filled, _, as := o.match(t)
+	l.release(&filled, t, as)
+	return filled.IsEmpty()
+}
+
+// slip 返回 o 在滑点后实际撮合的 tick
+// LIMIT 订单只有在滑点后的价格依然满足限价时才能成交，成交价依然是 o.AssetPrice
+func (l *orderList) slip(o *order, tick exch.Tick) exch.Tick {
+	if l.slippage == nil {
+		return tick
+	}
+	tick.Price = l.slippage.Price(o.Side, tick, o.fillQuantity(tick))
+	return tick
+}
+
+// snap 会把 tick 中 o 能够成交的数量，向下取整到 l.step 的整数倍
+// MARKET BUY 订单还受到 CapitalQuantity 的限制
+func (l *orderList) snap(o *order, tick exch.Tick) exch.Tick {
+	if l.step == 0 {
+		return tick
+	}
+	tick.Volume = floorStep(o.fillQuantity(tick), l.step)
+	return tick
+}
+
+// starved 返回 true 表示 MARKET BUY 订单 o 剩余的 Capital 不足以按照 price 买入一个 l.step
+func (l *orderList) starved(o *order, price exch.Decimal) bool {
+	return l.step != 0 && o.bookType() == exch.MARKET && o.Side == exch.BUY &&
+		o.CapitalQuantity < l.step.Mul(price)
+}
+
+// release 会在 MARKET BUY 订单剩余的 Capital 不足以买入一个 l.step 时，
+// 结束订单，并把剩余的 Capital 解冻
+// as 是撮合时 Asset 和 Capital 的变化量，会记录解冻的 Capital
+func (l *orderList) release(o *order, tick exch.Tick, as []exch.Asset) {
+	if !l.starved(o, tick.Price) {
+		return
+	}
+	as[1].Free += o.CapitalQuantity
+	as[1].Locked -= o.CapitalQuantity
+	o.CapitalQuantity = 0
+}
+
+// floorStep 返回不大于 quantity 的 step 的整数倍
+func floorStep(quantity, step exch.Decimal) exch.Decimal {
+	return exch.SymbolInfo{StepSize: step}.FloorStep(quantity)
+}
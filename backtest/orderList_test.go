@@ -2,6 +2,7 @@ package backtest
 
 import (
 	"testing"
+	"time"
 
 	"github.com/jujili/exch"
 	. "github.com/smartystreets/goconvey/convey"
@@ -33,7 +34,7 @@ func Test_orderList_push(t *testing.T) {
 			So(mb1.next, ShouldResemble, mb2)
 		})
 		temp := *lb1
-		temp.AssetPrice -= 10000
+		temp.AssetPrice -= exch.NewDecimal(10000)
 		lb2 := &temp
 		ol.push(lb2)
 		Convey("插入更低的限价买入单后，lb2 应该在最后", func() {
@@ -67,7 +68,7 @@ func Test_orderList_pop(t *testing.T) {
 		mb2.ID++
 		ol.push(mb2)
 		temp := *lb1
-		temp.AssetPrice -= 10000
+		temp.AssetPrice -= exch.NewDecimal(10000)
 		lb2 := &temp
 		ol.push(lb2)
 		Convey("整个 list 的顺序是", func() {
@@ -123,10 +124,10 @@ func Test_orderList_canMatch(t *testing.T) {
 			price := lb.AssetPrice
 			Convey("对相等或更低的价格**能够**匹配", func() {
 				So(ol.canMatch(price), ShouldBeTrue)
-				So(ol.canMatch(price*0.99), ShouldBeTrue)
+				So(ol.canMatch(price.MulFloat(0.99)), ShouldBeTrue)
 			})
 			Convey("对更高的价格**不能够**匹配", func() {
-				So(ol.canMatch(price*1.01), ShouldBeFalse)
+				So(ol.canMatch(price.MulFloat(1.01)), ShouldBeFalse)
 			})
 		})
 		Convey("限价 SELL 单", func() {
@@ -135,11 +136,137 @@ func Test_orderList_canMatch(t *testing.T) {
 			price := ls.AssetPrice
 			Convey("对相等或更高的价格**能够**匹配", func() {
 				So(ol.canMatch(price), ShouldBeTrue)
-				So(ol.canMatch(price*1.01), ShouldBeTrue)
+				So(ol.canMatch(price.MulFloat(1.01)), ShouldBeTrue)
 			})
 			Convey("对更低的价格**不能够**匹配", func() {
-				So(ol.canMatch(price*0.99), ShouldBeFalse)
+				So(ol.canMatch(price.MulFloat(0.99)), ShouldBeFalse)
 			})
 		})
 	})
 }
+
+func Test_orderList_match(t *testing.T) {
+	Convey("orderList.match", t, func() {
+		enc := exch.EncFunc()
+		dec := decOrderFunc()
+		de := func(i interface{}) *order {
+			return dec(enc(i))
+		}
+		BtcUsdtOrder := exch.NewOrder("BTCUSDT", "BTC", "USDT")
+		ol := newOrderList()
+		ls1 := de(BtcUsdtOrder.With(exch.Limit(exch.SELL, 1, 100)))
+		ol.push(ls1)
+		ls2 := de(BtcUsdtOrder.With(exch.Limit(exch.SELL, 2, 110)))
+		ls2.ID++
+		ol.push(ls2)
+		Convey("价格达不到的 tick 不会成交", func() {
+			fills := ol.match(exch.NewTick(1, time.Now(), 90, 10))
+			So(fills, ShouldBeEmpty)
+			So(ol.head.next, ShouldEqual, ls1)
+		})
+		Convey("每一次成交都会产生一个 fill", func() {
+			date := time.Now()
+			fills := ol.match(exch.NewTick(1, date, 120, 2))
+			So(len(fills), ShouldEqual, 2)
+			t1, t2 := fills[0].trade, fills[1].trade
+			So(t1.OrderID, ShouldEqual, ls1.ID)
+			So(t1.Price, ShouldEqual, exch.NewDecimal(100))
+			So(t1.Quantity, ShouldEqual, exch.NewDecimal(1))
+			So(t1.Side, ShouldEqual, exch.SELL)
+			So(t1.IsMaker, ShouldBeTrue)
+			So(t1.Date, ShouldEqual, date)
+			So(t2.OrderID, ShouldEqual, ls2.ID)
+			So(t2.Price, ShouldEqual, exch.NewDecimal(110))
+			So(t2.Quantity, ShouldEqual, exch.NewDecimal(1))
+			Convey("没有完全成交的订单会留在 orderList 中", func() {
+				o := ol.head.next
+				So(o.ID, ShouldEqual, ls2.ID)
+				So(o.AssetQuantity, ShouldEqual, exch.NewDecimal(1))
+				So(o.next, ShouldBeNil)
+			})
+		})
+		Convey("市价单以 tick 的价格成交", func() {
+			ms := de(BtcUsdtOrder.With(exch.Market(exch.SELL, 1)))
+			ol.push(ms)
+			fills := ol.match(exch.NewTick(1, time.Now(), 90, 1))
+			So(len(fills), ShouldEqual, 1)
+			So(fills[0].trade.Price, ShouldEqual, exch.NewDecimal(90))
+			So(fills[0].trade.IsMaker, ShouldBeFalse)
+			So(ol.head.next, ShouldEqual, ls1)
+		})
+		Convey("主动卖出的 tick 不会与挂着的 SELL 订单成交", func() {
+			fills := ol.match(exch.NewTick(1, time.Now(), 120, 2, exch.TickSide(exch.SELL)))
+			So(fills, ShouldBeEmpty)
+			So(ol.head.next, ShouldEqual, ls1)
+			So(ls1.next, ShouldEqual, ls2)
+			Convey("主动买入的 tick 才会与它们成交", func() {
+				fills := ol.match(exch.NewTick(2, time.Now(), 120, 2, exch.TickSide(exch.BUY)))
+				So(len(fills), ShouldEqual, 2)
+			})
+		})
+		Convey("taker 订单不受 tick 的主动方的限制", func() {
+			ms := de(BtcUsdtOrder.With(exch.Market(exch.SELL, 1)))
+			ol.push(ms)
+			fills := ol.match(exch.NewTick(1, time.Now(), 90, 1, exch.TickSide(exch.SELL)))
+			So(len(fills), ShouldEqual, 1)
+			So(fills[0].trade.OrderID, ShouldEqual, ms.ID)
+		})
+		Convey("FOK 订单按照取整以后的数量，判断能否全部成交", func() {
+			ol := newOrderList()
+			ol.step = exch.NewDecimal(1)
+			fs := de(BtcUsdtOrder.With(exch.Limit(exch.SELL, 2.5, 100), exch.InForce(exch.FOK)))
+			ol.push(fs)
+			// tick 的成交量比订单多，但是取整以后只能成交 2 个
+			fills := ol.match(exch.NewTick(1, time.Now(), 100, 3))
+			So(fills, ShouldBeEmpty)
+			So(ol.head.next, ShouldEqual, fs)
+			So(fs.AssetQuantity, ShouldEqual, exch.NewDecimal(2.5))
+		})
+		Convey("FOK 订单按照滑点以后的价格，判断能否全部成交", func() {
+			ol := newOrderList()
+			ol.slippage = FixedBps{Bps: 100}
+			fb := de(BtcUsdtOrder.With(exch.Market(exch.BUY, 1000), exch.InForce(exch.FOK)))
+			ol.push(fb)
+			// 按照 tick 的价格 100，9.95 个不够 1000，按照滑点以后的价格 101，就够了
+			fills := ol.match(exch.NewTick(1, time.Now(), 100, 9.95))
+			So(len(fills), ShouldEqual, 1)
+			So(fills[0].trade.Price, ShouldEqual, exch.NewDecimal(101))
+			So(fills[0].order.Status, ShouldEqual, exch.FILLED)
+			So(ol.isEmpty(), ShouldBeTrue)
+		})
+	})
+}
+
+func Test_orderList_trigger(t *testing.T) {
+	Convey("等待触发的订单", t, func() {
+		BtcUsdtOrder := exch.NewOrder("BTCUSDT", "BTC", "USDT")
+		Convey("价格上涨时触发的订单，StopPrice 低的先触发", func() {
+			ol := newStopList(true)
+			s1 := de(BtcUsdtOrder.With(exch.StopLoss(exch.BUY, 100, 120)))
+			s2 := de(BtcUsdtOrder.With(exch.TakeProfit(exch.SELL, 1, 110)))
+			s2.ID++
+			ol.push(s1)
+			ol.push(s2)
+			So(ol.head.next, ShouldEqual, s2)
+			So(ol.trigger(exch.NewDecimal(100)), ShouldBeEmpty)
+			os := ol.trigger(exch.NewDecimal(115))
+			So(len(os), ShouldEqual, 1)
+			So(os[0], ShouldEqual, s2)
+			So(ol.trigger(exch.NewDecimal(130)), ShouldResemble, []*order{s1})
+			So(ol.isEmpty(), ShouldBeTrue)
+		})
+		Convey("价格下跌时触发的订单，StopPrice 高的先触发", func() {
+			ol := newStopList(false)
+			s1 := de(BtcUsdtOrder.With(exch.StopLoss(exch.SELL, 1, 80)))
+			s2 := de(BtcUsdtOrder.With(exch.TakeProfitLimit(exch.BUY, 1, 90, 91)))
+			s2.ID++
+			ol.push(s1)
+			ol.push(s2)
+			So(ol.head.next, ShouldEqual, s2)
+			os := ol.trigger(exch.NewDecimal(70))
+			So(len(os), ShouldEqual, 2)
+			So(os[0], ShouldEqual, s2)
+			So(os[1], ShouldEqual, s1)
+		})
+	})
+}
@@ -171,7 +171,7 @@ func Test_matchMarket(t *testing.T) {
 			mb := de(BtcUsdtOrder.With(exch.Market(exch.BUY, capitalQuantity)))
 			tk := exch.NewTick(0, time.Now(), 1000, 100)
 			Convey("如果 tick.Volume*tick.Price < mb.CapitalQuantity", func() {
-				tk.Volume = mb.CapitalQuantity / tk.Price * 0.5
+				tk.Volume = mb.CapitalQuantity.Div(tk.Price).MulFloat(0.5)
 				//
 				et := tk
 				et.Volume = 0
@@ -184,7 +184,7 @@ func Test_matchMarket(t *testing.T) {
 				checkMatch(matchMarket, *mb, eo, tk, et, eAsset, eCapital)
 			})
 			Convey("如果 tick.Volume*tick.Price = mb.CapitalQuantity", func() {
-				tk.Volume = mb.CapitalQuantity / tk.Price
+				tk.Volume = mb.CapitalQuantity.Div(tk.Price)
 				//
 				et := tk
 				et.Volume = 0
@@ -197,7 +197,7 @@ func Test_matchMarket(t *testing.T) {
 				checkMatch(matchMarket, *mb, eo, tk, et, eAsset, eCapital)
 			})
 			Convey("如果 tick.Volume*tick.Price > mb.CapitalQuantity", func() {
-				tk.Volume = mb.CapitalQuantity / tk.Price * 2
+				tk.Volume = mb.CapitalQuantity.Div(tk.Price) * 2
 				//
 				et := tk
 				et.Volume = tk.Volume / 2
@@ -215,7 +215,7 @@ func Test_matchMarket(t *testing.T) {
 			ms := de(BtcUsdtOrder.With(exch.Market(exch.SELL, assetQuantity)))
 			tk := exch.NewTick(0, time.Now(), 1000, 100)
 			Convey("如果 tick.Volume < ms.AssetQuantity", func() {
-				tk.Volume = ms.AssetQuantity * 0.75
+				tk.Volume = ms.AssetQuantity.MulFloat(0.75)
 				//
 				et := tk
 				et.Volume = 0
@@ -224,7 +224,7 @@ func Test_matchMarket(t *testing.T) {
 				eo.AssetQuantity = ms.AssetQuantity - tk.Volume
 				//
 				eAsset.Locked = -tk.Volume
-				eCapital.Free = tk.Volume * tk.Price
+				eCapital.Free = tk.Volume.Mul(tk.Price)
 				checkMatch(matchMarket, *ms, eo, tk, et, eAsset, eCapital)
 			})
 			Convey("如果 tick.Volume = ms.AssetQuantity", func() {
@@ -237,11 +237,11 @@ func Test_matchMarket(t *testing.T) {
 				eo.AssetQuantity = ms.AssetQuantity - tk.Volume
 				//
 				eAsset.Locked = -tk.Volume
-				eCapital.Free = tk.Volume * tk.Price
+				eCapital.Free = tk.Volume.Mul(tk.Price)
 				checkMatch(matchMarket, *ms, eo, tk, et, eAsset, eCapital)
 			})
 			Convey("如果 tick.Volume > ms.AssetQuantity", func() {
-				tk.Volume = ms.AssetQuantity * 1.25
+				tk.Volume = ms.AssetQuantity.MulFloat(1.25)
 				//
 				et := tk
 				et.Volume = tk.Volume - ms.AssetQuantity
@@ -250,7 +250,7 @@ func Test_matchMarket(t *testing.T) {
 				eo.AssetQuantity = 0
 				//
 				eAsset.Locked = -ms.AssetQuantity
-				eCapital.Free = ms.AssetQuantity * tk.Price
+				eCapital.Free = ms.AssetQuantity.Mul(tk.Price)
 				checkMatch(matchMarket, *ms, eo, tk, et, eAsset, eCapital)
 			})
 		})
@@ -298,7 +298,7 @@ func Test_matchLimit(t *testing.T) {
 					eo.AssetQuantity = ls.AssetQuantity - tk.Volume
 					//
 					eAsset.Locked = -tk.Volume
-					eCapital.Free = ls.AssetPrice * tk.Volume
+					eCapital.Free = ls.AssetPrice.Mul(tk.Volume)
 					checkMatch(matchLimit, *ls, eo, tk, et, eAsset, eCapital)
 				})
 				Convey("如果 tick.Volume = ls.AssetQuantity", func() {
@@ -311,7 +311,7 @@ func Test_matchLimit(t *testing.T) {
 					eo.AssetQuantity = 0
 					//
 					eAsset.Locked = -tk.Volume
-					eCapital.Free = ls.AssetPrice * tk.Volume
+					eCapital.Free = ls.AssetPrice.Mul(tk.Volume)
 					checkMatch(matchLimit, *ls, eo, tk, et, eAsset, eCapital)
 				})
 				Convey("如果 tick.Volume > ls.AssetQuantity", func() {
@@ -324,7 +324,7 @@ func Test_matchLimit(t *testing.T) {
 					eo.AssetQuantity = 0
 					//
 					eAsset.Locked = -ls.AssetQuantity
-					eCapital.Free = ls.AssetPrice * ls.AssetQuantity
+					eCapital.Free = ls.AssetPrice.Mul(ls.AssetQuantity)
 					checkMatch(matchLimit, *ls, eo, tk, et, eAsset, eCapital)
 				})
 			})
@@ -341,7 +341,7 @@ func Test_matchLimit(t *testing.T) {
 					eo.AssetQuantity = ls.AssetQuantity - tk.Volume
 					//
 					eAsset.Locked = -tk.Volume
-					eCapital.Free = ls.AssetPrice * tk.Volume
+					eCapital.Free = ls.AssetPrice.Mul(tk.Volume)
 					checkMatch(matchLimit, *ls, eo, tk, et, eAsset, eCapital)
 				})
 				Convey("如果 tick.Volume = ls.AssetQuantity", func() {
@@ -354,7 +354,7 @@ func Test_matchLimit(t *testing.T) {
 					eo.AssetQuantity = 0
 					//
 					eAsset.Locked = -tk.Volume
-					eCapital.Free = ls.AssetPrice * tk.Volume
+					eCapital.Free = ls.AssetPrice.Mul(tk.Volume)
 					checkMatch(matchLimit, *ls, eo, tk, et, eAsset, eCapital)
 				})
 				Convey("如果 tick.Volume > ls.AssetQuantity", func() {
@@ -367,7 +367,7 @@ func Test_matchLimit(t *testing.T) {
 					eo.AssetQuantity = 0
 					//
 					eAsset.Locked = -ls.AssetQuantity
-					eCapital.Free = ls.AssetPrice * ls.AssetQuantity
+					eCapital.Free = ls.AssetPrice.Mul(ls.AssetQuantity)
 					checkMatch(matchLimit, *ls, eo, tk, et, eAsset, eCapital)
 				})
 			})
@@ -397,7 +397,7 @@ func Test_matchLimit(t *testing.T) {
 					eo.AssetQuantity = lb.AssetQuantity - tk.Volume
 					//
 					eAsset.Free = tk.Volume
-					eCapital.Locked = -lb.AssetPrice * tk.Volume
+					eCapital.Locked = -lb.AssetPrice.Mul(tk.Volume)
 					checkMatch(matchLimit, *lb, eo, tk, et, eAsset, eCapital)
 				})
 				Convey("如果 tick.Volume = lb.AssetQuantity", func() {
@@ -410,7 +410,7 @@ func Test_matchLimit(t *testing.T) {
 					eo.AssetQuantity = 0
 					//
 					eAsset.Free = tk.Volume
-					eCapital.Locked = -lb.AssetPrice * tk.Volume
+					eCapital.Locked = -lb.AssetPrice.Mul(tk.Volume)
 					checkMatch(matchLimit, *lb, eo, tk, et, eAsset, eCapital)
 				})
 				Convey("如果 tick.Volume > lb.AssetQuantity", func() {
@@ -423,7 +423,7 @@ func Test_matchLimit(t *testing.T) {
 					eo.AssetQuantity = 0
 					//
 					eAsset.Free = lb.AssetQuantity
-					eCapital.Locked = -lb.AssetPrice * lb.AssetQuantity
+					eCapital.Locked = -lb.AssetPrice.Mul(lb.AssetQuantity)
 					checkMatch(matchLimit, *lb, eo, tk, et, eAsset, eCapital)
 				})
 			})
@@ -440,7 +440,7 @@ func Test_matchLimit(t *testing.T) {
 					eo.AssetQuantity = lb.AssetQuantity - tk.Volume
 					//
 					eAsset.Free = tk.Volume
-					eCapital.Locked = -lb.AssetPrice * tk.Volume
+					eCapital.Locked = -lb.AssetPrice.Mul(tk.Volume)
 					checkMatch(matchLimit, *lb, eo, tk, et, eAsset, eCapital)
 				})
 				Convey("如果 tick.Volume = lb.AssetQuantity", func() {
@@ -453,7 +453,7 @@ func Test_matchLimit(t *testing.T) {
 					eo.AssetQuantity = 0
 					//
 					eAsset.Free = tk.Volume
-					eCapital.Locked = -lb.AssetPrice * tk.Volume
+					eCapital.Locked = -lb.AssetPrice.Mul(tk.Volume)
 					checkMatch(matchLimit, *lb, eo, tk, et, eAsset, eCapital)
 				})
 				Convey("如果 tick.Volume > lb.AssetQuantity", func() {
@@ -466,7 +466,7 @@ func Test_matchLimit(t *testing.T) {
 					eo.AssetQuantity = 0
 					//
 					eAsset.Free = lb.AssetQuantity
-					eCapital.Locked = -lb.AssetPrice * lb.AssetQuantity
+					eCapital.Locked = -lb.AssetPrice.Mul(lb.AssetQuantity)
 					checkMatch(matchLimit, *lb, eo, tk, et, eAsset, eCapital)
 				})
 			})
@@ -525,11 +525,10 @@ func Test_order_pend2Lock(t *testing.T) {
 		//
 		Convey("输入别的类型的 order 会 panic", func() {
 			lb := de(BtcUsdtOrder.With(exch.Market(exch.BUY, 100000)))
-			lb.Type = 3
-			So(lb.Type, ShouldNotBeBetweenOrEqual, 1, 2)
+			lb.Type = 0
 			So(func() {
 				lb.pend2Lock()
-			}, ShouldPanicWith, "现在只能处理 limit 和 market 类型")
+			}, ShouldPanicWith, "无法处理的订单类型")
 		})
 		Convey("挂 MARKET 订单时", func() {
 			hasCalled := false
@@ -560,6 +559,54 @@ func Test_order_pend2Lock(t *testing.T) {
 	})
 }
 
+func Test_order_stop(t *testing.T) {
+	Convey("测试触发类订单", t, func() {
+		BtcUsdtOrder := exch.NewOrder("BTCUSDT", "BTC", "USDT")
+		Convey("触发后的订单类型", func() {
+			So(de(BtcUsdtOrder.With(exch.StopLoss(exch.SELL, 1, 90))).baseType(), ShouldEqual, exch.MARKET)
+			So(de(BtcUsdtOrder.With(exch.TakeProfit(exch.SELL, 1, 90))).baseType(), ShouldEqual, exch.MARKET)
+			So(de(BtcUsdtOrder.With(exch.StopLossLimit(exch.SELL, 1, 90, 89))).baseType(), ShouldEqual, exch.LIMIT)
+			So(de(BtcUsdtOrder.With(exch.TakeProfitLimit(exch.SELL, 1, 90, 89))).baseType(), ShouldEqual, exch.LIMIT)
+			So(de(BtcUsdtOrder.With(exch.Limit(exch.SELL, 1, 90))).baseType(), ShouldEqual, exch.LIMIT)
+		})
+		Convey("SELL 止损单在价格下跌到 StopPrice 时触发", func() {
+			o := de(BtcUsdtOrder.With(exch.StopLoss(exch.SELL, 1, 90)))
+			So(o.isStop(), ShouldBeTrue)
+			So(o.canTrigger(exch.NewDecimal(91)), ShouldBeFalse)
+			So(o.canTrigger(exch.NewDecimal(90)), ShouldBeTrue)
+			So(o.canTrigger(exch.NewDecimal(89)), ShouldBeTrue)
+		})
+		Convey("BUY 止损单在价格上涨到 StopPrice 时触发", func() {
+			o := de(BtcUsdtOrder.With(exch.StopLossLimit(exch.BUY, 1, 110, 111)))
+			So(o.canTrigger(exch.NewDecimal(109)), ShouldBeFalse)
+			So(o.canTrigger(exch.NewDecimal(110)), ShouldBeTrue)
+		})
+		Convey("SELL 止盈单在价格上涨到 StopPrice 时触发", func() {
+			o := de(BtcUsdtOrder.With(exch.TakeProfit(exch.SELL, 1, 110)))
+			So(o.canTrigger(exch.NewDecimal(109)), ShouldBeFalse)
+			So(o.canTrigger(exch.NewDecimal(110)), ShouldBeTrue)
+		})
+		Convey("BUY 止盈单在价格下跌到 StopPrice 时触发", func() {
+			o := de(BtcUsdtOrder.With(exch.TakeProfitLimit(exch.BUY, 1, 90, 91)))
+			So(o.canTrigger(exch.NewDecimal(91)), ShouldBeFalse)
+			So(o.canTrigger(exch.NewDecimal(90)), ShouldBeTrue)
+		})
+		Convey("非触发类的订单，不能检查触发", func() {
+			o := de(BtcUsdtOrder.With(exch.Limit(exch.BUY, 1, 90)))
+			So(o.isStop(), ShouldBeFalse)
+			So(func() { o.canTrigger(exch.NewDecimal(90)) }, ShouldPanic)
+		})
+		Convey("触发类订单按照触发后的类型冻结资金", func() {
+			mb := de(BtcUsdtOrder.With(exch.StopLoss(exch.BUY, 1000, 110)))
+			So(mb.pend2Lock(), ShouldResemble, exch.NewAsset("USDT", -1000, 1000))
+			So(mb.cancel2Free(), ShouldResemble, exch.NewAsset("USDT", 1000, -1000))
+			lb := de(BtcUsdtOrder.With(exch.StopLossLimit(exch.BUY, 2, 110, 111)))
+			So(lb.pend2Lock(), ShouldResemble, exch.NewAsset("USDT", -222, 222))
+			So(lb.cancel2Free(), ShouldResemble, exch.NewAsset("USDT", 222, -222))
+		})
+	})
+}
+
 func Test_pendMarket(t *testing.T) {
 	Convey("测试 pendMarket", t, func() {
 		BtcUsdtOrder := exch.NewOrder("BTCUSDT", "BTC", "USDT")
@@ -580,8 +627,8 @@ func Test_pendMarket(t *testing.T) {
 		Convey("挂 BUY 单会冻结 Capital", func() {
 			quantity := 1000.
 			mb := de(BtcUsdtOrder.With(exch.Market(exch.BUY, quantity)))
-			eCapital.Free = -quantity
-			eCapital.Locked = quantity
+			eCapital.Free = -exch.NewDecimal(quantity)
+			eCapital.Locked = exch.NewDecimal(quantity)
 			ac := pendMarket(*mb)
 			Convey("Asset 应该符合预期", func() {
 				So(ac, ShouldResemble, eCapital)
@@ -590,8 +637,8 @@ func Test_pendMarket(t *testing.T) {
 		Convey("挂 SELL 单会冻结 Asset", func() {
 			quantity := 1000.
 			ms := de(BtcUsdtOrder.With(exch.Market(exch.SELL, quantity)))
-			eAsset.Free = -quantity
-			eAsset.Locked = quantity
+			eAsset.Free = -exch.NewDecimal(quantity)
+			eAsset.Locked = exch.NewDecimal(quantity)
 			aa := pendMarket(*ms)
 			Convey("Asset 应该符合预期", func() {
 				So(aa, ShouldResemble, eAsset)
@@ -621,7 +668,7 @@ func Test_pendLimit(t *testing.T) {
 			price := 10000.
 			quantity := 100.
 			lb := de(BtcUsdtOrder.With(exch.Limit(exch.BUY, quantity, price)))
-			total := quantity * price
+			total := exch.NewDecimal(quantity * price)
 			eCapital.Free = -total
 			eCapital.Locked = total
 			ac := pendLimit(*lb)
@@ -633,8 +680,8 @@ func Test_pendLimit(t *testing.T) {
 			price := 10000.
 			quantity := 100.
 			ls := de(BtcUsdtOrder.With(exch.Limit(exch.SELL, quantity, price)))
-			eAsset.Free = -quantity
-			eAsset.Locked = quantity
+			eAsset.Free = -exch.NewDecimal(quantity)
+			eAsset.Locked = exch.NewDecimal(quantity)
 			aa := pendLimit(*ls)
 			Convey("Asset 应该符合预期", func() {
 				So(aa, ShouldResemble, eAsset)
@@ -649,11 +696,10 @@ func Test_order_cancel2Free(t *testing.T) {
 		//
 		Convey("输入别的类型的 order 会 panic", func() {
 			lb := de(BtcUsdtOrder.With(exch.Market(exch.BUY, 100000)))
-			lb.Type = 3
-			So(lb.Type, ShouldNotBeBetweenOrEqual, 1, 2)
+			lb.Type = 0
 			So(func() {
 				lb.cancel2Free()
-			}, ShouldPanicWith, "现在只能处理 limit 和 market 类型")
+			}, ShouldPanicWith, "无法处理的订单类型")
 		})
 		Convey("撤销 MARKET 类型的订单", func() {
 			hasCalled := false
@@ -704,8 +750,8 @@ func Test_cancelMarket(t *testing.T) {
 		Convey("撤销 BUY 单会释放 Capital", func() {
 			quantity := 1000.
 			mb := de(BtcUsdtOrder.With(exch.Market(exch.BUY, quantity)))
-			eCapital.Free = quantity
-			eCapital.Locked = -quantity
+			eCapital.Free = exch.NewDecimal(quantity)
+			eCapital.Locked = -exch.NewDecimal(quantity)
 			ac := cancelMarket(*mb)
 			Convey("Asset 应该符合预期", func() {
 				So(ac, ShouldResemble, eCapital)
@@ -714,8 +760,8 @@ func Test_cancelMarket(t *testing.T) {
 		Convey("撤销 SELL 单会释放 Asset", func() {
 			quantity := 1000.
 			ms := de(BtcUsdtOrder.With(exch.Market(exch.SELL, quantity)))
-			eAsset.Free = quantity
-			eAsset.Locked = -quantity
+			eAsset.Free = exch.NewDecimal(quantity)
+			eAsset.Locked = -exch.NewDecimal(quantity)
 			aa := cancelMarket(*ms)
 			Convey("Asset 应该符合预期", func() {
 				So(aa, ShouldResemble, eAsset)
@@ -745,7 +791,7 @@ func Test_cancelLimit(t *testing.T) {
 			price := 10000.
 			quantity := 100.
 			lb := de(BtcUsdtOrder.With(exch.Limit(exch.BUY, quantity, price)))
-			total := quantity * price
+			total := exch.NewDecimal(quantity * price)
 			eCapital.Free = total
 			eCapital.Locked = -total
 			ac := cancelLimit(*lb)
@@ -757,8 +803,8 @@ func Test_cancelLimit(t *testing.T) {
 			price := 10000.
 			quantity := 100.
 			ls := de(BtcUsdtOrder.With(exch.Limit(exch.SELL, quantity, price)))
-			eAsset.Free = quantity
-			eAsset.Locked = -quantity
+			eAsset.Free = exch.NewDecimal(quantity)
+			eAsset.Locked = -exch.NewDecimal(quantity)
 			ac := cancelLimit(*ls)
 			Convey("Asset 应该符合预期", func() {
 				So(ac, ShouldResemble, eAsset)
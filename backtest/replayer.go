@@ -0,0 +1,353 @@
+package backtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/jujili/clock"
+	"github.com/jujili/exch"
+)
+
+// ReplayControlTopic 是控制 Replayer 的话题
+// 控制消息的 Metadata 中，ReplayCommandKey 记录了命令，ReplayArgKey 记录了命令的参数
+// 可以使用 NewReplayMsg 生成控制消息
+const ReplayControlTopic = "replayControl"
+
+// 控制消息的 Metadata 的键
+const (
+	ReplayCommandKey = "command"
+	ReplayArgKey     = "arg"
+)
+
+// Replayer 的控制命令
+const (
+	// ReplayPause 会暂停回放
+	ReplayPause = "pause"
+	// ReplayResume 会继续回放
+	ReplayResume = "resume"
+	// ReplayStep 会暂停回放，并发送下一个 tick
+	ReplayStep = "step"
+	// ReplaySpeed 会修改回放的速度，参数是十进制的速度，例如 "2.5"
+	ReplaySpeed = "speed"
+	// ReplaySeek 会跳到参数所表示的时间，参数是 time.RFC3339Nano 格式的时间
+	ReplaySeek = "seek"
+)
+
+var errReplayOver = errors.New("Replayer 已经结束了")
+
+// NewReplayMsg 返回控制 Replayer 的消息，需要发送到 ReplayControlTopic 话题
+// 没有参数的命令，arg 为空字符串
+func NewReplayMsg(command, arg string) *message.Message {
+	msg := message.NewMessage(watermill.NewUUID(), nil)
+	msg.Metadata.Set(ReplayCommandKey, command)
+	msg.Metadata.Set(ReplayArgKey, arg)
+	return msg
+}
+
+// replayCmd 是发送给 Replayer 的 goroutine 的命令
+type replayCmd struct {
+	command string
+	speed   float64
+	date    time.Time
+	done    chan error
+}
+
+// tickSeeker 是可以跳到指定时间的 tick 数据源，例如 exch.TickArchiveReader
+type tickSeeker interface {
+	Seek(date time.Time) error
+}
+
+// Replayer 会把 tick 数据源中的 tick 按照设定的速度发送到 "tick" 话题中
+// 与 TickSourceService 一样，数据源读完以后，会关闭 Publisher
+// Replayer 的方法可以并发调用，也可以通过 ReplayControlTopic 话题控制 Replayer
+type Replayer struct {
+	ps    Pubsub
+	r     exch.TickReader
+	enc   func(interface{}) *message.Message
+	clock *clock.Simulator
+	cmds  chan replayCmd
+	// done 在 Replayer 结束时关闭
+	done chan struct{}
+	// 以下的内容只能在 run 的 goroutine 中使用
+	speed  float64
+	paused bool
+	// next 是下一个要发送的 tick
+	next *exch.Tick
+	// 在 wallBase 时发送了 simBase 时的 tick，用来计算下一个 tick 的发送时间
+	wallBase, simBase time.Time
+}
+
+// WithSpeed 会让 Replayer 以 speed 倍速回放，1 表示与 tick 的时间同步
+// speed 不大于 0 的话，会以最快的速度回放，这也是默认的速度
+func WithSpeed(speed float64) func(*Replayer) {
+	return func(rp *Replayer) {
+		rp.speed = speed
+	}
+}
+
+// WithPaused 会让 Replayer 在开始时处于暂停状态
+func WithPaused() func(*Replayer) {
+	return func(rp *Replayer) {
+		rp.paused = true
+	}
+}
+
+// NewReplayer 返回一个从 r 中读取 tick，并发送到 ps 的 "tick" 话题中的 Replayer
+// tick 使用 exch.Gob 编码
+// Replayer 会订阅 ReplayControlTopic 话题，并在 ctx 结束时退出
+func NewReplayer(ctx context.Context, ps Pubsub, r exch.TickReader, options ...func(*Replayer)) *Replayer {
+	rp := &Replayer{
+		ps:   ps,
+		r:    r,
+		enc:  exch.EncMsgFunc(exch.Gob),
+		cmds: make(chan replayCmd),
+		done: make(chan struct{}),
+	}
+	for _, option := range options {
+		option(rp)
+	}
+	controls, err := ps.Subscribe(ctx, ReplayControlTopic)
+	if err != nil {
+		panic(err)
+	}
+	// 先读出第一个 tick，让 Clock 从它的时间开始
+	var start time.Time
+	if err := rp.peek(); err == nil {
+		start = rp.next.Date
+	}
+	rp.clock = clock.NewSimulator(start)
+	rp.rebase()
+	go rp.control(controls)
+	go rp.run(ctx)
+	return rp
+}
+
+// Clock 返回 Replayer 驱动的虚拟时钟，它的时间是最新发送的 tick 的时间
+// 只有 Replayer 会推进这个时钟，可以用 WithClock 把它交给 BalanceService
+func (rp *Replayer) Clock() *clock.Simulator {
+	return rp.clock
+}
+
+// Done 返回的 channel 会在 Replayer 结束时关闭
+func (rp *Replayer) Done() <-chan struct{} {
+	return rp.done
+}
+
+// Pause 会暂停回放
+func (rp *Replayer) Pause() error {
+	return rp.do(replayCmd{command: ReplayPause})
+}
+
+// Resume 会继续回放
+func (rp *Replayer) Resume() error {
+	return rp.do(replayCmd{command: ReplayResume})
+}
+
+// Step 会暂停回放，并发送下一个 tick
+func (rp *Replayer) Step() error {
+	return rp.do(replayCmd{command: ReplayStep})
+}
+
+// SetSpeed 会把回放的速度修改为 speed，参考 WithSpeed
+func (rp *Replayer) SetSpeed(speed float64) error {
+	return rp.do(replayCmd{command: ReplaySpeed, speed: speed})
+}
+
+// Seek 会跳过 date 之前的 tick，下一个发送的是第一个不早于 date 的 tick，Clock 也会被设置为 date
+// 数据源实现了 Seek(time.Time) error 方法的话，例如 exch.TickArchiveReader，会利用它直接跳转
+// 因为 Clock 的时间不能倒退，date 不能早于 Clock 的时间
+func (rp *Replayer) Seek(date time.Time) error {
+	return rp.do(replayCmd{command: ReplaySeek, date: date})
+}
+
+func (rp *Replayer) do(cmd replayCmd) error {
+	cmd.done = make(chan error, 1)
+	select {
+	case rp.cmds <- cmd:
+		return <-cmd.done
+	case <-rp.done:
+		return errReplayOver
+	}
+}
+
+// control 把 ReplayControlTopic 话题中的消息转换成命令
+func (rp *Replayer) control(controls <-chan *message.Message) {
+	deadLetter := newDeadLetter(rp.ps)
+	for msg := range controls {
+		cmd, err := parseReplayMsg(msg)
+		if err != nil {
+			deadLetter(ReplayControlTopic, msg, err)
+			continue
+		}
+		if err := rp.do(cmd); err != nil {
+			log.Printf("Replayer 无法执行 %s 命令: %s", cmd.command, err)
+		}
+		msg.Ack()
+	}
+}
+
+func parseReplayMsg(msg *message.Message) (replayCmd, error) {
+	cmd := replayCmd{command: msg.Metadata.Get(ReplayCommandKey)}
+	arg := msg.Metadata.Get(ReplayArgKey)
+	var err error
+	switch cmd.command {
+	case ReplayPause, ReplayResume, ReplayStep:
+	case ReplaySpeed:
+		cmd.speed, err = strconv.ParseFloat(arg, 64)
+	case ReplaySeek:
+		cmd.date, err = time.Parse(time.RFC3339Nano, arg)
+	default:
+		err = fmt.Errorf("未知的命令 %q", cmd.command)
+	}
+	return cmd, err
+}
+
+// peek 会读出下一个 tick 放入 rp.next
+func (rp *Replayer) peek() error {
+	if rp.next != nil {
+		return nil
+	}
+	tick, err := rp.r.Read()
+	if err != nil {
+		return err
+	}
+	rp.next = &tick
+	return nil
+}
+
+// rebase 让回放的速度从现在开始重新计算
+func (rp *Replayer) rebase() {
+	rp.wallBase, rp.simBase = time.Now(), rp.clock.Now()
+}
+
+func (rp *Replayer) run(ctx context.Context) {
+	defer close(rp.done)
+	defer func() {
+		if err := rp.ps.Close(); err != nil {
+			log.Println("Replayer 关闭 Pubsub 出错: ", err)
+		}
+	}()
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		if err := rp.peek(); err != nil {
+			if err != io.EOF {
+				log.Println("Replayer 读取 tick 出错: ", err)
+			}
+			log.Println("Replayer is over")
+			return
+		}
+		// wait 为 nil 时，只处理命令，不发送 tick
+		var wait <-chan time.Time
+		if !rp.paused {
+			d := rp.delay()
+			if d <= 0 {
+				// 已经到了发送的时间，先处理已经到达的命令，再发送
+				select {
+				case cmd := <-rp.cmds:
+					cmd.done <- rp.exec(cmd)
+				default:
+					rp.publish()
+				}
+				continue
+			}
+			timer.Reset(d)
+			wait = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			log.Println("Replayer Down: ", ctx.Err())
+			return
+		case cmd := <-rp.cmds:
+			if wait != nil && !timer.Stop() {
+				<-timer.C
+			}
+			cmd.done <- rp.exec(cmd)
+		case <-wait:
+			rp.publish()
+		}
+	}
+}
+
+// delay 返回距离 rp.next 的发送时间还有多久
+func (rp *Replayer) delay() time.Duration {
+	if rp.speed <= 0 {
+		return 0
+	}
+	sim := float64(rp.next.Date.Sub(rp.simBase)) / rp.speed
+	return time.Until(rp.wallBase.Add(time.Duration(sim)))
+}
+
+// publish 会发送 rp.next，并把 Clock 设置为它的时间
+func (rp *Replayer) publish() {
+	tick := *rp.next
+	rp.next = nil
+	if err := rp.ps.Publish("tick", rp.enc(tick)); err != nil {
+		log.Println("Replayer 发送 tick 出错: ", err)
+	}
+	rp.clock.Set(tick.Date)
+}
+
+func (rp *Replayer) exec(cmd replayCmd) error {
+	switch cmd.command {
+	case ReplayPause:
+		rp.paused = true
+	case ReplayResume:
+		rp.paused = false
+		rp.rebase()
+	case ReplayStep:
+		rp.paused = true
+		if err := rp.peek(); err != nil {
+			return err
+		}
+		rp.publish()
+	case ReplaySpeed:
+		rp.speed = cmd.speed
+		rp.rebase()
+	case ReplaySeek:
+		if err := rp.seek(cmd.date); err != nil {
+			return err
+		}
+		rp.rebase()
+	default:
+		return fmt.Errorf("未知的命令 %q", cmd.command)
+	}
+	return nil
+}
+
+func (rp *Replayer) seek(date time.Time) error {
+	if date.Before(rp.clock.Now()) {
+		return fmt.Errorf("Replayer 的时间不能倒退到 %s", date)
+	}
+	if rp.next != nil && !rp.next.Date.Before(date) {
+		rp.clock.Set(date)
+		return nil
+	}
+	rp.next = nil
+	if s, ok := rp.r.(tickSeeker); ok {
+		if err := s.Seek(date); err != nil {
+			return err
+		}
+	}
+	for {
+		if err := rp.peek(); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if !rp.next.Date.Before(date) {
+			break
+		}
+		rp.next = nil
+	}
+	rp.clock.Set(date)
+	return nil
+}
@@ -0,0 +1,112 @@
+package backtest
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/jujili/exch"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// sliceTicks 是 ticks 的 exch.TickReader
+type sliceTicks []exch.Tick
+
+func (s *sliceTicks) Read() (exch.Tick, error) {
+	if len(*s) == 0 {
+		return exch.Tick{}, io.EOF
+	}
+	t := (*s)[0]
+	*s = (*s)[1:]
+	return t, nil
+}
+
+// collect 会在另一个 goroutine 中接收 msgs 中的 tick
+// 因为 Replayer 发送 tick 时会阻塞到收到 Ack
+func collect(msgs <-chan *message.Message) <-chan exch.Tick {
+	res := make(chan exch.Tick, 64)
+	dec := exch.DecMsgFunc()
+	go func() {
+		defer close(res)
+		for msg := range msgs {
+			var tick exch.Tick
+			dec(msg, &tick)
+			msg.Ack()
+			res <- tick
+		}
+	}()
+	return res
+}
+
+func Test_Replayer(t *testing.T) {
+	Convey("Replayer 会回放 tick", t, func() {
+		ctx := context.Background()
+		config := gochannel.Config{BlockPublishUntilSubscriberAck: true}
+		ps := gochannel.NewGoChannel(config, watermill.NopLogger{})
+		msgs, err := ps.Subscribe(ctx, "tick")
+		So(err, ShouldBeNil)
+		ticks := collect(msgs)
+		begin := time.Date(2021, 5, 14, 8, 0, 0, 0, time.UTC)
+		source := make(sliceTicks, 5)
+		for i := range source {
+			source[i] = exch.NewTick(int64(i), begin.Add(time.Duration(i)*100*time.Millisecond), 100, 1)
+		}
+		Convey("默认以最快的速度回放，结束后会关闭 Pubsub", func() {
+			rp := NewReplayer(ctx, ps, &source)
+			ids := make([]int64, 0, 5)
+			for tick := range ticks {
+				ids = append(ids, tick.ID)
+			}
+			So(ids, ShouldResemble, []int64{0, 1, 2, 3, 4})
+			<-rp.Done()
+			So(rp.Clock().Now(), ShouldEqual, begin.Add(400*time.Millisecond))
+			So(rp.Pause(), ShouldNotBeNil)
+		})
+		Convey("可以按照 tick 的时间的 N 倍速回放", func() {
+			start := time.Now()
+			NewReplayer(ctx, ps, &source, WithSpeed(10))
+			for range ticks {
+			}
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 40*time.Millisecond)
+		})
+		Convey("可以暂停、单步、跳转和继续", func() {
+			rp := NewReplayer(ctx, ps, &source, WithPaused())
+			So(rp.Clock().Now(), ShouldEqual, begin)
+			So(rp.Step(), ShouldBeNil)
+			So((<-ticks).ID, ShouldEqual, 0)
+			So(rp.Seek(begin.Add(250*time.Millisecond)), ShouldBeNil)
+			So(rp.Clock().Now(), ShouldEqual, begin.Add(250*time.Millisecond))
+			So(rp.Seek(begin), ShouldNotBeNil)
+			So(rp.Step(), ShouldBeNil)
+			So((<-ticks).ID, ShouldEqual, 3)
+			select {
+			case <-ticks:
+				t.Error("暂停时不应该发送 tick")
+			case <-time.After(10 * time.Millisecond):
+			}
+			So(rp.Resume(), ShouldBeNil)
+			So((<-ticks).ID, ShouldEqual, 4)
+			<-rp.Done()
+		})
+		Convey("可以通过 ReplayControlTopic 话题控制", func() {
+			// 发送控制消息会阻塞到 Replayer 执行完命令，所以在另一个 goroutine 中发送
+			rp := NewReplayer(ctx, ps, &source, WithPaused())
+			go ps.Publish(ReplayControlTopic, NewReplayMsg(ReplayStep, ""))
+			So((<-ticks).ID, ShouldEqual, 0)
+			dead, err := ps.Subscribe(ctx, DeadLetterTopic)
+			So(err, ShouldBeNil)
+			go ps.Publish(ReplayControlTopic, NewReplayMsg(ReplaySpeed, "fast"))
+			msg := <-dead
+			msg.Ack()
+			So(msg.Metadata.Get(DeadLetterTopicKey), ShouldEqual, ReplayControlTopic)
+			go ps.Publish(ReplayControlTopic, NewReplayMsg(ReplayResume, ""))
+			for range ticks {
+			}
+			<-rp.Done()
+		})
+	})
+}
@@ -0,0 +1,89 @@
+package backtest
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/jujili/exch"
+)
+
+// SlippageModel 会计算订单与 tick 撮合时的滑点
+type SlippageModel interface {
+	// Price 返回 side 方向的订单，与 tick 成交 quantity 个 Asset 时的价格
+	// 返回的价格应该比 tick.Price 对订单更加不利
+	Price(side exch.OrderSide, tick exch.Tick, quantity exch.Decimal) exch.Decimal
+}
+
+// slip 会把 price 向对 side 不利的方向移动 frac 的比例
+// 因为 BUY 为 -1，BUY 的价格会上涨，SELL 的价格会下跌
+func slip(side exch.OrderSide, price exch.Decimal, frac float64) exch.Decimal {
+	return price.MulFloat(1 - float64(side)*frac)
+}
+
+// FixedBps 会让成交价向不利的方向固定移动 Bps 个基点
+type FixedBps struct {
+	Bps float64
+}
+
+// Price implements SlippageModel
+func (f FixedBps) Price(side exch.OrderSide, tick exch.Tick, quantity exch.Decimal) exch.Decimal {
+	return slip(side, tick.Price, f.Bps/10000)
+}
+
+// VolumeSlippage 的滑点与订单在 tick 成交量中的占比成正比
+// 订单吃掉全部成交量时，价格移动 Rate 的比例
+type VolumeSlippage struct {
+	Rate float64
+}
+
+// Price implements SlippageModel
+func (v VolumeSlippage) Price(side exch.OrderSide, tick exch.Tick, quantity exch.Decimal) exch.Decimal {
+	return slip(side, tick.Price, v.Rate*share(tick, quantity))
+}
+
+// SqrtImpact 是平方根冲击模型
+// 价格移动的比例为 Coef * sqrt(quantity / tick.Volume)
+type SqrtImpact struct {
+	Coef float64
+}
+
+// Price implements SlippageModel
+func (s SqrtImpact) Price(side exch.OrderSide, tick exch.Tick, quantity exch.Decimal) exch.Decimal {
+	return slip(side, tick.Price, s.Coef*math.Sqrt(share(tick, quantity)))
+}
+
+// share 返回 quantity 在 tick 成交量中的占比，最大为 1
+func share(tick exch.Tick, quantity exch.Decimal) float64 {
+	if tick.Volume <= 0 {
+		return 1
+	}
+	return math.Min(quantity.Float64()/tick.Volume.Float64(), 1)
+}
+
+// NoisySlippage 会在 Base 的滑点上，
+// 再向不利的方向随机移动 [0, Bps) 个基点
+// 随机数来自 seed，相同的 seed 会得到相同的回测结果
+type NoisySlippage struct {
+	Base SlippageModel
+	Bps  float64
+	rand *rand.Rand
+}
+
+// NewNoisySlippage 返回一个 NoisySlippage
+// base 为 nil 时，只有随机的滑点
+func NewNoisySlippage(base SlippageModel, bps float64, seed int64) *NoisySlippage {
+	if base == nil {
+		base = FixedBps{}
+	}
+	return &NoisySlippage{
+		Base: base,
+		Bps:  bps,
+		rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Price implements SlippageModel
+func (n *NoisySlippage) Price(side exch.OrderSide, tick exch.Tick, quantity exch.Decimal) exch.Decimal {
+	price := n.Base.Price(side, tick, quantity)
+	return slip(side, price, n.rand.Float64()*n.Bps/10000)
+}
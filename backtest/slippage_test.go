@@ -0,0 +1,76 @@
+package backtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jujili/exch"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_SlippageModel(t *testing.T) {
+	Convey("SlippageModel 总是让价格向不利的方向移动", t, func() {
+		tick := exch.NewTick(1, time.Now(), 100, 10)
+		Convey("FixedBps", func() {
+			fs := FixedBps{Bps: 10}
+			So(fs.Price(exch.BUY, tick, exch.NewDecimal(1)), ShouldEqual, exch.NewDecimal(100.1))
+			So(fs.Price(exch.SELL, tick, exch.NewDecimal(1)), ShouldEqual, exch.NewDecimal(99.9))
+		})
+		Convey("VolumeSlippage", func() {
+			vs := VolumeSlippage{Rate: 0.01}
+			So(vs.Price(exch.BUY, tick, exch.NewDecimal(5)), ShouldEqual, exch.NewDecimal(100.5))
+			So(vs.Price(exch.SELL, tick, exch.NewDecimal(10)), ShouldEqual, exch.NewDecimal(99))
+			Convey("占比最多为 1", func() {
+				So(vs.Price(exch.SELL, tick, exch.NewDecimal(20)), ShouldEqual, exch.NewDecimal(99))
+			})
+		})
+		Convey("SqrtImpact", func() {
+			si := SqrtImpact{Coef: 0.01}
+			So(si.Price(exch.BUY, tick, exch.NewDecimal(2.5)), ShouldEqual, exch.NewDecimal(100.5))
+			So(si.Price(exch.SELL, tick, exch.NewDecimal(10)), ShouldEqual, exch.NewDecimal(99))
+		})
+		Convey("NoisySlippage", func() {
+			prices := func(seed int64) []exch.Decimal {
+				ns := NewNoisySlippage(FixedBps{Bps: 10}, 10, seed)
+				res := make([]exch.Decimal, 0, 10)
+				for i := 0; i < 10; i++ {
+					price := ns.Price(exch.BUY, tick, exch.NewDecimal(1))
+					So(price, ShouldBeBetweenOrEqual, exch.NewDecimal(100.1), exch.NewDecimal(100.2))
+					res = append(res, price)
+				}
+				return res
+			}
+			Convey("相同的 seed 会得到相同的滑点", func() {
+				So(prices(1), ShouldResemble, prices(1))
+			})
+			Convey("不同的 seed 会得到不同的滑点", func() {
+				So(prices(1), ShouldNotResemble, prices(2))
+			})
+		})
+	})
+}
+
+func Test_orderList_slippage(t *testing.T) {
+	Convey("orderList.match 会使用 slippage", t, func() {
+		BtcUsdtOrder := exch.NewOrder("BTCUSDT", "BTC", "USDT")
+		ol := newOrderList()
+		ol.slippage = FixedBps{Bps: 100}
+		Convey("MARKET 订单以滑点后的价格成交", func() {
+			ol.push(de(BtcUsdtOrder.With(exch.Market(exch.BUY, 202))))
+			fills := ol.match(exch.NewTick(1, time.Now(), 100, 10))
+			So(len(fills), ShouldEqual, 1)
+			trade := fills[0].trade
+			So(trade.Price, ShouldEqual, exch.NewDecimal(101))
+			So(trade.Quantity, ShouldEqual, exch.NewDecimal(2))
+		})
+		Convey("滑点后的价格不满足限价时，LIMIT 订单不会成交", func() {
+			ol.push(de(BtcUsdtOrder.With(exch.Limit(exch.SELL, 1, 100))))
+			So(ol.match(exch.NewTick(1, time.Now(), 100.5, 10)), ShouldBeEmpty)
+			Convey("满足限价时，以限价成交", func() {
+				fills := ol.match(exch.NewTick(2, time.Now(), 102, 10))
+				So(len(fills), ShouldEqual, 1)
+				So(fills[0].trade.Price, ShouldEqual, exch.NewDecimal(100))
+			})
+		})
+	})
+}
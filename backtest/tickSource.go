@@ -0,0 +1,48 @@
+package backtest
+
+import (
+	"context"
+	"io"
+	"log"
+
+	"github.com/jujili/exch"
+)
+
+// TickSourceService 会把 r 中的 tick 按照顺序发送到 "tick" 话题中，tick 使用 exch.Gob 编码
+// r 读完以后，会关闭 pub，
+// TickBarService 和 NewBackTest 等订阅者会把它当作数据结束的信号
+// r 返回 io.EOF 以外的错误时，会记录日志，然后同样关闭 pub
+// ctx 结束时，会直接退出，不会关闭 pub
+// 例如，从 CSV 文件中读取 tick
+//
+//	file, _ := os.Open("ticks.csv")
+//	TickSourceService(ctx, ps, exch.NewCSVTickReader(file, exch.CSVFormat{Header: true}))
+func TickSourceService(ctx context.Context, pub Publisher, r exch.TickReader) {
+	log.Println(`TickSourceService 会把 tick 发送到 "tick" 话题中`)
+	enc := exch.EncMsgFunc(exch.Gob)
+	go func() {
+		count := 0
+		for {
+			if err := ctx.Err(); err != nil {
+				log.Println("TickSourceService Down: ", err)
+				return
+			}
+			tick, err := r.Read()
+			if err != nil {
+				if err != io.EOF {
+					log.Println("TickSourceService 读取 tick 出错: ", err)
+				}
+				break
+			}
+			if err := pub.Publish("tick", enc(tick)); err != nil {
+				log.Println("TickSourceService 发送 tick 出错: ", err)
+				break
+			}
+			count++
+		}
+		log.Printf("TickSourceService 发送了 %d 个 tick", count)
+		if err := pub.Close(); err != nil {
+			log.Println("TickSourceService 关闭 Publisher 出错: ", err)
+		}
+	}()
+}
@@ -0,0 +1,34 @@
+package backtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/jujili/exch"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_TickSourceService(t *testing.T) {
+	Convey("TickSourceService 会按照顺序发送 tick，然后关闭 Publisher", t, func() {
+		ctx := context.Background()
+		config := gochannel.Config{BlockPublishUntilSubscriberAck: true}
+		ps := gochannel.NewGoChannel(config, watermill.NopLogger{})
+		ticks, err := ps.Subscribe(ctx, "tick")
+		So(err, ShouldBeNil)
+		data := "ID,Date,Price,Volume\n1,1620000000,100,1\n2,1620000001,101,1\n3,1620000002,102,1\n"
+		r := exch.NewCSVTickReader(strings.NewReader(data), exch.CSVFormat{Header: true, TimeFormat: exch.UnixSecond})
+		TickSourceService(ctx, ps, r)
+		dec := exch.DecMsgFunc()
+		ids := make([]int64, 0, 3)
+		for msg := range ticks {
+			var tick exch.Tick
+			So(dec(msg, &tick), ShouldBeNil)
+			msg.Ack()
+			ids = append(ids, tick.ID)
+		}
+		So(ids, ShouldResemble, []int64{1, 2, 3})
+	})
+}
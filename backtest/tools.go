@@ -1,10 +1,60 @@
 package backtest
 
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// DeadLetterTopic 是无法解码的消息被转发到的话题
+const DeadLetterTopic = "deadLetter"
+
+// 转发到 DeadLetterTopic 的消息，会在 Metadata 中记录以下内容
+const (
+	// DeadLetterTopicKey 记录了消息原来所在的话题
+	DeadLetterTopicKey = "deadLetterTopic"
+	// DeadLetterReasonKey 记录了消息无法解码的原因
+	DeadLetterReasonKey = "deadLetterReason"
+)
+
 // NextIDFunc 返回的函数，可以生成连续的 ID
+// 返回的函数可以并发调用
+// 通过 WithIDFunc 交给 Kernel，可以让回测中的订单 ID 从 1 开始连续递增
 func NextIDFunc() func() int64 {
 	id := int64(0)
 	return func() int64 {
-		id++
-		return id
+		return atomic.AddInt64(&id, 1)
+	}
+}
+
+// newDeadLetter 返回的函数，会把从 topic 话题收到的，无法解码的 msg 转发到 pub 的 DeadLetterTopic 话题
+// 转发以后，msg 会被 Nack，告诉 Pubsub 这条消息没有被处理
+// gochannel 会立即重发被 Nack 的消息，而无法解码的消息永远无法解码，
+// 所以，再次收到已经转发过的消息时，只会 Ack 它，不会再次转发，也不会无限地重发下去
+// 每个订阅都需要自己的 newDeadLetter，返回的函数可以并发调用
+func newDeadLetter(pub Publisher) func(topic string, msg *message.Message, err error) {
+	var mutex sync.Mutex
+	// nacked 记录了已经转发并 Nack 过，还没有重发回来的消息
+	nacked := make(map[string]bool, 8)
+	return func(topic string, msg *message.Message, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		key := topic + "/" + msg.UUID
+		if nacked[key] {
+			delete(nacked, key)
+			msg.Ack()
+			return
+		}
+		log.Printf(`"%s" 话题的消息 %s 无法解码: %s`, topic, msg.UUID, err)
+		dead := msg.Copy()
+		dead.Metadata.Set(DeadLetterTopicKey, topic)
+		dead.Metadata.Set(DeadLetterReasonKey, err.Error())
+		if err := pub.Publish(DeadLetterTopic, dead); err != nil {
+			log.Println("无法发布到死信话题:", err)
+		}
+		nacked[key] = true
+		msg.Nack()
 	}
 }
@@ -1,7 +1,15 @@
 package backtest
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/jujili/exch"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -14,3 +22,88 @@ func Test_NextIDFunc(t *testing.T) {
 		}
 	})
 }
+
+func Test_deadLetter(t *testing.T) {
+	Convey("deadLetter 会把消息转发到 DeadLetterTopic 话题", t, func() {
+		rec := newRecorder()
+		deadLetter := newDeadLetter(rec)
+		msg := message.NewMessage(watermill.NewUUID(), []byte("bad"))
+		deadLetter("tick", msg, errors.New("无法解码"))
+		msgs := rec.topic(DeadLetterTopic)
+		So(len(msgs), ShouldEqual, 1)
+		So(msgs[0].UUID, ShouldEqual, msg.UUID)
+		So(string(msgs[0].Payload), ShouldEqual, "bad")
+		So(msgs[0].Metadata.Get(DeadLetterTopicKey), ShouldEqual, "tick")
+		So(msgs[0].Metadata.Get(DeadLetterReasonKey), ShouldEqual, "无法解码")
+		Convey("原来的消息会被 Nack", func() {
+			select {
+			case <-msg.Nacked():
+			default:
+				So("msg 没有被 Nack", ShouldBeEmpty)
+			}
+		})
+		Convey("重发回来的消息会被 Ack，不会再次转发", func() {
+			again := msg.Copy()
+			deadLetter("tick", again, errors.New("无法解码"))
+			select {
+			case <-again.Acked():
+			default:
+				So("again 没有被 Ack", ShouldBeEmpty)
+			}
+			So(len(rec.topic(DeadLetterTopic)), ShouldEqual, 1)
+		})
+	})
+}
+
+func Test_NewBackTest_deadLetter(t *testing.T) {
+	Convey("NewBackTest 不会处理无法解码的订单", t, func() {
+		// NewBackTest 在 ctx 结束后不会退出，所以不取消 ctx
+		ctx := context.Background()
+		ps := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+		dead, err := ps.Subscribe(ctx, DeadLetterTopic)
+		So(err, ShouldBeNil)
+		updates, err := ps.Subscribe(ctx, "orderUpdate")
+		So(err, ShouldBeNil)
+		balance := exch.NewBalances(exch.NewAsset("USDT", 100000, 0))
+		NewBackTest(ctx, ps, balance)
+		So(ps.Publish("order", message.NewMessage(watermill.NewUUID(), []byte("bad"))), ShouldBeNil)
+		order := exch.NewOrder("BTCUSDT", "BTC", "USDT").With(exch.Limit(exch.BUY, 1, 10000))
+		So(ps.Publish("order", message.NewMessage(watermill.NewUUID(), exch.EncFunc()(order))), ShouldBeNil)
+		msg := <-dead
+		msg.Ack()
+		So(msg.Metadata.Get(DeadLetterTopicKey), ShouldEqual, "order")
+		Convey("之后的订单依然会被受理", func() {
+			msg := <-updates
+			msg.Ack()
+			o := exch.DecOrderFunc()(msg.Payload)
+			So(o.ID, ShouldEqual, order.ID)
+			So(o.Status, ShouldEqual, exch.NEW)
+		})
+		Convey("gochannel 重发被 Nack 的消息时，不会再次转发", func() {
+			select {
+			case msg := <-dead:
+				msg.Ack()
+				So("又收到了死信", ShouldBeEmpty)
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	})
+}
+
+func Test_NewBackTest_codec(t *testing.T) {
+	Convey("NewBackTest 会按照 message 的 Codec 解码", t, func() {
+		ctx := context.Background()
+		ps := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+		updates, err := ps.Subscribe(ctx, "orderUpdate")
+		So(err, ShouldBeNil)
+		balance := exch.NewBalances(exch.NewAsset("USDT", 100000, 0))
+		NewBackTest(ctx, ps, balance)
+		order := exch.NewOrder("BTCUSDT", "BTC", "USDT").With(exch.Limit(exch.BUY, 1, 10000))
+		So(ps.Publish("order", exch.EncMsgFunc(exch.Protobuf)(order)), ShouldBeNil)
+		msg := <-updates
+		msg.Ack()
+		o := exch.DecOrderFunc()(msg.Payload)
+		So(o.ID, ShouldEqual, order.ID)
+		So(o.Status, ShouldEqual, exch.NEW)
+	})
+}
@@ -3,6 +3,7 @@ package backtest
 import (
 	"context"
 	"log"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/jujili/exch"
@@ -29,51 +30,215 @@ type Subscriber interface {
 
 // BackTest 是一个模拟的交易中心
 type BackTest struct {
+	// books 按照 Order.Symbol 分别存放订单
+	books map[string]*book
+	// symbols 记录了 books 创建的顺序，让撮合的顺序是确定的
+	symbols []string
+	bm      *balanceManager
+	pub     Publisher
+	// codec 是发布 message 时使用的编码
+	codec     exch.Codec
+	encTrade  func(interface{}) *message.Message
+	encCancel func(interface{}) *message.Message
+	encOrder  func(interface{}) *message.Message
+	// now 是最新的 tick 的时间，也就是回测中的当前时间
+	now time.Time
+	// lastPrice 是最新的没有 Symbol 的 tick 的价格
+	// 新建的 book 会以此作为自己的 lastPrice
+	lastPrice exch.Decimal
+	fee       FeeModel
+	// slippage 为 nil 时，没有滑点
+	slippage SlippageModel
+	// latency 为 nil 时，订单在提交后立即被受理
+	latency LatencyModel
+	// pending 存放还没有到达交易中心的订单
+	pending delayQueue
+	// clientIDs 记录了已经受理过的 ClientOrderID
+	clientIDs map[string]bool
+	// symbolInfos 不为 nil 时，订单需要符合 exchange 交易所的交易规则
+	exchange    exch.Name
+	symbolInfos exch.Symbols
+	// calendar 不为 nil 时，只在交易时段中撮合订单
+	calendar *exch.Calendar
+}
+
+func newBackTest(pub Publisher, balance exch.Balance, options ...func(*BackTest)) *BackTest {
+	bt := &BackTest{
+		books:     make(map[string]*book, 64),
+		clientIDs: make(map[string]bool, 1024),
+		bm:        newBalanceManager(pub, balance),
+		pub:       pub,
+		codec:     exch.Gob,
+		fee:       MakerTaker{Maker: 0.001, Taker: 0.001},
+	}
+	for _, option := range options {
+		option(bt)
+	}
+	bt.encTrade = exch.EncMsgFunc(bt.codec)
+	bt.encCancel = exch.EncMsgFunc(bt.codec)
+	bt.encOrder = exch.EncMsgFunc(bt.codec)
+	bt.bm.enc = exch.EncMsgFunc(bt.codec)
+	return bt
+}
+
+// book 返回 symbol 的 book，没有的话，就新建一个
+func (bt *BackTest) book(symbol string) *book {
+	if b, ok := bt.books[symbol]; ok {
+		return b
+	}
+	var info *exch.SymbolInfo
+	if si, ok := bt.symbolInfos.Get(bt.exchange, symbol); ok {
+		info = &si
+	}
+	b := newBook(symbol, bt.slippage, info)
+	b.lastPrice = bt.lastPrice
+	bt.books[symbol] = b
+	bt.symbols = append(bt.symbols, symbol)
+	return b
+}
+
+// price 返回 symbol 的最新价，没有 book 的话，不会新建 book
+func (bt *BackTest) price(symbol string) exch.Decimal {
+	if b, ok := bt.books[symbol]; ok {
+		return b.lastPrice
+	}
+	return bt.lastPrice
+}
+
+// ticked 返回 tick 需要撮合的 book
+// tick.Symbol 为空的话，tick 会撮合全部的 book
+func (bt *BackTest) ticked(tick exch.Tick) []*book {
+	if tick.Symbol != "" {
+		return []*book{bt.book(tick.Symbol)}
+	}
+	bt.lastPrice = tick.Price
+	res := make([]*book, 0, len(bt.symbols))
+	for _, symbol := range bt.symbols {
+		res = append(res, bt.books[symbol])
+	}
+	return res
+}
+
+// WithFeeModel 会让 BackTest 使用 fm 计算手续费
+// 默认的手续费是 maker 和 taker 都为 0.001 的 MakerTaker
+func WithFeeModel(fm FeeModel) func(*BackTest) {
+	return func(bt *BackTest) {
+		bt.fee = fm
+	}
+}
+
+// WithSlippage 会让 BackTest 使用 sm 调整 MARKET 订单的成交价，
+// 以及判断 LIMIT 订单能否成交
+// 默认没有滑点
+func WithSlippage(sm SlippageModel) func(*BackTest) {
+	return func(bt *BackTest) {
+		bt.slippage = sm
+	}
+}
+
+// WithLatency 会让订单在提交 lm.Delay 以后，才被 BackTest 受理
+// 订单的提交时间是 BackTest 收到订单时，最新的 tick 的时间
+// 默认没有延迟
+func WithLatency(lm LatencyModel) func(*BackTest) {
+	return func(bt *BackTest) {
+		bt.latency = lm
+	}
+}
+
+// WithCodec 会让 BackTest 使用 c 编码发布的 message
+// 默认使用 exch.Gob
+func WithCodec(c exch.Codec) func(*BackTest) {
+	return func(bt *BackTest) {
+		bt.codec = c
+	}
+}
+
+// WithSymbols 会让 BackTest 按照 symbols 中 name 交易所的交易规则检查订单
+// 不符合 PRICE_FILTER、LOT_SIZE 或 MIN_NOTIONAL 的订单，以及
+// symbols 中没有登记的 Symbol 的订单，会被 REJECTED
+// 每次成交的数量都会向下取整到 StepSize 的整数倍
+// 默认不检查交易规则
+func WithSymbols(name exch.Name, symbols exch.Symbols) func(*BackTest) {
+	return func(bt *BackTest) {
+		bt.exchange = name
+		bt.symbolInfos = symbols
+	}
+}
+
+// WithCalendar 会让 BackTest 只撮合交易时段中的 tick
+// 休市时的 tick 不会触发止损单，也不会成交，
+// IOC 和 FOK 订单会等到开盘以后的第一个 tick 再撮合
+// 默认全天都可以成交
+func WithCalendar(c *exch.Calendar) func(*BackTest) {
+	return func(bt *BackTest) {
+		bt.calendar = c
+	}
 }
 
 // NewBackTest returns a new trade center - bt
-// bt subscribe "tick", "order" and "cancelAllOrders" topics from pubsub
+// bt subscribe "tick", "order", "cancelOrder",
+// "cancelSymbolOrders" and "cancelAllOrders" topics from pubsub
 // and
-// bt publish "balance" topic
-//
-// TODO: 在成交的时候，还需要发布 "traded" 具体的成交信息。
-func NewBackTest(ctx context.Context, ps Pubsub, balance exch.Balance) {
-	sells := newOrderList()
-	buys := newOrderList()
-
+// bt publish "balance", "traded", "orderUpdate" and "cancelResult" topics
+// 每个 Order.Symbol 都有自己的订单簿，只会与相同 Symbol 的 tick 撮合
+// 没有 Symbol 的 tick 会与全部的订单簿撮合
+// 所有的订单簿共用一个 balance
+// 订单每次部分成交或完全成交，都会在 "traded" 话题发布一条 exch.Trade
+// 订单的状态每次发生变化，都会在 "orderUpdate" 话题发布一条 exch.Order
+// 每个撤单请求，都会在 "cancelResult" 话题得到 exch.CancelResult 回报
+// 收到的 message 按照 Metadata 中记录的 exch.Codec 解码，
+// 发布的 message 使用 WithCodec 设置的 exch.Codec 编码
+// 无法解码的消息会被转发到 DeadLetterTopic 话题，不会被处理
+// STOP_LOSS 和 TAKE_PROFIT 系列的订单，在 tick 的价格触及 StopPrice 时，
+// 会转换成 MARKET 或 LIMIT 订单，并参与这个 tick 的撮合
+// LIMIT_MAKER 订单如果在提交时与最新价交叉，会被拒绝；
+// 挂单成功的 LIMIT_MAKER 订单与 LIMIT 订单一样撮合，并且总是 maker
+// IOC 订单与提交后的第一个 tick 撮合后，没有成交的部分会 EXPIRED
+// FOK 订单如果不能与提交后的第一个 tick 全部成交，会被 REJECTED
+// GTD 订单在 tick 的时间达到 ExpireTime 时会 EXPIRED
+// ClientOrderID 与已经受理的订单重复的订单，会被 REJECTED
+// 设置了 WithSymbols 的话，不符合交易规则的订单，会被 REJECTED
+// 设置了 WithLatency 的话，订单要等到 tick 的时间达到提交时间加上延迟以后，
+// 才会被受理，并参与撮合
+// options 可以修改 bt 的默认设置，例如 WithFeeModel 和 WithSlippage
+func NewBackTest(ctx context.Context, ps Pubsub, balance exch.Balance, options ...func(*BackTest)) {
 	ticks, err := ps.Subscribe(ctx, "tick")
 	if err != nil {
 		panic(err)
 	}
 
-	// bars, err := ps.Subscribe(ctx, "bar")
-	// if err != nil {
-	// 	panic(err)
-	// }
-
 	orders, err := ps.Subscribe(ctx, "order")
 	if err != nil {
 		panic(err)
 	}
 
-	// REVIEW:还没有想好如何在回测的时候，维护好策略和回测中心两边的订单。
-	// 以便于删除单个订单。
-	// 所以，就只好全部都删除了算了。
-	// 但是全部删除也不是什么坏事。
-	// cancelAllOrders, err := ps.Subscribe(ctx, "cancelAllOrders")
-	// if err != nil {
-	// panic(err)
-	// }
+	cancelOrders, err := ps.Subscribe(ctx, "cancelOrder")
+	if err != nil {
+		panic(err)
+	}
+
+	cancelSymbolOrders, err := ps.Subscribe(ctx, "cancelSymbolOrders")
+	if err != nil {
+		panic(err)
+	}
+
+	cancelAllOrders, err := ps.Subscribe(ctx, "cancelAllOrders")
+	if err != nil {
+		panic(err)
+	}
 
-	decOrder := decOrderFunc()
-	decTick := exch.DecTickFunc()
+	decOrder := exch.DecMsgFunc()
+	decTick := exch.DecMsgFunc()
+	decCancel := exch.DecMsgFunc()
+	deadLetter := newDeadLetter(ps)
 
 	go func() {
-		bm := newBalanceManager(ps, balance)
+		bt := newBackTest(ps, balance, options...)
 		// 空更新一下，是为了能够让 balanceService 可以获取到 Balance 的数值
-		bm.update([]exch.Asset{}...)
+		bt.bm.update([]exch.Asset{}...)
+		// 订阅了 5 个话题，全部关闭后，才退出
 		count := 0
-		for count < 2 {
+		for count < 5 {
 			select {
 			case <-ctx.Done():
 				log.Println("ctx.Done", ctx.Err())
@@ -83,52 +248,349 @@ func NewBackTest(ctx context.Context, ps Pubsub, balance exch.Balance) {
 					ticks = nil
 					continue
 				}
-				tick := decTick(msg.Payload)
+				var tick exch.Tick
+				if err := decTick(msg, &tick); err != nil {
+					deadLetter("tick", msg, err)
+					continue
+				}
 				msg.Ack()
-				as := make([]exch.Asset, 0, 32)
-				if !buys.isEmpty() {
-					// log.Println("before match", buys, tick)
-					as = append(as, buys.match(tick)...)
-					// log.Println("After  match", buys)
+				bt.onTick(tick)
+			case msg, ok := <-orders:
+				if !ok {
+					count++
+					orders = nil
+					continue
 				}
-				if !sells.isEmpty() {
-					// log.Println("before match", sells, tick)
-					as = append(as, sells.match(tick)...)
-					// log.Println("After  match", sells)
+				o := &order{}
+				if err := decOrder(msg, &o.Order); err != nil {
+					deadLetter("order", msg, err)
+					continue
 				}
-				if len(as) > 0 {
-					// 收取手续费
-					fee := 0.001 // 交易手续费
-					keep := 1 - fee
-					for i, a := range as {
-						as[i] = exch.NewAsset(a.Name, a.Free*keep, a.Locked*keep)
-					}
-					bm.update(as...)
+				msg.Ack()
+				bt.onOrder(o)
+			case msg, ok := <-cancelOrders:
+				if !ok {
+					count++
+					cancelOrders = nil
+					continue
 				}
-			case msg, ok := <-orders:
+				var c exch.CancelOrder
+				if err := decCancel(msg, &c); err != nil {
+					deadLetter("cancelOrder", msg, err)
+					continue
+				}
+				msg.Ack()
+				bt.cancelOrder(c.ID)
+			case msg, ok := <-cancelSymbolOrders:
 				if !ok {
 					count++
-					orders = nil
+					cancelSymbolOrders = nil
+					continue
+				}
+				var c exch.CancelOrder
+				if err := decCancel(msg, &c); err != nil {
+					deadLetter("cancelSymbolOrders", msg, err)
 					continue
 				}
-				order := decOrder(msg.Payload)
 				msg.Ack()
-				if order.Side == exch.BUY {
-					bm.update(buys.push(order))
-				} else {
-					bm.update(sells.push(order))
+				bt.cancelSymbolOrders(c.Symbol)
+			case msg, ok := <-cancelAllOrders:
+				if !ok {
+					count++
+					cancelAllOrders = nil
+					continue
 				}
-				// TODO: 添加取消订单的功能
-				// case msg := <-cancelAllOrders:
-				// msg.Ack()
-				// for !buys.isEmpty() {
-				// bm.update(buys.pop().cancel2Free())
-				// }
-				// for !sells.isEmpty() {
-				// bm.update(sells.pop().cancel2Free())
-				// }
+				msg.Ack()
+				bt.cancelAllOrders()
 			}
 		}
 		log.Println("backtest center is over")
 	}()
 }
+
+func (bt *BackTest) onTick(tick exch.Tick) {
+	bt.activate(tick.Date)
+	bt.now = tick.Date
+	bt.expire(tick.Date)
+	// 休市时不成交
+	if bt.calendar != nil && !bt.calendar.IsOpen(tick.Date) {
+		return
+	}
+	bs := bt.ticked(tick)
+	fills := make([]fill, 0, 32)
+	for _, b := range bs {
+		b.lastPrice = tick.Price
+		bt.trigger(b, tick.Price)
+		fills = append(fills, b.match(tick)...)
+	}
+	if len(fills) != 0 {
+		bt.settle(fills)
+	}
+	bt.sweep(bs)
+}
+
+// expire 会撤销在 date 之前到期的 GTD 订单
+func (bt *BackTest) expire(date time.Time) {
+	isExpired := func(o *order) bool {
+		return o.TimeInForce == exch.GTD && !o.ExpireTime.After(date)
+	}
+	os := make([]*order, 0, 8)
+	for _, l := range bt.lists() {
+		os = append(os, l.removeIf(isExpired)...)
+	}
+	bt.close(exch.EXPIRED, "", os...)
+}
+
+// sweep 会在撮合以后，撤销还留在 bs 的 buys 和 sells 中的 IOC 和 FOK 订单
+// 因为它们已经与提交后的第一个 tick 撮合过了
+// 按照最新价买不到一个 StepSize 的 MARKET BUY 订单也会被撤销，并解冻剩余的 Capital
+func (bt *BackTest) sweep(bs []*book) {
+	isIOC := func(o *order) bool { return o.TimeInForce == exch.IOC }
+	isFOK := func(o *order) bool { return o.TimeInForce == exch.FOK }
+	for _, b := range bs {
+		isStarved := func(o *order) bool { return b.buys.starved(o, b.lastPrice) }
+		bt.close(exch.EXPIRED, "剩余的资金买不到一个 StepSize", b.buys.removeIf(isStarved)...)
+		for _, l := range []*orderList{b.buys, b.sells} {
+			bt.close(exch.EXPIRED, "", l.removeIf(isIOC)...)
+			bt.close(exch.REJECTED, "FOK 订单无法全部成交", l.removeIf(isFOK)...)
+		}
+	}
+}
+
+// settle 会结算 fills 中的资金变化，并发布成交记录和订单状态
+// 手续费由 bt.fee 计算，并从手续费资产的 Free 中扣除
+func (bt *BackTest) settle(fills []fill) {
+	msgs := make([]*message.Message, 0, len(fills))
+	os := make([]exch.Order, 0, len(fills))
+	for _, f := range fills {
+		os = append(os, f.order)
+		trade := f.trade
+		// 逐个结算，是为了让 bt.fee 看到上一笔成交后的资产
+		trade.Fee, trade.FeeAsset = bt.fee.Fee(trade, bt.bm.Balance)
+		bt.bm.add(f.assets...)
+		if trade.Fee != 0 {
+			bt.bm.add(exch.Asset{Name: trade.FeeAsset, Free: -trade.Fee})
+		}
+		msgs = append(msgs, bt.encTrade(trade))
+	}
+	bt.bm.update()
+	bt.pub.Publish("traded", msgs...)
+	bt.updateOrders(os...)
+}
+
+// trigger 会把被 price 触发的订单，转换成 MARKET 或 LIMIT 订单后，
+// 放入 b 的 buys 或 sells 中等待撮合
+// 触发的订单会参与同一个 tick 的撮合
+func (bt *BackTest) trigger(b *book, price exch.Decimal) {
+	os := b.trigger(price)
+	if len(os) == 0 {
+		return
+	}
+	updates := make([]exch.Order, 0, len(os))
+	for _, o := range os {
+		o.Type = o.baseType()
+		o.UpdateTime = bt.now
+		// 与 accept 一样，触发时就与最新价交叉的 LIMIT 订单是 taker
+		o.isTaker = o.Type == exch.LIMIT && crosses(o, b.lastPrice)
+		// 挂单的时候已经冻结过资金了
+		b.list(o).push(o)
+		updates = append(updates, o.Order)
+	}
+	bt.updateOrders(updates...)
+}
+
+// onOrder 会在 o 到达交易中心后受理 o
+func (bt *BackTest) onOrder(o *order) {
+	// 被受理以前，订单没有状态
+	o.Status = 0
+	if bt.latency != nil {
+		if delay := bt.latency.Delay(o.Order); delay > 0 {
+			bt.pending.push(o, bt.now.Add(delay))
+			return
+		}
+	}
+	bt.accept(o)
+}
+
+// activate 会受理在 date 之前到达交易中心的订单
+// 受理时，使用的是订单到达时的模拟时间，和那时的最新价
+func (bt *BackTest) activate(date time.Time) {
+	for _, d := range bt.pending.popUntil(date) {
+		bt.now = d.live
+		bt.accept(d.order)
+	}
+}
+
+// accept 会检查 o，检查通过后，把 o 放入对应的 book 中
+func (bt *BackTest) accept(o *order) {
+	if reason := bt.check(o); reason != "" {
+		o.Status = exch.REJECTED
+		o.RejectReason = reason
+		o.UpdateTime = bt.now
+		bt.updateOrders(o.Order)
+		return
+	}
+	o.Status = exch.NEW
+	o.UpdateTime = bt.now
+	if o.ClientOrderID != "" {
+		bt.clientIDs[o.ClientOrderID] = true
+	}
+	b := bt.book(o.Symbol)
+	o.isTaker = o.Type == exch.LIMIT && crosses(o, b.lastPrice)
+	bt.bm.update(b.list(o).push(o))
+	bt.updateOrders(o.Order)
+}
+
+// check 会检查 o 能否被受理
+// 不能受理的话，会返回拒绝的原因
+// 只有受理的订单才会新建 book，所以这里不会调用 bt.book
+func (bt *BackTest) check(o *order) string {
+	if t := o.baseType(); t != exch.MARKET && t != exch.LIMIT {
+		return "不支持的订单类型"
+	}
+	if o.Side != exch.BUY && o.Side != exch.SELL {
+		return "未知的订单方向"
+	}
+	if o.isStop() && o.StopPrice <= 0 {
+		return "触发类订单需要设置 StopPrice"
+	}
+	if o.IsEmpty() {
+		return "订单的数量为 0"
+	}
+	if bt.clientIDs[o.ClientOrderID] {
+		// 重复提交的订单不会被再次受理
+		return "重复的 ClientOrderID"
+	}
+	price := bt.price(o.Symbol)
+	if bt.symbolInfos != nil {
+		info, ok := bt.symbolInfos.Get(bt.exchange, o.Symbol)
+		if !ok {
+			return "未知的 Symbol"
+		}
+		if err := info.Check(o.Order, price); err != nil {
+			return err.Error()
+		}
+	}
+	if o.Type == exch.LIMITmaker && crosses(o, price) {
+		return "LIMIT_MAKER 订单会立即成交"
+	}
+	if o.TimeInForce == exch.GTD && !o.ExpireTime.After(bt.now) {
+		return "GTD 订单的 ExpireTime 已经到期"
+	}
+	lock := o.pend2Lock()
+	if bt.bm.Balance[lock.Name].Free < lock.Locked {
+		return lock.Name + " 的可用余额不足"
+	}
+	return ""
+}
+
+// updateOrders 会把 os 发布到 "orderUpdate" 话题
+func (bt *BackTest) updateOrders(os ...exch.Order) {
+	msgs := make([]*message.Message, 0, len(os))
+	for _, o := range os {
+		msgs = append(msgs, bt.encOrder(o))
+	}
+	bt.pub.Publish("orderUpdate", msgs...)
+}
+
+// lists 返回全部 book 中存放订单的 orderList
+func (bt *BackTest) lists() []*orderList {
+	res := make([]*orderList, 0, 4*len(bt.symbols))
+	for _, symbol := range bt.symbols {
+		res = append(res, bt.books[symbol].lists()...)
+	}
+	return res
+}
+
+// cancelOrder 会撤销 ID 为 id 的订单
+func (bt *BackTest) cancelOrder(id int64) {
+	var o *order
+	for _, l := range bt.lists() {
+		if o = l.remove(id); o != nil {
+			break
+		}
+	}
+	if o == nil {
+		isID := func(o *order) bool { return o.ID == id }
+		if os := bt.pending.removeIf(isID); len(os) != 0 {
+			o = os[0]
+		}
+	}
+	if o == nil {
+		bt.rejectCancel(exch.CancelResult{ID: id, Reason: "没有找到 ID 对应的订单"})
+		return
+	}
+	bt.canceled(o)
+}
+
+// cancelSymbolOrders 会撤销 symbol 的全部订单
+func (bt *BackTest) cancelSymbolOrders(symbol string) {
+	isSymbol := func(o *order) bool {
+		return o.Symbol == symbol
+	}
+	os := make([]*order, 0, 16)
+	for _, l := range bt.lists() {
+		os = append(os, l.removeIf(isSymbol)...)
+	}
+	os = append(os, bt.pending.removeIf(isSymbol)...)
+	if len(os) == 0 {
+		bt.rejectCancel(exch.CancelResult{Symbol: symbol, Reason: "没有找到 Symbol 对应的订单"})
+		return
+	}
+	bt.canceled(os...)
+}
+
+// cancelAllOrders 会撤销全部的订单
+func (bt *BackTest) cancelAllOrders() {
+	os := make([]*order, 0, 16)
+	for _, l := range bt.lists() {
+		for !l.isEmpty() {
+			os = append(os, l.pop())
+		}
+	}
+	isAny := func(o *order) bool { return true }
+	os = append(os, bt.pending.removeIf(isAny)...)
+	if len(os) == 0 {
+		bt.rejectCancel(exch.CancelResult{Reason: "没有可以撤销的订单"})
+		return
+	}
+	bt.canceled(os...)
+}
+
+// canceled 会关闭 os，并逐个发送撤单成功的回报
+func (bt *BackTest) canceled(os ...*order) {
+	bt.close(exch.CANCELED, "", os...)
+	msgs := make([]*message.Message, 0, len(os))
+	for _, o := range os {
+		r := exch.CancelResult{ID: o.ID, Symbol: o.Symbol, IsCanceled: true}
+		msgs = append(msgs, bt.encCancel(r))
+	}
+	bt.pub.Publish("cancelResult", msgs...)
+}
+
+// close 会释放已经从 orderList 中移除的 os 所冻结的资金，
+// 并把 os 的状态改成 status 后发布出去
+func (bt *BackTest) close(status exch.OrderStatus, reason string, os ...*order) {
+	if len(os) == 0 {
+		return
+	}
+	as := make([]exch.Asset, 0, len(os))
+	updates := make([]exch.Order, 0, len(os))
+	for _, o := range os {
+		// Status 为 0 的订单还在 pending 中，没有冻结过资金
+		if o.Status != 0 {
+			as = append(as, o.cancel2Free())
+		}
+		o.Status = status
+		o.RejectReason = reason
+		o.UpdateTime = bt.now
+		updates = append(updates, o.Order)
+	}
+	bt.bm.update(as...)
+	bt.updateOrders(updates...)
+}
+
+func (bt *BackTest) rejectCancel(r exch.CancelResult) {
+	msg := bt.encCancel(r)
+	bt.pub.Publish("cancelResult", msg)
+}
@@ -0,0 +1,570 @@
+package backtest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/jujili/exch"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// recorder 会记录发布到各个话题的 message
+type recorder struct {
+	sync.Mutex
+	msgs map[string][]*message.Message
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		msgs: make(map[string][]*message.Message, 8),
+	}
+}
+
+func (r *recorder) Publish(topic string, msgs ...*message.Message) error {
+	r.Lock()
+	defer r.Unlock()
+	r.msgs[topic] = append(r.msgs[topic], msgs...)
+	return nil
+}
+
+func (r *recorder) Close() error {
+	return nil
+}
+
+func (r *recorder) topic(topic string) []*message.Message {
+	r.Lock()
+	defer r.Unlock()
+	return r.msgs[topic]
+}
+
+func (r *recorder) cancelResults() []exch.CancelResult {
+	dec := exch.DecCancelResultFunc()
+	msgs := r.topic("cancelResult")
+	res := make([]exch.CancelResult, 0, len(msgs))
+	for _, msg := range msgs {
+		res = append(res, dec(msg.Payload))
+	}
+	return res
+}
+
+func (r *recorder) orderUpdates() []exch.Order {
+	dec := exch.DecOrderFunc()
+	msgs := r.topic("orderUpdate")
+	res := make([]exch.Order, 0, len(msgs))
+	for _, msg := range msgs {
+		res = append(res, *dec(msg.Payload))
+	}
+	return res
+}
+
+func Test_BackTest_orderUpdate(t *testing.T) {
+	Convey("BackTest 会发布订单状态的变化", t, func() {
+		rec := newRecorder()
+		balance := exch.NewBalances(
+			exch.NewAsset("BTC", 10, 0),
+			exch.NewAsset("USDT", 100000, 0),
+		)
+		bt := newBackTest(rec, balance)
+		BtcUsdtOrder := exch.NewOrder("BTCUSDT", "BTC", "USDT")
+		date := time.Now()
+		bt.onTick(exch.NewTick(1, date, 10000, 1))
+		Convey("受理的订单是 NEW 状态", func() {
+			ls := de(BtcUsdtOrder.With(exch.Limit(exch.SELL, 2, 10000)))
+			bt.onOrder(ls)
+			us := rec.orderUpdates()
+			So(len(us), ShouldEqual, 1)
+			So(us[0].ID, ShouldEqual, ls.ID)
+			So(us[0].Status, ShouldEqual, exch.NEW)
+			So(us[0].UpdateTime.Equal(date), ShouldBeTrue)
+			Convey("部分成交后是 PARTIALLY_FILLED 状态", func() {
+				next := date.Add(time.Second)
+				bt.onTick(exch.NewTick(2, next, 11000, 0.5))
+				us := rec.orderUpdates()
+				So(len(us), ShouldEqual, 2)
+				So(us[1].Status, ShouldEqual, exch.PARTIALLYfilled)
+				So(us[1].FilledQuantity, ShouldEqual, exch.NewDecimal(0.5))
+				So(us[1].AvgPrice, ShouldEqual, exch.NewDecimal(10000))
+				So(us[1].UpdateTime.Equal(next), ShouldBeTrue)
+				Convey("全部成交后是 FILLED 状态", func() {
+					bt.onTick(exch.NewTick(3, next, 12000, 10))
+					us := rec.orderUpdates()
+					So(len(us), ShouldEqual, 3)
+					So(us[2].Status, ShouldEqual, exch.FILLED)
+					So(us[2].FilledQuantity, ShouldEqual, exch.NewDecimal(2))
+					So(us[2].AssetQuantity, ShouldEqual, exch.NewDecimal(0))
+				})
+			})
+			Convey("撤单后是 CANCELED 状态", func() {
+				bt.cancelOrder(ls.ID)
+				us := rec.orderUpdates()
+				So(len(us), ShouldEqual, 2)
+				So(us[1].Status, ShouldEqual, exch.CANCELED)
+			})
+		})
+		Convey("可用余额不足的订单是 REJECTED 状态", func() {
+			lb := de(BtcUsdtOrder.With(exch.Limit(exch.BUY, 20, 10000)))
+			bt.onOrder(lb)
+			us := rec.orderUpdates()
+			So(len(us), ShouldEqual, 1)
+			So(us[0].Status, ShouldEqual, exch.REJECTED)
+			So(us[0].RejectReason, ShouldNotBeEmpty)
+			So(bt.book("BTCUSDT").buys.isEmpty(), ShouldBeTrue)
+			So(bt.bm.Balance["USDT"], ShouldResemble, exch.NewAsset("USDT", 100000, 0))
+		})
+		Convey("不支持的订单类型是 REJECTED 状态", func() {
+			lb := de(BtcUsdtOrder.With(exch.Limit(exch.BUY, 1, 10000)))
+			lb.Type = 0
+			bt.onOrder(lb)
+			us := rec.orderUpdates()
+			So(len(us), ShouldEqual, 1)
+			So(us[0].Status, ShouldEqual, exch.REJECTED)
+		})
+	})
+}
+
+func Test_BackTest_stop(t *testing.T) {
+	Convey("BackTest 处理触发类订单", t, func() {
+		rec := newRecorder()
+		balance := exch.NewBalances(
+			exch.NewAsset("BTC", 10, 0),
+			exch.NewAsset("USDT", 100000, 0),
+		)
+		bt := newBackTest(rec, balance)
+		BtcUsdtOrder := exch.NewOrder("BTCUSDT", "BTC", "USDT")
+		date := time.Now()
+		ss := de(BtcUsdtOrder.With(exch.StopLoss(exch.SELL, 1, 90)))
+		bt.onOrder(ss)
+		So(bt.book("BTCUSDT").falls.head.next, ShouldEqual, ss)
+		So(bt.bm.Balance["BTC"], ShouldResemble, exch.NewAsset("BTC", 9, 1))
+		Convey("价格没有触及 StopPrice 时，不会成交", func() {
+			bt.onTick(exch.NewTick(1, date, 95, 10))
+			So(bt.book("BTCUSDT").falls.head.next, ShouldEqual, ss)
+			So(rec.topic("traded"), ShouldBeEmpty)
+		})
+		Convey("价格触及 StopPrice 后，会变成市价单成交", func() {
+			bt.onTick(exch.NewTick(1, date, 89, 10))
+			So(bt.book("BTCUSDT").falls.isEmpty(), ShouldBeTrue)
+			So(bt.book("BTCUSDT").sells.isEmpty(), ShouldBeTrue)
+			trades := rec.topic("traded")
+			So(len(trades), ShouldEqual, 1)
+			trade := exch.DecTradeFunc()(trades[0].Payload)
+			So(trade.Price, ShouldEqual, exch.NewDecimal(89))
+			So(trade.Quantity, ShouldEqual, exch.NewDecimal(1))
+			us := rec.orderUpdates()
+			So(us[1].Type, ShouldEqual, exch.MARKET)
+			So(us[1].Status, ShouldEqual, exch.NEW)
+			So(us[2].Status, ShouldEqual, exch.FILLED)
+		})
+		Convey("触发时就与最新价交叉的限价单是 taker，可以与同方向主动成交的 tick 成交", func() {
+			sl := de(BtcUsdtOrder.With(exch.StopLossLimit(exch.SELL, 1, 100, 95)))
+			sl.ID++
+			bt.onOrder(sl)
+			bt.onTick(exch.NewTick(1, date, 99, 10, exch.TickSide(exch.SELL)))
+			So(bt.book("BTCUSDT").sells.isEmpty(), ShouldBeTrue)
+			trades := rec.topic("traded")
+			So(len(trades), ShouldEqual, 1)
+			trade := exch.DecTradeFunc()(trades[0].Payload)
+			So(trade.OrderID, ShouldEqual, sl.ID)
+			So(trade.Price, ShouldEqual, exch.NewDecimal(95))
+			So(trade.IsMaker, ShouldBeFalse)
+		})
+		Convey("撤销等待触发的订单，会释放冻结的资金", func() {
+			bt.cancelOrder(ss.ID)
+			So(bt.book("BTCUSDT").falls.isEmpty(), ShouldBeTrue)
+			So(bt.bm.Balance["BTC"], ShouldResemble, exch.NewAsset("BTC", 10, 0))
+		})
+	})
+}
+
+func Test_BackTest_limitMaker(t *testing.T) {
+	Convey("BackTest 处理 LIMIT_MAKER 订单", t, func() {
+		rec := newRecorder()
+		balance := exch.NewBalances(
+			exch.NewAsset("BTC", 10, 0),
+			exch.NewAsset("USDT", 100000, 0),
+		)
+		bt := newBackTest(rec, balance)
+		BtcUsdtOrder := exch.NewOrder("BTCUSDT", "BTC", "USDT")
+		date := time.Now()
+		bt.onTick(exch.NewTick(1, date, 100, 10))
+		Convey("与最新价交叉的订单会被拒绝", func() {
+			lb := de(BtcUsdtOrder.With(exch.LimitMaker(exch.BUY, 1, 100)))
+			bt.onOrder(lb)
+			us := rec.orderUpdates()
+			So(us[0].Status, ShouldEqual, exch.REJECTED)
+			So(us[0].RejectReason, ShouldNotBeEmpty)
+			So(bt.book("BTCUSDT").buys.isEmpty(), ShouldBeTrue)
+		})
+		Convey("挂单成功的订单总是 maker", func() {
+			lb := de(BtcUsdtOrder.With(exch.LimitMaker(exch.BUY, 1, 99)))
+			bt.onOrder(lb)
+			So(rec.orderUpdates()[0].Status, ShouldEqual, exch.NEW)
+			bt.onTick(exch.NewTick(2, date, 98, 10))
+			trade := exch.DecTradeFunc()(rec.topic("traded")[0].Payload)
+			So(trade.Price, ShouldEqual, exch.NewDecimal(99))
+			So(trade.IsMaker, ShouldBeTrue)
+		})
+		Convey("与最新价交叉的 LIMIT 订单，第一次成交是 taker", func() {
+			lb := de(BtcUsdtOrder.With(exch.Limit(exch.BUY, 1, 101)))
+			bt.onOrder(lb)
+			bt.onTick(exch.NewTick(2, date, 100, 10))
+			trade := exch.DecTradeFunc()(rec.topic("traded")[0].Payload)
+			So(trade.IsMaker, ShouldBeFalse)
+		})
+	})
+}
+
+func Test_BackTest_timeInForce(t *testing.T) {
+	Convey("BackTest 处理订单的有效方式", t, func() {
+		rec := newRecorder()
+		balance := exch.NewBalances(
+			exch.NewAsset("BTC", 10, 0),
+			exch.NewAsset("USDT", 100000, 0),
+		)
+		bt := newBackTest(rec, balance)
+		BtcUsdtOrder := exch.NewOrder("BTCUSDT", "BTC", "USDT")
+		date := time.Now()
+		bt.onTick(exch.NewTick(1, date, 100, 10))
+		Convey("IOC 订单没有成交的部分会 EXPIRED", func() {
+			ls := de(BtcUsdtOrder.With(exch.Limit(exch.SELL, 2, 100), exch.InForce(exch.IOC)))
+			bt.onOrder(ls)
+			bt.onTick(exch.NewTick(2, date, 100, 0.5))
+			So(bt.book("BTCUSDT").sells.isEmpty(), ShouldBeTrue)
+			us := rec.orderUpdates()
+			So(len(us), ShouldEqual, 3)
+			So(us[1].Status, ShouldEqual, exch.PARTIALLYfilled)
+			So(us[2].Status, ShouldEqual, exch.EXPIRED)
+			So(bt.bm.Balance["BTC"], ShouldResemble, exch.NewAsset("BTC", 9.5, 0))
+		})
+		Convey("IOC 订单没有遇到合适价格的话，也会 EXPIRED", func() {
+			ls := de(BtcUsdtOrder.With(exch.Limit(exch.SELL, 2, 110), exch.InForce(exch.IOC)))
+			bt.onOrder(ls)
+			bt.onTick(exch.NewTick(2, date, 100, 10))
+			So(bt.book("BTCUSDT").sells.isEmpty(), ShouldBeTrue)
+			So(rec.orderUpdates()[1].Status, ShouldEqual, exch.EXPIRED)
+			So(bt.bm.Balance["BTC"], ShouldResemble, exch.NewAsset("BTC", 10, 0))
+		})
+		Convey("FOK 订单", func() {
+			ls := de(BtcUsdtOrder.With(exch.Limit(exch.SELL, 2, 100), exch.InForce(exch.FOK)))
+			bt.onOrder(ls)
+			Convey("tick 的成交量不够的话，会被 REJECTED", func() {
+				bt.onTick(exch.NewTick(2, date, 100, 1))
+				So(bt.book("BTCUSDT").sells.isEmpty(), ShouldBeTrue)
+				So(rec.topic("traded"), ShouldBeEmpty)
+				us := rec.orderUpdates()
+				So(us[1].Status, ShouldEqual, exch.REJECTED)
+				So(us[1].RejectReason, ShouldNotBeEmpty)
+				So(bt.bm.Balance["BTC"], ShouldResemble, exch.NewAsset("BTC", 10, 0))
+			})
+			Convey("tick 的成交量足够的话，会全部成交", func() {
+				bt.onTick(exch.NewTick(2, date, 100, 2))
+				So(rec.orderUpdates()[1].Status, ShouldEqual, exch.FILLED)
+			})
+		})
+		Convey("GTD 订单", func() {
+			expire := date.Add(time.Minute)
+			lb := de(BtcUsdtOrder.With(exch.Limit(exch.BUY, 1, 90), exch.GoodTillDate(expire)))
+			bt.onOrder(lb)
+			bt.onTick(exch.NewTick(2, date.Add(time.Second), 100, 1))
+			So(bt.book("BTCUSDT").buys.head.next, ShouldEqual, lb)
+			Convey("到期后会 EXPIRED，并释放冻结的资金", func() {
+				bt.onTick(exch.NewTick(3, expire, 100, 1))
+				So(bt.book("BTCUSDT").buys.isEmpty(), ShouldBeTrue)
+				So(rec.orderUpdates()[1].Status, ShouldEqual, exch.EXPIRED)
+				So(bt.bm.Balance["USDT"], ShouldResemble, exch.NewAsset("USDT", 100000, 0))
+			})
+			Convey("已经到期的订单会被拒绝", func() {
+				old := de(BtcUsdtOrder.With(exch.Limit(exch.BUY, 1, 90), exch.GoodTillDate(date)))
+				bt.onOrder(old)
+				So(rec.orderUpdates()[1].Status, ShouldEqual, exch.REJECTED)
+			})
+		})
+	})
+}
+
+func Test_BackTest_cancel(t *testing.T) {
+	Convey("BackTest 撤单", t, func() {
+		rec := newRecorder()
+		balance := exch.NewBalances(
+			exch.NewAsset("BTC", 100, 0),
+			exch.NewAsset("ETH", 100, 0),
+			exch.NewAsset("USDT", 100000, 0),
+		)
+		bt := newBackTest(rec, balance)
+		BtcUsdtOrder := exch.NewOrder("BTCUSDT", "BTC", "USDT")
+		EthUsdtOrder := exch.NewOrder("ETHUSDT", "ETH", "USDT")
+		lb := de(BtcUsdtOrder.With(exch.Limit(exch.BUY, 1, 10000)))
+		ls := de(BtcUsdtOrder.With(exch.Limit(exch.SELL, 1, 20000)))
+		ls.ID++
+		le := de(EthUsdtOrder.With(exch.Limit(exch.SELL, 10, 200)))
+		le.ID += 2
+		bt.onOrder(lb)
+		bt.onOrder(ls)
+		bt.onOrder(le)
+		So(bt.bm.Balance["USDT"], ShouldResemble, exch.NewAsset("USDT", 90000, 10000))
+		So(bt.bm.Balance["BTC"], ShouldResemble, exch.NewAsset("BTC", 99, 1))
+		So(bt.bm.Balance["ETH"], ShouldResemble, exch.NewAsset("ETH", 90, 10))
+		Convey("按照 ID 撤单，会释放冻结的资金", func() {
+			bt.cancelOrder(lb.ID)
+			So(bt.bm.Balance["USDT"], ShouldResemble, exch.NewAsset("USDT", 100000, 0))
+			So(bt.book("BTCUSDT").buys.isEmpty(), ShouldBeTrue)
+			rs := rec.cancelResults()
+			So(len(rs), ShouldEqual, 1)
+			So(rs[0], ShouldResemble, exch.CancelResult{ID: lb.ID, Symbol: "BTCUSDT", IsCanceled: true})
+			Convey("再次撤销同一个订单，会收到拒绝的回报", func() {
+				bt.cancelOrder(lb.ID)
+				rs := rec.cancelResults()
+				So(len(rs), ShouldEqual, 2)
+				So(rs[1].ID, ShouldEqual, lb.ID)
+				So(rs[1].IsCanceled, ShouldBeFalse)
+				So(rs[1].Reason, ShouldNotBeEmpty)
+			})
+		})
+		Convey("按照 Symbol 撤单，只会撤销这个 Symbol 的订单", func() {
+			bt.cancelSymbolOrders("ETHUSDT")
+			So(bt.bm.Balance["ETH"], ShouldResemble, exch.NewAsset("ETH", 100, 0))
+			So(bt.bm.Balance["BTC"], ShouldResemble, exch.NewAsset("BTC", 99, 1))
+			rs := rec.cancelResults()
+			So(len(rs), ShouldEqual, 1)
+			So(rs[0].ID, ShouldEqual, le.ID)
+			So(bt.book("BTCUSDT").sells.head.next, ShouldEqual, ls)
+			So(ls.next, ShouldBeNil)
+		})
+		Convey("撤销全部订单", func() {
+			bt.cancelAllOrders()
+			So(bt.bm.Balance, ShouldResemble, exch.NewBalances(
+				exch.NewAsset("BTC", 100, 0),
+				exch.NewAsset("ETH", 100, 0),
+				exch.NewAsset("USDT", 100000, 0),
+			))
+			So(len(rec.cancelResults()), ShouldEqual, 3)
+			Convey("没有订单时撤销全部订单，会收到拒绝的回报", func() {
+				bt.cancelAllOrders()
+				rs := rec.cancelResults()
+				So(len(rs), ShouldEqual, 4)
+				So(rs[3].IsCanceled, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func Test_BackTest_symbols(t *testing.T) {
+	Convey("BackTest 为每个 Symbol 分别撮合", t, func() {
+		rec := newRecorder()
+		balance := exch.NewBalances(
+			exch.NewAsset("BTC", 10, 0),
+			exch.NewAsset("ETH", 100, 0),
+			exch.NewAsset("USDT", 100000, 0),
+		)
+		bt := newBackTest(rec, balance)
+		BtcUsdtOrder := exch.NewOrder("BTCUSDT", "BTC", "USDT")
+		EthUsdtOrder := exch.NewOrder("ETHUSDT", "ETH", "USDT")
+		lb := de(BtcUsdtOrder.With(exch.Limit(exch.BUY, 1, 10000)))
+		le := de(EthUsdtOrder.With(exch.Limit(exch.BUY, 10, 200)))
+		le.ID++
+		bt.onOrder(lb)
+		bt.onOrder(le)
+		btcTick := exch.NewTick(1, time.Now(), 20000, 100)
+		btcTick.Symbol = "BTCUSDT"
+		Convey("BTCUSDT 的 tick 不会撮合 ETHUSDT 的订单", func() {
+			bt.onTick(btcTick)
+			So(rec.topic("traded"), ShouldBeEmpty)
+			So(bt.book("ETHUSDT").buys.head.next, ShouldEqual, le)
+			So(bt.book("BTCUSDT").lastPrice, ShouldEqual, exch.NewDecimal(20000))
+			So(bt.book("ETHUSDT").lastPrice, ShouldEqual, exch.NewDecimal(0))
+			Convey("LIMIT_MAKER 只和自己 Symbol 的最新价比较", func() {
+				lm := de(EthUsdtOrder.With(exch.LimitMaker(exch.BUY, 1, 190)))
+				lm.ID += 2
+				bt.onOrder(lm)
+				us := rec.orderUpdates()
+				So(us[len(us)-1].Status, ShouldEqual, exch.NEW)
+			})
+		})
+		Convey("ETHUSDT 的 tick 只撮合 ETHUSDT 的订单", func() {
+			ethTick := exch.NewTick(2, time.Now(), 150, 100)
+			ethTick.Symbol = "ETHUSDT"
+			bt.onTick(ethTick)
+			trades := rec.topic("traded")
+			So(len(trades), ShouldEqual, 1)
+			trade := exch.DecTradeFunc()(trades[0].Payload)
+			So(trade.Symbol, ShouldEqual, "ETHUSDT")
+			So(bt.book("BTCUSDT").buys.head.next, ShouldEqual, lb)
+			So(bt.bm.Balance["USDT"], ShouldResemble, exch.NewAsset("USDT", 88000, 10000))
+		})
+		Convey("没有 Symbol 的 tick 会撮合全部的订单", func() {
+			bt.onTick(exch.NewTick(3, time.Now(), 150, 100))
+			So(len(rec.topic("traded")), ShouldEqual, 2)
+			So(bt.book("BTCUSDT").buys.isEmpty(), ShouldBeTrue)
+			So(bt.book("ETHUSDT").buys.isEmpty(), ShouldBeTrue)
+		})
+	})
+}
+
+func Test_BackTest_clientOrderID(t *testing.T) {
+	Convey("BackTest 会拒绝重复的 ClientOrderID", t, func() {
+		rec := newRecorder()
+		balance := exch.NewBalances(
+			exch.NewAsset("BTC", 10, 0),
+			exch.NewAsset("USDT", 100000, 0),
+		)
+		bt := newBackTest(rec, balance)
+		BtcUsdtOrder := exch.NewOrder("BTCUSDT", "BTC", "USDT")
+		lb := BtcUsdtOrder.With(exch.Limit(exch.BUY, 1, 10000), exch.ClientOrderID("retry"))
+		bt.onOrder(de(lb))
+		Convey("重复提交的订单不会被再次受理", func() {
+			bt.onOrder(de(lb))
+			us := rec.orderUpdates()
+			So(len(us), ShouldEqual, 2)
+			So(us[0].Status, ShouldEqual, exch.NEW)
+			So(us[1].Status, ShouldEqual, exch.REJECTED)
+			So(us[1].RejectReason, ShouldEqual, "重复的 ClientOrderID")
+			So(bt.bm.Balance["USDT"], ShouldResemble, exch.NewAsset("USDT", 90000, 10000))
+		})
+		Convey("没有 ClientOrderID 的订单不做检查", func() {
+			bt.onOrder(de(BtcUsdtOrder.With(exch.Limit(exch.BUY, 1, 10000))))
+			bt.onOrder(de(BtcUsdtOrder.With(exch.Limit(exch.BUY, 1, 10000))))
+			us := rec.orderUpdates()
+			So(us[1].Status, ShouldEqual, exch.NEW)
+			So(us[2].Status, ShouldEqual, exch.NEW)
+		})
+	})
+}
+
+func Test_BackTest_decimal(t *testing.T) {
+	Convey("BackTest 的资金没有浮点数的误差", t, func() {
+		rec := newRecorder()
+		balance := exch.NewBalances(
+			exch.NewAsset("BTC", 0, 0),
+			exch.NewAsset("USDT", 1, 0),
+		)
+		bt := newBackTest(rec, balance, WithFeeModel(MakerTaker{}))
+		BtcUsdtOrder := exch.NewOrder("BTCUSDT", "BTC", "USDT")
+		bt.onOrder(de(BtcUsdtOrder.With(exch.Limit(exch.BUY, 3, 0.1))))
+		Convey("多次部分成交后，冻结的资金正好全部释放", func() {
+			for i := int64(0); i < 30; i++ {
+				bt.onTick(exch.NewTick(i, time.Now(), 0.1, 0.1))
+			}
+			So(bt.bm.Balance["BTC"], ShouldResemble, exch.NewAsset("BTC", 3, 0))
+			So(bt.bm.Balance["USDT"], ShouldResemble, exch.NewAsset("USDT", 0.7, 0))
+		})
+	})
+}
+
+func Test_BackTest_symbolInfo(t *testing.T) {
+	Convey("BackTest 会按照交易规则检查订单", t, func() {
+		rec := newRecorder()
+		balance := exch.NewBalances(
+			exch.NewAsset("BTC", 10, 0),
+			exch.NewAsset("USDT", 100000, 0),
+		)
+		info := exch.SymbolInfo{
+			Exchange:    exch.BINANCE,
+			Symbol:      "BTCUSDT",
+			AssetName:   "BTC",
+			CapitalName: "USDT",
+			TickSize:    exch.NewDecimal(0.01),
+			StepSize:    exch.NewDecimal(0.01),
+			MinQuantity: exch.NewDecimal(0.01),
+			MinNotional: exch.NewDecimal(10),
+		}
+		bt := newBackTest(rec, balance, WithFeeModel(MakerTaker{}),
+			WithSymbols(exch.BINANCE, exch.NewSymbols(info)))
+		BtcUsdtOrder := info.NewOrder()
+		Convey("不符合规则的订单会被拒绝", func() {
+			bt.onOrder(de(BtcUsdtOrder.With(exch.Limit(exch.BUY, 0.015, 10000))))
+			bt.onOrder(de(BtcUsdtOrder.With(exch.Limit(exch.BUY, 1, 10000.001))))
+			bt.onOrder(de(BtcUsdtOrder.With(exch.Market(exch.BUY, 5))))
+			bt.onOrder(de(exch.NewOrder("ETHUSDT", "ETH", "USDT").With(exch.Limit(exch.BUY, 1, 100))))
+			us := rec.orderUpdates()
+			So(len(us), ShouldEqual, 4)
+			for _, u := range us {
+				So(u.Status, ShouldEqual, exch.REJECTED)
+			}
+			So(us[0].RejectReason, ShouldStartWith, "LOT_SIZE")
+			So(us[1].RejectReason, ShouldStartWith, "PRICE_FILTER")
+			So(us[2].RejectReason, ShouldStartWith, "MIN_NOTIONAL")
+			So(us[3].RejectReason, ShouldEqual, "未知的 Symbol")
+			So(bt.bm.Balance["USDT"], ShouldResemble, exch.NewAsset("USDT", 100000, 0))
+			Convey("被拒绝的订单不会新建 book", func() {
+				So(bt.books, ShouldBeEmpty)
+				So(bt.symbols, ShouldBeEmpty)
+			})
+		})
+		Convey("成交的数量是 StepSize 的整数倍", func() {
+			bt.onOrder(de(BtcUsdtOrder.With(exch.Limit(exch.SELL, 1, 10000))))
+			bt.onTick(exch.NewTick(1, time.Now(), 10000, 0.555))
+			trade := exch.DecTradeFunc()(rec.topic("traded")[0].Payload)
+			So(trade.Quantity, ShouldEqual, exch.NewDecimal(0.55))
+		})
+		Convey("MARKET BUY 剩余的 Capital 买不到一个 StepSize 时，订单完全成交", func() {
+			bt.onOrder(de(BtcUsdtOrder.With(exch.Market(exch.BUY, 1050))))
+			bt.onTick(exch.NewTick(1, time.Now(), 10000, 10))
+			trade := exch.DecTradeFunc()(rec.topic("traded")[0].Payload)
+			So(trade.Quantity, ShouldEqual, exch.NewDecimal(0.1))
+			us := rec.orderUpdates()
+			So(us[len(us)-1].Status, ShouldEqual, exch.FILLED)
+			So(bt.bm.Balance["USDT"], ShouldResemble, exch.NewAsset("USDT", 99000, 0))
+			So(bt.bm.Balance["BTC"], ShouldResemble, exch.NewAsset("BTC", 10.1, 0))
+		})
+		Convey("买不到一个 StepSize 的 MARKET BUY 会过期，不会挡住后面的订单", func() {
+			mb := de(BtcUsdtOrder.With(exch.Market(exch.BUY, 50)))
+			lb := de(BtcUsdtOrder.With(exch.Limit(exch.BUY, 0.01, 10000)))
+			bt.onOrder(mb)
+			bt.onOrder(lb)
+			bt.onTick(exch.NewTick(1, time.Now(), 10000, 10))
+			trades := rec.topic("traded")
+			So(len(trades), ShouldEqual, 1)
+			trade := exch.DecTradeFunc()(trades[0].Payload)
+			So(trade.OrderID, ShouldEqual, lb.ID)
+			us := rec.orderUpdates()
+			So(us[len(us)-2].ID, ShouldEqual, lb.ID)
+			So(us[len(us)-2].Status, ShouldEqual, exch.FILLED)
+			So(us[len(us)-1].ID, ShouldEqual, mb.ID)
+			So(us[len(us)-1].Status, ShouldEqual, exch.EXPIRED)
+			So(bt.book("BTCUSDT").buys.isEmpty(), ShouldBeTrue)
+			So(bt.bm.Balance["USDT"], ShouldResemble, exch.NewAsset("USDT", 99900, 0))
+		})
+	})
+}
+
+func Test_BackTest_codec(t *testing.T) {
+	Convey("BackTest 使用 WithCodec 设置的 Codec 发布 message", t, func() {
+		rec := newRecorder()
+		balance := exch.NewBalances(exch.NewAsset("USDT", 100000, 0))
+		bt := newBackTest(rec, balance, WithCodec(exch.JSON))
+		bt.onOrder(de(exch.NewOrder("BTCUSDT", "BTC", "USDT").With(exch.Limit(exch.BUY, 1, 10000))))
+		msgs := rec.topic("orderUpdate")
+		So(len(msgs), ShouldEqual, 1)
+		So(msgs[0].Metadata.Get(exch.CodecKey), ShouldEqual, "json")
+		var o exch.Order
+		So(exch.DecMsgFunc()(msgs[0], &o), ShouldBeNil)
+		So(o.Status, ShouldEqual, exch.NEW)
+		So(o.AssetPrice, ShouldEqual, exch.NewDecimal(10000))
+	})
+}
+
+func Test_BackTest_calendar(t *testing.T) {
+	Convey("BackTest 只在交易时段中成交", t, func() {
+		rec := newRecorder()
+		balance := exch.NewBalances(exch.NewAsset("USDT", 100000, 0))
+		cal, err := exch.DefaultCalendar(exch.SSE)
+		So(err, ShouldBeNil)
+		bt := newBackTest(rec, balance, WithFeeModel(MakerTaker{}), WithCalendar(cal))
+		bt.onOrder(de(exch.NewOrder("600000", "600000", "USDT").With(exch.Limit(exch.BUY, 100, 10))))
+		loc := cal.Location
+		Convey("午休时的 tick 不会成交", func() {
+			bt.onTick(exch.NewTick(1, time.Date(2021, 5, 14, 12, 0, 0, 0, loc), 9, 1000))
+			So(rec.topic("traded"), ShouldBeEmpty)
+			Convey("下午开盘以后才成交", func() {
+				bt.onTick(exch.NewTick(2, time.Date(2021, 5, 14, 13, 0, 0, 0, loc), 9, 1000))
+				So(len(rec.topic("traded")), ShouldEqual, 1)
+			})
+		})
+		Convey("周末的 tick 不会成交", func() {
+			bt.onTick(exch.NewTick(1, time.Date(2021, 5, 15, 10, 0, 0, 0, loc), 9, 1000))
+			So(rec.topic("traded"), ShouldBeEmpty)
+		})
+	})
+}
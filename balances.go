@@ -1,8 +1,6 @@
 package exch
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
 )
 
@@ -18,18 +16,24 @@ func NewBalances(assets ...Asset) Balance {
 	return b
 }
 
-// DecBalanceFunc 返回的函数会把序列化成 []byte 的 Balances 值转换回来
+// DecBalanceErrFunc 返回的函数会把序列化成 []byte 的 Balance 值转换回来
+// bs 无法转换成 Balance 时，返回错误
+func DecBalanceErrFunc() func(bs []byte) (*Balance, error) {
+	dec := decFunc()
+	return func(bs []byte) (*Balance, error) {
+		var balance Balance
+		err := dec(bs, &balance)
+		return &balance, err
+	}
+}
+
+// DecBalanceFunc 返回的函数会把序列化成 []byte 的 Balance 值转换回来
+// bs 无法转换时，返回的是零值，需要处理错误的话，请使用 DecBalanceErrFunc
 func DecBalanceFunc() func(bs []byte) *Balance {
-	var bb bytes.Buffer
-	dec := gob.NewDecoder(&bb)
+	dec := DecBalanceErrFunc()
 	return func(bs []byte) *Balance {
-		bb.Reset()
-		bb.Write(bs)
-		var balance Balance
-		// dec.Decode 只有在输入不是指针时候，才会报错
-		// 显然 &balances 肯定是一个指针
-		dec.Decode(&balance)
-		return &balance
+		balance, _ := dec(bs)
+		return balance
 	}
 }
 
@@ -55,7 +59,7 @@ func (b *Balance) Total(prices map[string]float64) float64 {
 			msg := fmt.Sprintf("Balance.Total: %s do NOT have a price", name)
 			panic(msg)
 		}
-		total += asset.Total() * price
+		total += asset.Total().Float64() * price
 	}
 	return total
 }
@@ -64,19 +68,20 @@ func (b *Balance) Total(prices map[string]float64) float64 {
 // Asset 是一个值对象 value object
 type Asset struct {
 	Name         string
-	Free, Locked float64
+	Free, Locked Decimal
 }
 
 func (a Asset) String() string {
-	return fmt.Sprintf("[%s:F%f:L%f]", a.Name, a.Free, a.Locked)
+	return fmt.Sprintf("[%s:F%s:L%s]", a.Name, a.Free, a.Locked)
 }
 
 // NewAsset return new asset
+// free 和 locked 会被四舍五入到 Decimal
 func NewAsset(name string, free, locked float64) Asset {
 	return Asset{
 		Name:   name,
-		Free:   free,
-		Locked: locked,
+		Free:   NewDecimal(free),
+		Locked: NewDecimal(locked),
 	}
 }
 
@@ -85,14 +90,14 @@ func (a Asset) Add(delta Asset) Asset {
 	if a.Name != delta.Name {
 		panic("Asset can NOT change with a different asset")
 	}
-	return NewAsset(
-		a.Name,
-		a.Free+delta.Free,
-		a.Locked+delta.Locked,
-	)
+	return Asset{
+		Name:   a.Name,
+		Free:   a.Free + delta.Free,
+		Locked: a.Locked + delta.Locked,
+	}
 }
 
 // Total returns total asset of this asset
-func (a Asset) Total() float64 {
+func (a Asset) Total() Decimal {
 	return a.Free + a.Locked
 }
@@ -67,7 +67,7 @@ func Test_Asset_Total(t *testing.T) {
 	Convey("测试 Asset.Total", t, func() {
 		a := NewAsset("BTC", 1, 2)
 		Convey("会返回 Free 和 Locked 的总和", func() {
-			So(a.Total(), ShouldEqual, 3)
+			So(a.Total(), ShouldEqual, 3*DecimalOne)
 		})
 	})
 }
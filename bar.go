@@ -1,74 +1,134 @@
 package exch
 
 import (
-	"bytes"
-	"encoding/gob"
 	"time"
 )
 
 // Bar 实现了 k 线的相关方法
 type Bar struct {
+	// Symbol 是生成 bar 的 tick 的 Symbol
+	Symbol                 string
 	Begin                  time.Time
 	Interval               time.Duration
 	Open, High, Low, Close float64 // Price
 	Volume                 float64
+	// QuoteVolume 是成交额，也就是每个 tick 的 Price 乘以 Volume 的和
+	QuoteVolume float64
+	// VWAP 是成交量加权平均价，等于 QuoteVolume / Volume
+	// 没有成交的 bar 的 VWAP 就是 Close
+	VWAP float64
+	// Trades 是成交的笔数，也就是 tick 的个数
+	Trades int64
+	// TakerBuyVolume 和 TakerBuyQuoteVolume 是主动买入的成交量和成交额
+	// 主动卖出的部分是 Volume - TakerBuyVolume，Side 为零值的 tick 算作主动卖出
+	TakerBuyVolume, TakerBuyQuoteVolume float64
+}
+
+// addTick 会把 tick 的成交计入 bar 的成交量、成交额等字段中
+// 需要先更新 bar 的 Close
+func (bar *Bar) addTick(tick Tick) {
+	volume := tick.Volume.Float64()
+	quote := tick.Price.Mul(tick.Volume).Float64()
+	bar.Volume += volume
+	bar.QuoteVolume += quote
+	bar.Trades++
+	if tick.Side == BUY {
+		bar.TakerBuyVolume += volume
+		bar.TakerBuyQuoteVolume += quote
+	}
+	bar.updateVWAP()
+}
+
+// addBar 会把窄 bar b 的成交计入 bar 的成交量、成交额等字段中
+// 需要先更新 bar 的 Close
+func (bar *Bar) addBar(b Bar) {
+	bar.Volume += b.Volume
+	bar.QuoteVolume += b.QuoteVolume
+	bar.Trades += b.Trades
+	bar.TakerBuyVolume += b.TakerBuyVolume
+	bar.TakerBuyQuoteVolume += b.TakerBuyQuoteVolume
+	bar.updateVWAP()
+}
+
+func (bar *Bar) updateVWAP() {
+	if bar.Volume == 0 {
+		bar.VWAP = bar.Close
+		return
+	}
+	bar.VWAP = bar.QuoteVolume / bar.Volume
+}
+
+// DecBarErrFunc 返回的函数会把序列化成 []byte 的 Bar 值转换回来
+// bs 无法转换成 Bar 时，返回错误
+func DecBarErrFunc() func(bs []byte) (Bar, error) {
+	dec := decFunc()
+	return func(bs []byte) (Bar, error) {
+		var bar Bar
+		err := dec(bs, &bar)
+		return bar, err
+	}
 }
 
 // DecBarFunc 返回的函数会把序列化成 []byte 的 Bar 值转换回来
+// bs 无法转换时，返回的是零值，需要处理错误的话，请使用 DecBarErrFunc
 func DecBarFunc() func(bs []byte) Bar {
-	var bb bytes.Buffer
-	dec := gob.NewDecoder(&bb)
+	dec := DecBarErrFunc()
 	return func(bs []byte) Bar {
-		bb.Reset()
-		bb.Write(bs)
-		var bar Bar
-		dec.Decode(&bar)
+		bar, _ := dec(bs)
 		return bar
 	}
 }
 
 // newTickBar make the first bar from a tick
-func newTickBar(tick Tick, begin time.Time, interval time.Duration) Bar {
+// bar 的时间范围是 [begin, end)，日历周期的 end 不一定是 begin 加上 interval
+func newTickBar(tick Tick, begin, end time.Time, interval time.Duration) Bar {
 	tU := tick.Date.Unix()
 	beginU := begin.Unix()
-	endU := begin.Add(interval).Unix()
+	endU := end.Unix()
 	if !(beginU <= tU && tU < endU) {
 		panic("newTickBar: tick should in begin,interval")
 	}
-	return Bar{
+	bar := Bar{
+		Symbol:   tick.Symbol,
 		Begin:    begin,
 		Interval: interval,
-		Open:     tick.Price,
-		High:     tick.Price,
-		Low:      tick.Price,
-		Close:    tick.Price,
-		Volume:   tick.Volume,
+		Open:     tick.Price.Float64(),
+		High:     tick.Price.Float64(),
+		Low:      tick.Price.Float64(),
+		Close:    tick.Price.Float64(),
 	}
+	bar.addTick(tick)
+	return bar
 }
 
-// TODO: uncomment this block, 添加 bar 生成 bar 的相关方法
-// // newBarBar make the first bar from another kind bar
-// func newBarBar(bar *Bar, begin time.Time, interval time.Duration) *Bar {
-// 	bInterval := bar.Interval
-// 	if interval <= bInterval {
-// 		panic("newBarBar: 新 bar 应该比旧 bar 宽")
-// 	}
-// 	bBegin, bEnd := bar.Begin, bar.Begin.Add(bInterval)
-// 	end := begin.Add(interval)
-// 	if !(begin.Unix() < bBegin.Unix() && bEnd.Unix() < end.Unix()) {
-// 		panic("newBarBar: 新 bar 应该完全包住旧 bar")
-// 	}
-// 	if interval%bInterval != 0 {
-// 		panic("newBarBar: 新 bar 的宽度，应该是旧 bar 的整数倍")
-// 	}
-// 	if bBegin.Sub(begin)%bInterval != 0 {
-// 		panic("newBarBar: 新旧 bar 要能够对齐")
-// 	}
-// 	res := *bar
-// 	res.Begin = begin
-// 	res.Interval = interval
-// 	return &res
-// }
+// newBarBar make the first bar from another kind bar
+// 新 bar 的时间范围是 [begin, end)
+func newBarBar(bar Bar, begin, end time.Time, interval time.Duration) Bar {
+	checkBarBar(bar, begin, end, interval)
+	res := bar
+	res.Begin = begin
+	res.Interval = interval
+	return res
+}
+
+// checkBarBar 会检查 bar 能否合并到时间范围是 [begin, end) 的宽度为 interval 的 bar 中
+// 日历周期的长度不固定，只要能够完全包住 bar 就算对齐
+func checkBarBar(bar Bar, begin, end time.Time, interval time.Duration) {
+	bInterval := bar.Interval
+	if interval <= bInterval {
+		panic("newBarBar: 新 bar 应该比旧 bar 宽")
+	}
+	bBegin, bEnd := bar.Begin, bar.Begin.Add(bInterval)
+	if bBegin.Before(begin) || end.Before(bEnd) {
+		panic("newBarBar: 新 bar 应该完全包住旧 bar")
+	}
+	if interval%bInterval != 0 {
+		panic("newBarBar: 新 bar 的宽度，应该是旧 bar 的整数倍")
+	}
+	if end.Equal(begin.Add(interval)) && bBegin.Sub(begin)%bInterval != 0 {
+		panic("newBarBar: 新旧 bar 要能够对齐")
+	}
+}
 
 // NilTick 只是一个标志
 var NilTick = Tick{}
@@ -83,14 +143,21 @@ var NilTick = Tick{}
 //    返回上一个 bar
 // 4. 接收到下一个 interval 后面的 interval 的 tick，市场冷清，长时间没有交易
 //    返回多个 bar
+// 5. 接收到休市时的 tick
+//    不返回 bar
+// 使用 Calendar.Begin 的话，会跳过休市的时间，不会生成休市时的空 bar
 func GenTickBarFunc(begin BeginFunc, interval time.Duration) func(Tick) []Bar {
 	isInited := false
 	var bar Bar
 	var lastTickDate time.Time
 	return func(tick Tick) []Bar {
 		tickBegin := begin(tick.Date, interval)
+		// 休市时的 tick 不属于任何 bar
+		if tick != NilTick && tickBegin.After(tick.Date) {
+			return nil
+		}
 		if !isInited {
-			bar = newTickBar(tick, tickBegin, interval)
+			bar = newTickBar(tick, tickBegin, nextBegin(begin, tickBegin, interval), interval)
 			lastTickDate = tick.Date
 			isInited = true
 			return nil
@@ -106,33 +173,104 @@ func GenTickBarFunc(begin BeginFunc, interval time.Duration) func(Tick) []Bar {
 		lastTickDate = tick.Date
 		// 收到了一个本周期的 tick
 		if tickBegin.Equal(bar.Begin) {
-			bar.High = maxFloat64(bar.High, tick.Price)
-			bar.Low = minFloat64(bar.Low, tick.Price)
-			bar.Close = tick.Price
-			bar.Volume += tick.Volume
+			price := tick.Price.Float64()
+			bar.High = maxFloat64(bar.High, price)
+			bar.Low = minFloat64(bar.Low, price)
+			bar.Close = price
+			bar.addTick(tick)
 			return nil
 		}
 		// 收到了若干个周期后的 tick
 		res := make([]Bar, 0, 256)
 		for bar.Begin.Before(tickBegin) {
 			res = append(res, bar)
-			bar = nextEmptyBar(bar)
+			bar = nextEmptyBar(bar, begin)
 		}
-		bar = newTickBar(tick, tickBegin, interval)
+		bar = newTickBar(tick, tickBegin, nextBegin(begin, tickBegin, interval), interval)
 		return res
 	}
 }
 
-func nextEmptyBar(bar Bar) Bar {
-	begin := bar.Begin.Add(bar.Interval)
+// nextEmptyBar 返回 bar 后面的没有成交的 bar
+// 下一个 bar 的开始时间由 bf 决定
+func nextEmptyBar(bar Bar, bf BeginFunc) Bar {
 	interval := bar.Interval
 	return Bar{
-		Begin:    begin,
+		Symbol:   bar.Symbol,
+		Begin:    nextBegin(bf, bar.Begin, interval),
 		Interval: interval,
 		Open:     bar.Close,
 		High:     bar.Close,
 		Low:      bar.Close,
 		Close:    bar.Close,
-		Volume:   0,
+		VWAP:     bar.Close,
+	}
+}
+
+// NilBar 只是一个标志，与 NilTick 一样，用于逼出最后一个 bar
+var NilBar = Bar{}
+
+// GenBarBarFunc 会返回一个接收窄 bar 并生成宽 bar 的闭包函数
+// 例如，用 1 分钟的 bar 生成 15 分钟的 bar
+// 接收的 bar 需要按照 Begin 排序，宽度都一样，
+// interval 需要是它们宽度的整数倍，并且能够对齐，否则会 panic
+// 与 GenTickBarFunc 一样，有以下情况需要处理
+// 1. 接收第一个 bar,
+//    不返回 bar
+// 2. 接收到当前的 interval 的 bar
+//    不返回 bar
+// 3. 接收到下一个 interval 的 bar
+//    返回上一个 bar
+// 4. 接收到下一个 interval 后面的 interval 的 bar，中间缺少了数据
+//    返回多个 bar
+// 5. 接收到 NilBar
+//    返回最后一个 bar
+// 6. 接收到休市时的 bar
+//    不返回 bar
+func GenBarBarFunc(begin BeginFunc, interval time.Duration) func(Bar) []Bar {
+	isInited := false
+	var res Bar
+	var lastBarBegin time.Time
+	return func(bar Bar) []Bar {
+		if bar == NilBar {
+			if !isInited {
+				return nil
+			}
+			return []Bar{res}
+		}
+		barBegin := begin(bar.Begin, interval)
+		// 休市时的 bar 不属于任何 bar
+		if barBegin.After(bar.Begin) {
+			return nil
+		}
+		barEnd := nextBegin(begin, barBegin, interval)
+		if !isInited {
+			res = newBarBar(bar, barBegin, barEnd, interval)
+			lastBarBegin = bar.Begin
+			isInited = true
+			return nil
+		}
+		// GenBarBar 不接受乱序的 bars
+		if bar.Begin.Before(lastBarBegin) {
+			panic("GenBarBar: Bars should be sorted in begin")
+		}
+		lastBarBegin = bar.Begin
+		// 收到了一个本周期的 bar
+		if barBegin.Equal(res.Begin) {
+			checkBarBar(bar, barBegin, barEnd, interval)
+			res.High = maxFloat64(res.High, bar.High)
+			res.Low = minFloat64(res.Low, bar.Low)
+			res.Close = bar.Close
+			res.addBar(bar)
+			return nil
+		}
+		// 收到了若干个周期后的 bar
+		bars := make([]Bar, 0, 16)
+		for res.Begin.Before(barBegin) {
+			bars = append(bars, res)
+			res = nextEmptyBar(res, begin)
+		}
+		res = newBarBar(bar, barBegin, barEnd, interval)
+		return bars
 	}
 }
@@ -13,9 +13,10 @@ func Test_GenBarFunc(t *testing.T) {
 		interval := time.Minute
 		gb := GenTickBarFunc(Begin, interval)
 		tick := Tick{
+			Symbol: "BTCUSDT",
 			Date:   date,
-			Price:  1.0,
-			Volume: 1.0,
+			Price:  DecimalOne,
+			Volume: DecimalOne,
 		}
 		Convey("输入第一个 tick", func() {
 			actual := gb(tick)
@@ -40,7 +41,7 @@ func Test_GenBarFunc(t *testing.T) {
 						So(bar.Open, ShouldEqual, bar.Close)
 						So(bar.High, ShouldEqual, bar.Close)
 						So(bar.Low, ShouldEqual, bar.Close)
-						So(bar.Volume, ShouldEqual, tick.Volume*2)
+						So(bar.Volume, ShouldEqual, tick.Volume.Float64()*2)
 					})
 				})
 				Convey("输入下两个周期的 tick，会返回 2 个 bar", func() {
@@ -52,9 +53,13 @@ func Test_GenBarFunc(t *testing.T) {
 						So(bar.Open, ShouldEqual, bar.Close)
 						So(bar.High, ShouldEqual, bar.Close)
 						So(bar.Low, ShouldEqual, bar.Close)
-						So(bar.Volume, ShouldEqual, tick.Volume*2)
+						So(bar.Volume, ShouldEqual, tick.Volume.Float64()*2)
 					})
 					emptyBar := bars[1]
+					Convey("bar 的 Symbol 与 tick 相同", func() {
+						So(bar.Symbol, ShouldEqual, tick.Symbol)
+						So(emptyBar.Symbol, ShouldEqual, tick.Symbol)
+					})
 					Convey("第二个 bar 应该是 empty 的", func() {
 						So(emptyBar.Volume, ShouldEqual, 0)
 						So(emptyBar.Open, ShouldEqual, bar.Close)
@@ -103,14 +108,165 @@ func Test_newTickBar(t *testing.T) {
 		Convey("如果想要生成的 bar 的起始时间太早，会 panic", func() {
 			tooEarly := begin.Add(-interval)
 			So(func() {
-				newTickBar(tick, tooEarly, interval)
+				newTickBar(tick, tooEarly, tooEarly.Add(interval), interval)
 			}, ShouldPanic)
 		})
 		Convey("如果想要生成的 bar 的起始时间太晚，会 panic", func() {
 			tooLate := begin.Add(interval)
 			So(func() {
-				newTickBar(tick, tooLate, interval)
+				newTickBar(tick, tooLate, tooLate.Add(interval), interval)
 			}, ShouldPanic)
 		})
 	})
 }
+
+func Test_newBarBar(t *testing.T) {
+	Convey("想要利用窄 bar 生成宽 bar", t, func() {
+		begin := time.Date(2020, 5, 1, 8, 0, 0, 0, time.UTC)
+		bar := Bar{Begin: begin.Add(2 * time.Minute), Interval: time.Minute, Open: 1, High: 2, Low: 0.5, Close: 1.5, Volume: 3}
+		Convey("对齐的宽 bar 会复制旧 bar 的价格和成交量", func() {
+			res := newBarBar(bar, begin, begin.Add(15*time.Minute), 15*time.Minute)
+			So(res.Begin, ShouldEqual, begin)
+			So(res.Interval, ShouldEqual, 15*time.Minute)
+			So(res.High, ShouldEqual, bar.High)
+			So(res.Volume, ShouldEqual, bar.Volume)
+		})
+		Convey("新 bar 不比旧 bar 宽，会 panic", func() {
+			So(func() { newBarBar(bar, bar.Begin, bar.Begin.Add(time.Minute), time.Minute) }, ShouldPanic)
+		})
+		Convey("新 bar 没有包住旧 bar，会 panic", func() {
+			So(func() { newBarBar(bar, begin.Add(15*time.Minute), begin.Add(30*time.Minute), 15*time.Minute) }, ShouldPanic)
+		})
+		Convey("新 bar 的宽度不是旧 bar 的整数倍，会 panic", func() {
+			So(func() { newBarBar(bar, begin, begin.Add(150*time.Second), 150*time.Second) }, ShouldPanic)
+		})
+		Convey("新旧 bar 不能对齐，会 panic", func() {
+			bar.Begin = bar.Begin.Add(time.Second)
+			So(func() { newBarBar(bar, begin, begin.Add(15*time.Minute), 15*time.Minute) }, ShouldPanic)
+		})
+	})
+}
+
+func Test_GenBarBarFunc(t *testing.T) {
+	Convey("用 1 分钟的 bar 生成 5 分钟的 bar", t, func() {
+		begin := time.Date(2020, 5, 1, 8, 0, 0, 0, time.UTC)
+		interval := 5 * time.Minute
+		gbb := GenBarBarFunc(Begin, interval)
+		minute := func(i int, price float64) Bar {
+			return Bar{
+				Symbol:   "BTCUSDT",
+				Begin:    begin.Add(time.Duration(i) * time.Minute),
+				Interval: time.Minute,
+				Open:     price, High: price + 1, Low: price - 1, Close: price,
+				Volume: 1,
+			}
+		}
+		Convey("没有输入过 bar 时，NilBar 不返回 bar", func() {
+			So(gbb(NilBar), ShouldBeNil)
+		})
+		for i := 0; i < 5; i++ {
+			So(gbb(minute(i, float64(10+i))), ShouldBeNil)
+		}
+		Convey("输入更早的 bar 会 panic", func() {
+			So(func() { gbb(minute(3, 10)) }, ShouldPanic)
+		})
+		Convey("宽度不一样的 bar 会 panic", func() {
+			bar := minute(5, 10)
+			bar.Interval = 2 * time.Minute
+			So(func() { gbb(bar) }, ShouldPanic)
+		})
+		Convey("输入下个周期的 bar，会返回合并好的 bar", func() {
+			bars := gbb(minute(5, 20))
+			So(len(bars), ShouldEqual, 1)
+			So(bars[0], ShouldResemble, Bar{
+				Symbol:   "BTCUSDT",
+				Begin:    begin,
+				Interval: interval,
+				Open:     10, High: 15, Low: 9, Close: 14,
+				Volume: 5,
+			})
+			Convey("NilBar 会逼出最后一个 bar", func() {
+				bars := gbb(NilBar)
+				So(len(bars), ShouldEqual, 1)
+				So(bars[0].Begin, ShouldEqual, begin.Add(interval))
+				So(bars[0].Open, ShouldEqual, 20)
+			})
+		})
+		Convey("中间缺少数据的话，会用空 bar 补齐", func() {
+			bars := gbb(minute(17, 20))
+			So(len(bars), ShouldEqual, 3)
+			So(bars[1].Begin, ShouldEqual, begin.Add(interval))
+			So(bars[1].Open, ShouldEqual, 14)
+			So(bars[1].Volume, ShouldEqual, 0)
+			So(bars[2].Begin, ShouldEqual, begin.Add(2*interval))
+		})
+	})
+}
+
+func Test_GenTickBarFunc_calendar(t *testing.T) {
+	Convey("GenTickBarFunc 可以生成月线", t, func() {
+		gb := GenTickBarFunc(CalendarBegin(time.UTC, 0, time.Monday), Month)
+		date := func(m time.Month, d int) time.Time {
+			return time.Date(2020, m, d, 12, 0, 0, 0, time.UTC)
+		}
+		So(gb(NewTick(1, date(1, 1), 1, 1)), ShouldBeNil)
+		So(gb(NewTick(2, date(1, 31), 2, 1)), ShouldBeNil)
+		bars := gb(NewTick(3, date(4, 15), 3, 1))
+		So(len(bars), ShouldEqual, 3)
+		So(bars[0].Begin, ShouldEqual, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+		So(bars[0].Volume, ShouldEqual, 2)
+		So(bars[1].Begin, ShouldEqual, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC))
+		So(bars[2].Begin, ShouldEqual, time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC))
+		So(bars[2].Close, ShouldEqual, 2)
+		Convey("日线也可以合并成月线", func() {
+			gbb := GenBarBarFunc(CalendarBegin(time.UTC, 0, time.Monday), Month)
+			for d := 1; d <= 31; d++ {
+				day := Bar{Begin: time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC), Interval: Day, Close: 1, Volume: 1}
+				So(gbb(day), ShouldBeNil)
+			}
+			bars := gbb(Bar{Begin: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), Interval: Day})
+			So(len(bars), ShouldEqual, 1)
+			So(bars[0].Volume, ShouldEqual, 31)
+		})
+	})
+}
+
+func Test_Bar_volumes(t *testing.T) {
+	Convey("bar 会记录成交额、VWAP、成交笔数和主动买入的成交量", t, func() {
+		begin := time.Date(2020, 5, 1, 8, 0, 0, 0, time.UTC)
+		tick := func(i int, price, volume float64, side OrderSide) Tick {
+			t := NewTick(int64(i), begin.Add(time.Duration(i)*time.Second), price, volume)
+			t.Side = side
+			return t
+		}
+		gb := GenTickBarFunc(Begin, time.Minute)
+		So(gb(tick(0, 10, 1, BUY)), ShouldBeNil)
+		So(gb(tick(1, 20, 3, SELL)), ShouldBeNil)
+		So(gb(tick(2, 30, 1, BUY)), ShouldBeNil)
+		bars := gb(tick(180, 40, 1, 0))
+		So(len(bars), ShouldEqual, 3)
+		bar := bars[0]
+		So(bar.Volume, ShouldEqual, 5)
+		So(bar.QuoteVolume, ShouldEqual, 100)
+		So(bar.VWAP, ShouldEqual, 20)
+		So(bar.Trades, ShouldEqual, 3)
+		So(bar.TakerBuyVolume, ShouldEqual, 2)
+		So(bar.TakerBuyQuoteVolume, ShouldEqual, 40)
+		Convey("没有成交的 bar 的 VWAP 是 Close", func() {
+			So(bars[1].VWAP, ShouldEqual, 30)
+			So(bars[1].Trades, ShouldEqual, 0)
+			So(bars[1].QuoteVolume, ShouldEqual, 0)
+		})
+		Convey("合并 bar 时会累加这些字段", func() {
+			gbb := GenBarBarFunc(Begin, 5*time.Minute)
+			So(gbb(bars[0]), ShouldBeNil)
+			So(gbb(bars[1]), ShouldBeNil)
+			So(gbb(bars[2]), ShouldBeNil)
+			bars := gbb(NilBar)
+			So(len(bars), ShouldEqual, 1)
+			So(bars[0].Trades, ShouldEqual, 3)
+			So(bars[0].VWAP, ShouldEqual, 20)
+			So(bars[0].TakerBuyVolume, ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,248 @@
+package exch
+
+import (
+	"fmt"
+	"time"
+)
+
+// Session 是每个交易日中的一个交易时段
+// Open 和 Close 是开盘和收盘的当地钟表时间，从当天零点开始计算
+// Close 不大于 Open 的话，表示这个时段跨过了午夜，在第二天的 Close 收盘
+type Session struct {
+	Open, Close time.Duration
+	// Night 为 true 表示这是夜盘，夜盘属于下一个交易日
+	// 只有下一个工作日不是假日时，才会有夜盘，
+	// 例如，国内期货在长假前的最后一天没有夜盘
+	Night bool
+}
+
+// Calendar 记录了一个交易所的交易时段和假日
+// 夜盘属于下一个交易日，例如，星期五的夜盘属于下星期一
+// 其他跨过午夜的交易时段，属于开盘的那一天
+type Calendar struct {
+	Location *time.Location
+	// Sessions 按照开盘时间排列
+	Sessions []Session
+	weekdays [7]bool
+	holidays map[string]bool
+}
+
+// dateLayout 是 Calendar 中日期的格式
+const dateLayout = "2006-01-02"
+
+// NewCalendar 返回一个在 weekdays 的 sessions 交易的 Calendar
+// 交易时段使用 loc 时区的钟表时间
+func NewCalendar(loc *time.Location, weekdays []time.Weekday, sessions ...Session) *Calendar {
+	c := &Calendar{
+		Location: loc,
+		Sessions: sessions,
+		holidays: make(map[string]bool, 32),
+	}
+	for _, w := range weekdays {
+		c.weekdays[w] = true
+	}
+	return c
+}
+
+// AddHolidays 会添加休市的日期，日期的格式是 "2006-01-02"
+// 格式不对的话，会 panic
+func (c *Calendar) AddHolidays(dates ...string) *Calendar {
+	for _, date := range dates {
+		if _, err := time.Parse(dateLayout, date); err != nil {
+			panic("Calendar.AddHolidays: " + err.Error())
+		}
+		c.holidays[date] = true
+	}
+	return c
+}
+
+// IsTradingDay 返回 true 表示 date 在当地的那一天是交易日
+func (c *Calendar) IsTradingDay(date time.Time) bool {
+	date = date.In(c.Location)
+	return c.weekdays[date.Weekday()] && !c.holidays[date.Format(dateLayout)]
+}
+
+// period 是一个具体的交易时段
+type period struct {
+	open, close time.Time
+	night       bool
+}
+
+// periods 返回在当地的 y 年 m 月 d 日开盘的交易时段
+func (c *Calendar) periods(y int, m time.Month, d int) []period {
+	at := func(d int, offset time.Duration) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, int(offset), c.Location)
+	}
+	day := at(d, 0)
+	if !c.IsTradingDay(day) {
+		return nil
+	}
+	res := make([]period, 0, len(c.Sessions))
+	for _, s := range c.Sessions {
+		if s.Night && !c.hasNight(day) {
+			continue
+		}
+		p := period{open: at(d, s.Open), close: at(d, s.Close), night: s.Night}
+		if s.Close <= s.Open {
+			p.close = at(d+1, s.Close)
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+// hasNight 返回 true 表示 day 的下一个工作日不是假日
+func (c *Calendar) hasNight(day time.Time) bool {
+	for i := 1; i <= 7; i++ {
+		next := day.AddDate(0, 0, i)
+		if c.weekdays[next.Weekday()] {
+			return !c.holidays[next.Format(dateLayout)]
+		}
+	}
+	return false
+}
+
+// Session 返回 t 所在的交易时段的开盘和收盘时间
+// t 不在交易时段中的话，ok 为 false
+func (c *Calendar) Session(t time.Time) (open, close time.Time, ok bool) {
+	p, ok := c.session(t)
+	return p.open, p.close, ok
+}
+
+// session 返回 t 所在的交易时段
+func (c *Calendar) session(t time.Time) (period, bool) {
+	y, m, d := t.In(c.Location).Date()
+	// 前一天开盘的时段，可能跨过了午夜
+	for _, day := range []int{d - 1, d} {
+		for _, p := range c.periods(y, m, day) {
+			if !t.Before(p.open) && t.Before(p.close) {
+				return p, true
+			}
+		}
+	}
+	return period{}, false
+}
+
+// tradingDay 返回 p 所属的交易日的当地零点
+// 夜盘属于下一个交易日，会跳过周末和假日，其他的交易时段属于开盘的那一天
+func (c *Calendar) tradingDay(p period) time.Time {
+	y, m, d := p.open.In(c.Location).Date()
+	if !p.night {
+		return time.Date(y, m, d, 0, 0, 0, 0, c.Location)
+	}
+	for i := 1; i <= maxClosedDays; i++ {
+		if day := time.Date(y, m, d+i, 0, 0, 0, 0, c.Location); c.IsTradingDay(day) {
+			return day
+		}
+	}
+	panic(fmt.Sprintf("Calendar: %s 的夜盘以后的一年中没有交易日", p.open))
+}
+
+// dayOpen 返回交易日 day 的第一个交易时段的开盘时间
+// 上一个交易日有夜盘的话，就是那个夜盘的开盘时间
+func (c *Calendar) dayOpen(day time.Time) time.Time {
+	y, m, d := day.Date()
+	for i := 1; i <= maxClosedDays; i++ {
+		if !c.IsTradingDay(time.Date(y, m, d-i, 0, 0, 0, 0, c.Location)) {
+			continue
+		}
+		for _, p := range c.periods(y, m, d-i) {
+			if p.night {
+				return p.open
+			}
+		}
+		break
+	}
+	return c.periods(y, m, d)[0].open
+}
+
+// IsOpen 返回 true 表示 t 在交易时段中
+func (c *Calendar) IsOpen(t time.Time) bool {
+	_, _, ok := c.Session(t)
+	return ok
+}
+
+// maxClosedDays 是 NextOpen 最多向后查找的天数
+const maxClosedDays = 366
+
+// NextOpen 返回 t 以后，最近的一次开盘时间，包括 t
+// 一年之内都没有交易时段的话，会 panic
+func (c *Calendar) NextOpen(t time.Time) time.Time {
+	y, m, d := t.In(c.Location).Date()
+	for i := -1; i <= maxClosedDays; i++ {
+		for _, p := range c.periods(y, m, d+i) {
+			if !p.open.Before(t) {
+				return p.open
+			}
+		}
+	}
+	panic(fmt.Sprintf("Calendar.NextOpen: %s 以后的一年中没有交易时段", t))
+}
+
+// Begin 是按照交易时段划分周期的 BeginFunc
+// interval 能够整除 Day 时，从每个交易时段的开盘开始，每 interval 一个周期，
+// 交易时段的最后一个周期可能不足 interval
+// interval 为 Day 时，周期从交易日的第一个交易时段的开盘开始，
+// 有夜盘的话，就是从上一个交易日的夜盘开始，例如，星期一的日线从上星期五的夜盘开始
+// date 不在交易时段中的话，返回下一次开盘的时间，
+// GenTickBarFunc 和 GenBarBarFunc 会忽略这样的 tick 和 bar
+// 其他的 interval 会 panic
+func (c *Calendar) Begin(date time.Time, interval time.Duration) time.Time {
+	if interval != Day && Day%interval != 0 {
+		panic("Calendar.Begin: 不支持的 interval " + interval.String())
+	}
+	p, ok := c.session(date)
+	if !ok {
+		return c.NextOpen(date)
+	}
+	if interval == Day {
+		return c.dayOpen(c.tradingDay(p))
+	}
+	return p.open.Add(date.Sub(p.open) / interval * interval)
+}
+
+// DefaultCalendar 返回 name 交易所的常规交易时段，不包含假日，
+// 假日需要使用 AddHolidays 添加
+// 期货交易所的夜盘因品种而异，这里使用的是最常见的 21:00 到 23:00
+// 没有记录的交易所会返回错误，例如全天交易的数字货币交易所
+func DefaultCalendar(name Name) (*Calendar, error) {
+	weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	china := time.FixedZone("CST", 8*60*60)
+	hm := func(h, m int) time.Duration {
+		return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+	}
+	switch name {
+	case SSE, SZSE, CFFEX:
+		return NewCalendar(china, weekdays,
+			Session{Open: hm(9, 30), Close: hm(11, 30)},
+			Session{Open: hm(13, 0), Close: hm(15, 0)},
+		), nil
+	case SHFE, DCE, CZCE, INE:
+		return NewCalendar(china, weekdays,
+			Session{Open: hm(9, 0), Close: hm(10, 15)},
+			Session{Open: hm(10, 30), Close: hm(11, 30)},
+			Session{Open: hm(13, 30), Close: hm(15, 0)},
+			Session{Open: hm(21, 0), Close: hm(23, 0), Night: true},
+		), nil
+	case SEHK, HKSE:
+		return NewCalendar(time.FixedZone("HKT", 8*60*60), weekdays,
+			Session{Open: hm(9, 30), Close: hm(12, 0)},
+			Session{Open: hm(13, 0), Close: hm(16, 0)},
+		), nil
+	case NYSE, NASDAQ, SMART:
+		loc, err := time.LoadLocation("America/New_York")
+		if err != nil {
+			return nil, err
+		}
+		return NewCalendar(loc, weekdays, Session{Open: hm(9, 30), Close: hm(16, 0)}), nil
+	case CME, GLOBEX, CBOT, NYMEX, COMEX:
+		loc, err := time.LoadLocation("America/Chicago")
+		if err != nil {
+			return nil, err
+		}
+		// 周日到周四的 17:00 开盘，第二天的 16:00 收盘
+		sundayToThursday := []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday}
+		return NewCalendar(loc, sundayToThursday, Session{Open: hm(17, 0), Close: hm(16, 0)}), nil
+	}
+	return nil, fmt.Errorf("DefaultCalendar: 没有 %s 的交易时段", name)
+}
@@ -0,0 +1,127 @@
+package exch
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Calendar(t *testing.T) {
+	Convey("Calendar 记录了交易时段和假日", t, func() {
+		c, err := DefaultCalendar(SHFE)
+		So(err, ShouldBeNil)
+		c.AddHolidays("2021-10-01", "2021-10-04", "2021-10-05", "2021-10-06", "2021-10-07")
+		at := func(m time.Month, d, h, mi int) time.Time {
+			return time.Date(2021, m, d, h, mi, 0, 0, c.Location)
+		}
+		Convey("IsOpen 会判断是否处在交易时段中", func() {
+			So(c.IsOpen(at(5, 14, 9, 0)), ShouldBeTrue)
+			So(c.IsOpen(at(5, 14, 10, 20)), ShouldBeFalse)
+			So(c.IsOpen(at(5, 14, 11, 30)), ShouldBeFalse)
+			So(c.IsOpen(at(5, 14, 22, 0)), ShouldBeTrue)
+			So(c.IsOpen(at(5, 15, 10, 0)), ShouldBeFalse)
+		})
+		Convey("长假前的最后一天没有夜盘", func() {
+			So(c.IsOpen(at(9, 29, 22, 0)), ShouldBeTrue)
+			So(c.IsOpen(at(9, 30, 22, 0)), ShouldBeFalse)
+			So(c.IsOpen(at(10, 1, 10, 0)), ShouldBeFalse)
+			So(c.NextOpen(at(9, 30, 15, 0)), ShouldEqual, at(10, 8, 9, 0))
+		})
+		Convey("Session 返回所在交易时段的开盘和收盘时间", func() {
+			open, close, ok := c.Session(at(5, 14, 14, 0))
+			So(ok, ShouldBeTrue)
+			So(open, ShouldEqual, at(5, 14, 13, 30))
+			So(close, ShouldEqual, at(5, 14, 15, 0))
+		})
+		Convey("Begin 从交易时段的开盘开始划分周期", func() {
+			So(c.Begin(at(5, 14, 13, 50), time.Hour), ShouldEqual, at(5, 14, 13, 30))
+			So(c.Begin(at(5, 14, 10, 10), 15*time.Minute), ShouldEqual, at(5, 14, 10, 0))
+			So(c.Begin(at(5, 14, 13, 50), Day), ShouldEqual, at(5, 13, 21, 0))
+			Convey("休市时返回下一个交易时段的周期", func() {
+				So(c.Begin(at(5, 14, 12, 0), time.Hour), ShouldEqual, at(5, 14, 13, 30))
+				So(c.Begin(at(5, 15, 12, 0), Day), ShouldEqual, at(5, 17, 9, 0))
+			})
+			Convey("不支持的 interval 会 panic", func() {
+				So(func() { c.Begin(at(5, 14, 9, 0), 7*time.Minute) }, ShouldPanic)
+			})
+		})
+		Convey("夜盘属于下一个交易日", func() {
+			// 2021-05-13 是星期四，夜盘属于星期五
+			So(c.Begin(at(5, 13, 21, 30), Day), ShouldEqual, at(5, 13, 21, 0))
+			So(c.Begin(at(5, 14, 9, 0), Day), ShouldEqual, at(5, 13, 21, 0))
+			// 星期五的夜盘属于下星期一
+			So(c.Begin(at(5, 14, 22, 0), Day), ShouldEqual, at(5, 14, 21, 0))
+			So(c.Begin(at(5, 17, 14, 0), Day), ShouldEqual, at(5, 14, 21, 0))
+			So(nextBegin(c.Begin, at(5, 13, 21, 0), Day), ShouldEqual, at(5, 14, 21, 0))
+			So(nextBegin(c.Begin, at(5, 14, 21, 0), Day), ShouldEqual, at(5, 17, 21, 0))
+			Convey("长假前的最后一天没有夜盘，长假后的交易日从白天开始", func() {
+				So(c.Begin(at(9, 30, 14, 0), Day), ShouldEqual, at(9, 29, 21, 0))
+				So(c.Begin(at(10, 8, 9, 0), Day), ShouldEqual, at(10, 8, 9, 0))
+				So(nextBegin(c.Begin, at(9, 29, 21, 0), Day), ShouldEqual, at(10, 8, 9, 0))
+			})
+			Convey("夜盘的 tick 会生成到下一个交易日的日线中", func() {
+				gb := GenTickBarFunc(c.Begin, Day)
+				So(gb(NewTick(1, at(5, 14, 14, 0), 1, 1)), ShouldBeNil)
+				bars := gb(NewTick(2, at(5, 14, 21, 30), 2, 1))
+				So(len(bars), ShouldEqual, 1)
+				So(bars[0].Begin, ShouldEqual, at(5, 13, 21, 0))
+				So(gb(NewTick(3, at(5, 17, 9, 0), 3, 1)), ShouldBeNil)
+				bars = gb(NilTick)
+				So(bars[0].Begin, ShouldEqual, at(5, 14, 21, 0))
+				So(bars[0].Open, ShouldEqual, 2)
+				So(bars[0].Close, ShouldEqual, 3)
+			})
+		})
+	})
+	Convey("不是夜盘的话，跨过午夜的交易时段属于开盘的那一天", t, func() {
+		c := NewCalendar(time.UTC, []time.Weekday{time.Sunday, time.Monday},
+			Session{Open: 17 * time.Hour, Close: 16 * time.Hour})
+		sunday := time.Date(2021, 5, 16, 17, 0, 0, 0, time.UTC)
+		So(c.IsOpen(sunday.Add(-time.Minute)), ShouldBeFalse)
+		So(c.IsOpen(sunday.Add(20*time.Hour)), ShouldBeTrue)
+		So(c.Begin(sunday.Add(20*time.Hour), Day), ShouldEqual, sunday)
+		So(c.IsOpen(sunday.Add(23*time.Hour)), ShouldBeFalse)
+		So(c.NextOpen(sunday.Add(time.Minute)), ShouldEqual, sunday.Add(Day))
+	})
+	Convey("日期的格式不对的话，AddHolidays 会 panic", t, func() {
+		So(func() { NewCalendar(time.UTC, nil).AddHolidays("2021/10/01") }, ShouldPanic)
+	})
+	Convey("没有记录的交易所，DefaultCalendar 会返回错误", t, func() {
+		_, err := DefaultCalendar(BINANCE)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func Test_GenTickBarFunc_session(t *testing.T) {
+	Convey("使用 Calendar.Begin 时，GenTickBarFunc 会跳过休市的时间", t, func() {
+		c, err := DefaultCalendar(SSE)
+		So(err, ShouldBeNil)
+		at := func(d, h, mi int) time.Time {
+			return time.Date(2021, 5, d, h, mi, 0, 0, c.Location)
+		}
+		gb := GenTickBarFunc(c.Begin, 30*time.Minute)
+		So(gb(NewTick(1, at(14, 9, 20), 1, 1)), ShouldBeNil)
+		So(gb(NewTick(2, at(14, 11, 10), 2, 1)), ShouldBeNil)
+		So(gb(NewTick(3, at(14, 12, 0), 3, 1)), ShouldBeNil)
+		bars := gb(NewTick(4, at(14, 13, 10), 4, 1))
+		So(len(bars), ShouldEqual, 1)
+		So(bars[0].Begin, ShouldEqual, at(14, 11, 0))
+		So(bars[0].Close, ShouldEqual, 2)
+		Convey("周末没有空 bar", func() {
+			bars := gb(NewTick(5, at(17, 9, 40), 5, 1))
+			So(len(bars), ShouldEqual, 4)
+			So(bars[0].Begin, ShouldEqual, at(14, 13, 0))
+			So(bars[3].Begin, ShouldEqual, at(14, 14, 30))
+		})
+		Convey("1 分钟的 bar 可以合并到交易时段的 bar 中", func() {
+			gbb := GenBarBarFunc(c.Begin, 30*time.Minute)
+			So(gbb(Bar{Begin: at(14, 11, 29), Interval: time.Minute, Close: 1, Volume: 1}), ShouldBeNil)
+			So(gbb(Bar{Begin: at(14, 12, 0), Interval: time.Minute, Close: 2, Volume: 1}), ShouldBeNil)
+			bars := gbb(Bar{Begin: at(14, 13, 0), Interval: time.Minute, Close: 3, Volume: 1})
+			So(len(bars), ShouldEqual, 1)
+			So(bars[0].Begin, ShouldEqual, at(14, 11, 0))
+			So(bars[0].Volume, ShouldEqual, 1)
+		})
+	})
+}
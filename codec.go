@@ -0,0 +1,128 @@
+package exch
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// CodecKey 是 message 的 Metadata 中记录 Codec 名称的键
+const CodecKey = "codec"
+
+// Codec 负责 Tick、Bar、Order、Balance 和 Asset 与 []byte 之间的转换
+// 因为 gob 的编码器会记住已经发送过的类型，
+// 编码器和解码器都需要成对地为每一个话题单独创建
+type Codec interface {
+	// Name 是记录在 message 的 Metadata 中的名称
+	Name() string
+	// NewEncoder 返回的函数能够把 e 转换成 []byte
+	NewEncoder() func(e interface{}) ([]byte, error)
+	// NewDecoder 返回的函数能够把 bs 转换到 e 中，e 需要是指针
+	NewDecoder() func(bs []byte, e interface{}) error
+}
+
+var (
+	// Gob 使用 encoding/gob 编码，只能在 Go 程序之间使用
+	// 没有在 Metadata 中记录 Codec 的 message，都是 Gob 编码的
+	Gob Codec = gobCodec{}
+	// JSON 使用 encoding/json 编码
+	// Decimal 会被编码成十进制的字符串，time.Time 是 RFC 3339 格式的字符串
+	JSON Codec = jsonCodec{}
+	// Protobuf 使用 protocol buffers 的格式编码，具体的格式在 exch.proto 中
+	Protobuf Codec = protoCodec{}
+)
+
+var codecs = map[string]Codec{
+	Gob.Name():      Gob,
+	JSON.Name():     JSON,
+	Protobuf.Name(): Protobuf,
+}
+
+// CodecByName 返回名称为 name 的 Codec
+func CodecByName(name string) (Codec, bool) {
+	c, ok := codecs[name]
+	return c, ok
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) NewEncoder() func(e interface{}) ([]byte, error) {
+	var bb bytes.Buffer
+	enc := gob.NewEncoder(&bb)
+	return func(e interface{}) ([]byte, error) {
+		bb.Reset()
+		if err := enc.Encode(e); err != nil {
+			return nil, err
+		}
+		res := make([]byte, bb.Len())
+		copy(res, bb.Bytes())
+		return res, nil
+	}
+}
+
+func (gobCodec) NewDecoder() func(bs []byte, e interface{}) error {
+	return decFunc()
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) NewEncoder() func(e interface{}) ([]byte, error) {
+	return json.Marshal
+}
+
+func (jsonCodec) NewDecoder() func(bs []byte, e interface{}) error {
+	return json.Unmarshal
+}
+
+// EncMsgFunc 返回的函数会用 c 把 e 编码成 message，
+// 并在 message 的 Metadata 中记录 c 的名称
+// 与 EncFunc 一样，无法编码时会 panic
+func EncMsgFunc(c Codec) func(e interface{}) *message.Message {
+	enc := c.NewEncoder()
+	return func(e interface{}) *message.Message {
+		payload, err := enc(e)
+		if err != nil {
+			panic(c.Name() + " encode error:" + err.Error())
+		}
+		msg := message.NewMessage(watermill.NewUUID(), payload)
+		msg.Metadata.Set(CodecKey, c.Name())
+		return msg
+	}
+}
+
+// DecMsgFunc 返回的函数会按照 msg 的 Metadata 中记录的 Codec，
+// 把 msg.Payload 转换到 e 中，e 需要是指针
+// 没有记录 Codec 的 msg 按照 Gob 解码
+// 同一个话题的 message 需要使用同一个 DecMsgFunc 返回的函数解码
+func DecMsgFunc() func(msg *message.Message, e interface{}) error {
+	decs := make(map[string]func([]byte, interface{}) error, 4)
+	return func(msg *message.Message, e interface{}) error {
+		name := msg.Metadata.Get(CodecKey)
+		if name == "" {
+			name = Gob.Name()
+		}
+		dec, ok := decs[name]
+		if !ok {
+			c, ok := CodecByName(name)
+			if !ok {
+				return fmt.Errorf("未知的 codec: %q", name)
+			}
+			dec = c.NewDecoder()
+			decs[name] = dec
+		}
+		return dec(msg.Payload, e)
+	}
+}
+
+// errUnsupported 表示 Codec 不支持 e 的类型
+func errUnsupported(c Codec, e interface{}) error {
+	return fmt.Errorf("%s: 不支持的类型 %T", c.Name(), e)
+}
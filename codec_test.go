@@ -0,0 +1,243 @@
+package exch
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func Test_Codec(t *testing.T) {
+	date := time.Date(2020, 5, 1, 8, 0, 0, 123, time.UTC)
+	order := Order{
+		Symbol:          "BTCUSDT",
+		AssetName:       "BTC",
+		CapitalName:     "USDT",
+		ID:              7,
+		Side:            BUY,
+		Type:            STOPlossLIMIT,
+		ClientOrderID:   "client",
+		AssetQuantity:   NewDecimal(0.5),
+		AssetPrice:      NewDecimal(10000.01),
+		StopPrice:       NewDecimal(9999),
+		TimeInForce:     GTD,
+		ExpireTime:      date,
+		Status:          PARTIALLYfilled,
+		FilledQuantity:  NewDecimal(0.1),
+		AvgPrice:        NewDecimal(10000),
+		UpdateTime:      date,
+		RejectReason:    "reason",
+		CapitalQuantity: -Satoshi,
+	}
+	for _, c := range []Codec{Gob, JSON, Protobuf} {
+		c := c
+		Convey(c.Name()+" 可以还原编码前的值", t, func() {
+			enc, dec := c.NewEncoder(), c.NewDecoder()
+			Convey("Tick", func() {
+				expected := NewTick(1, date, 10000.5, 0.001)
+				expected.Symbol = "BTCUSDT"
+				expected.Side = BUY
+				expected.Exchange = BINANCE
+				bs, err := enc(expected)
+				So(err, ShouldBeNil)
+				var actual Tick
+				So(dec(bs, &actual), ShouldBeNil)
+				So(actual.Date.Equal(expected.Date), ShouldBeTrue)
+				actual.Date = expected.Date
+				So(actual, ShouldResemble, expected)
+			})
+			Convey("Bar", func() {
+				expected := Bar{Symbol: "BTCUSDT", Begin: date, Interval: time.Minute,
+					Open: 1, High: 2.5, Low: 0.5, Close: 2, Volume: 100,
+					QuoteVolume: 150, VWAP: 1.5, Trades: 7, TakerBuyVolume: 40, TakerBuyQuoteVolume: 62}
+				bs, err := enc(&expected)
+				So(err, ShouldBeNil)
+				var actual Bar
+				So(dec(bs, &actual), ShouldBeNil)
+				So(actual.Begin.Equal(expected.Begin), ShouldBeTrue)
+				actual.Begin = expected.Begin
+				So(actual, ShouldResemble, expected)
+			})
+			Convey("Order", func() {
+				bs, err := enc(order)
+				So(err, ShouldBeNil)
+				var actual Order
+				So(dec(bs, &actual), ShouldBeNil)
+				So(actual.ExpireTime.Equal(order.ExpireTime), ShouldBeTrue)
+				So(actual.UpdateTime.Equal(order.UpdateTime), ShouldBeTrue)
+				actual.ExpireTime, actual.UpdateTime = order.ExpireTime, order.UpdateTime
+				So(actual, ShouldResemble, order)
+			})
+			Convey("Balance 和 Asset", func() {
+				expected := NewBalances(NewAsset("BTC", 1.5, 0.1), NewAsset("USDT", 100, 0))
+				bs, err := enc(expected)
+				So(err, ShouldBeNil)
+				var actual Balance
+				So(dec(bs, &actual), ShouldBeNil)
+				So(actual, ShouldResemble, expected)
+				bs, err = enc(expected["BTC"])
+				So(err, ShouldBeNil)
+				var asset Asset
+				So(dec(bs, &asset), ShouldBeNil)
+				So(asset, ShouldResemble, expected["BTC"])
+			})
+		})
+	}
+}
+
+func Test_Protobuf(t *testing.T) {
+	Convey("Protobuf 的编码符合 exch.proto", t, func() {
+		enc := Protobuf.NewEncoder()
+		bs, err := enc(Asset{Name: "BTC", Free: Satoshi, Locked: -Satoshi})
+		So(err, ShouldBeNil)
+		So(bs, ShouldResemble, []byte{0x0a, 3, 'B', 'T', 'C', 0x10, 2, 0x18, 1})
+		Convey("不支持的类型会返回错误", func() {
+			_, err := enc(1)
+			So(err, ShouldBeError)
+			So(Protobuf.NewDecoder()(bs, new(int)), ShouldBeError)
+		})
+		Convey("被截断的数据会返回错误", func() {
+			var asset Asset
+			So(Protobuf.NewDecoder()(bs[:3], &asset), ShouldBeError)
+		})
+		Convey("不认识的字段会被忽略", func() {
+			var asset Asset
+			bs = append(bs, 0x20, 5)
+			So(Protobuf.NewDecoder()(bs, &asset), ShouldBeNil)
+			So(asset.Name, ShouldEqual, "BTC")
+		})
+	})
+	Convey("Protobuf 与 protowire 按照 exch.proto 编码的结果一致", t, func() {
+		enc, dec := Protobuf.NewEncoder(), Protobuf.NewDecoder()
+		date := time.Date(2020, 5, 1, 8, 0, 0, 123, time.UTC)
+		// 与 proto3 一样，跳过零值的字段
+		varint := func(b []byte, num protowire.Number, v uint64) []byte {
+			if v == 0 {
+				return b
+			}
+			return protowire.AppendVarint(protowire.AppendTag(b, num, protowire.VarintType), v)
+		}
+		sint := func(b []byte, num protowire.Number, v Decimal) []byte {
+			return varint(b, num, protowire.EncodeZigZag(int64(v)))
+		}
+		str := func(b []byte, num protowire.Number, s string) []byte {
+			if s == "" {
+				return b
+			}
+			return protowire.AppendString(protowire.AppendTag(b, num, protowire.BytesType), s)
+		}
+		// optional 的时间字段，只要存在就会写入
+		optional := func(b []byte, num protowire.Number, t time.Time) []byte {
+			return protowire.AppendVarint(protowire.AppendTag(b, num, protowire.VarintType), uint64(t.UnixNano()))
+		}
+		Convey("Tick", func() {
+			tick := NewTick(1, date, 10000.5, 0.001)
+			tick.Symbol = "BTCUSDT"
+			tick.Side = BUY
+			tick.Exchange = BINANCE
+			var expected []byte
+			expected = str(expected, 1, tick.Symbol)
+			expected = varint(expected, 2, uint64(tick.ID))
+			expected = optional(expected, 3, tick.Date)
+			expected = sint(expected, 4, tick.Price)
+			expected = sint(expected, 5, tick.Volume)
+			expected = varint(expected, 6, protowire.EncodeZigZag(int64(tick.Side)))
+			expected = str(expected, 7, string(tick.Exchange))
+			bs, err := enc(tick)
+			So(err, ShouldBeNil)
+			So(bs, ShouldResemble, expected)
+		})
+		Convey("Order", func() {
+			order := NewOrder("BTCUSDT", "BTC", "USDT")
+			order.ID = 7
+			order.Side = SELL
+			order.Type = TAKEprofitLIMIT
+			order.ClientOrderID = "client"
+			order.AssetQuantity = NewDecimal(0.5)
+			order.AssetPrice = NewDecimal(10000.01)
+			order.StopPrice = NewDecimal(9999)
+			order.TimeInForce = GTD
+			order.ExpireTime = date
+			order.Status = REJECTED
+			order.UpdateTime = date
+			order.RejectReason = "reason"
+			var expected []byte
+			expected = str(expected, 1, order.Symbol)
+			expected = str(expected, 2, order.AssetName)
+			expected = str(expected, 3, order.CapitalName)
+			expected = varint(expected, 4, uint64(order.ID))
+			expected = varint(expected, 5, protowire.EncodeZigZag(int64(order.Side)))
+			expected = varint(expected, 6, uint64(order.Type))
+			expected = str(expected, 7, order.ClientOrderID)
+			expected = sint(expected, 8, order.AssetQuantity)
+			expected = sint(expected, 9, order.AssetPrice)
+			expected = sint(expected, 11, order.StopPrice)
+			expected = varint(expected, 12, uint64(order.TimeInForce))
+			expected = optional(expected, 13, order.ExpireTime)
+			expected = varint(expected, 14, uint64(order.Status))
+			expected = optional(expected, 17, order.UpdateTime)
+			expected = str(expected, 18, order.RejectReason)
+			bs, err := enc(order)
+			So(err, ShouldBeNil)
+			So(bs, ShouldResemble, expected)
+		})
+		Convey("字段的顺序不影响解码，不认识的字段会被忽略", func() {
+			var bs []byte
+			bs = protowire.AppendTag(bs, 99, protowire.Fixed32Type)
+			bs = protowire.AppendFixed32(bs, 42)
+			bs = sint(bs, 6, NewDecimal(2))
+			bs = varint(bs, 10, uint64(date.UnixNano()))
+			bs = str(bs, 2, "BTCUSDT")
+			bs = varint(bs, 1, 3)
+			var trade Trade
+			So(dec(bs, &trade), ShouldBeNil)
+			So(trade.OrderID, ShouldEqual, 3)
+			So(trade.Symbol, ShouldEqual, "BTCUSDT")
+			So(trade.Price, ShouldEqual, NewDecimal(2))
+			So(trade.Date.Equal(date), ShouldBeTrue)
+		})
+		Convey("1970-01-01T00:00:00Z 与零值的时间可以区分", func() {
+			epoch := time.Unix(0, 0).UTC()
+			bs, err := enc(Tick{Date: epoch})
+			So(err, ShouldBeNil)
+			So(bs, ShouldResemble, optional(nil, 3, epoch))
+			var tick Tick
+			So(dec(bs, &tick), ShouldBeNil)
+			So(tick.Date.IsZero(), ShouldBeFalse)
+			So(tick.Date.Equal(epoch), ShouldBeTrue)
+			bs, err = enc(Tick{})
+			So(err, ShouldBeNil)
+			So(bs, ShouldBeEmpty)
+			So(dec(bs, &tick), ShouldBeNil)
+			So(tick.Date.IsZero(), ShouldBeTrue)
+		})
+	})
+}
+
+func Test_EncMsgFunc(t *testing.T) {
+	Convey("message 的 Metadata 中记录了 Codec", t, func() {
+		tick := NewTick(1, time.Now(), 100, 1)
+		dec := DecMsgFunc()
+		for _, c := range []Codec{Gob, JSON, Protobuf} {
+			msg := EncMsgFunc(c)(tick)
+			So(msg.Metadata.Get(CodecKey), ShouldEqual, c.Name())
+			var actual Tick
+			So(dec(msg, &actual), ShouldBeNil)
+			So(actual.Price, ShouldEqual, tick.Price)
+		}
+		Convey("没有记录 Codec 的 message 按照 Gob 解码", func() {
+			msg := EncMsgFunc(Gob)(tick)
+			msg.Metadata.Set(CodecKey, "")
+			var actual Tick
+			So(DecMsgFunc()(msg, &actual), ShouldBeNil)
+			So(actual.Price, ShouldEqual, tick.Price)
+		})
+		Convey("未知的 Codec 会返回错误", func() {
+			msg := EncMsgFunc(JSON)(tick)
+			msg.Metadata.Set(CodecKey, "xml")
+			var actual Tick
+			So(dec(msg, &actual), ShouldBeError)
+		})
+	})
+}
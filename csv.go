@@ -0,0 +1,440 @@
+package exch
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TickReader 是 tick 的数据源，tick 按照时间顺序读出，读完以后返回 io.EOF
+type TickReader interface {
+	Read() (Tick, error)
+}
+
+// BarReader 是 bar 的数据源，bar 按照 Begin 的顺序读出，读完以后返回 io.EOF
+type BarReader interface {
+	Read() (Bar, error)
+}
+
+// CSVFormat 中使用的 Unix 时间戳格式
+// 其他的 TimeFormat 都会作为 time.Parse 的 layout，例如 time.RFC3339
+const (
+	UnixSecond = "unix"
+	UnixMilli  = "unixms"
+	UnixMicro  = "unixus"
+	UnixNano   = "unixns"
+)
+
+var unixUnits = map[string]time.Duration{
+	UnixSecond: time.Second,
+	UnixMilli:  time.Millisecond,
+	UnixMicro:  time.Microsecond,
+	UnixNano:   time.Nanosecond,
+}
+
+var errNoField = errors.New("没有这个字段")
+
+// TickColumns 和 BarColumns 是 CSV 文件中默认的列
+// Tick 的 Side 列写作 BUY 或 SELL，不知道主动方时是空白的单元格，
+// 读取时，不区分大小写，还可以使用 B、S 和 UNKNOWN
+var (
+	TickColumns = []string{"Exchange", "Symbol", "ID", "Date", "Price", "Volume", "Side"}
+	BarColumns  = []string{"Symbol", "Begin", "Interval", "Open", "High", "Low", "Close", "Volume",
+		"QuoteVolume", "VWAP", "Trades", "TakerBuyVolume", "TakerBuyQuoteVolume"}
+)
+
+// CSVFormat 描述了 CSV 文件的格式
+type CSVFormat struct {
+	// Columns 是每一列对应的字段名称，空字符串表示忽略这一列
+	// 读取时，Columns 为 nil 的话，有 Header 就按照表头，否则按照 TickColumns 或 BarColumns
+	// 写入时，Columns 为 nil 的话，按照 TickColumns 或 BarColumns
+	Columns []string
+	// TimeFormat 是 UnixSecond 等时间戳格式，或者 time.Parse 的 layout
+	// 为空时，使用 time.RFC3339Nano
+	// UnixSecond 的时间戳可以带有小数，例如 "1620000000.123"
+	TimeFormat string
+	// Location 是解析没有时区的时间，以及写入时间时使用的时区
+	// 为 nil 时，使用 time.UTC
+	Location *time.Location
+	// Header 为 true 表示第一行是表头
+	Header bool
+	// Comma 是分隔符，为 0 时使用 ','
+	Comma rune
+}
+
+func (f CSVFormat) location() *time.Location {
+	if f.Location == nil {
+		return time.UTC
+	}
+	return f.Location
+}
+
+func (f CSVFormat) layout() string {
+	if f.TimeFormat == "" {
+		return time.RFC3339Nano
+	}
+	return f.TimeFormat
+}
+
+func (f CSVFormat) parseTime(s string) (time.Time, error) {
+	if unit, ok := unixUnits[f.TimeFormat]; ok {
+		return parseUnix(s, unit)
+	}
+	return time.ParseInLocation(f.layout(), s, f.location())
+}
+
+func (f CSVFormat) formatTime(t time.Time) string {
+	if unit, ok := unixUnits[f.TimeFormat]; ok {
+		return strconv.FormatInt(t.UnixNano()/int64(unit), 10)
+	}
+	return t.In(f.location()).Format(f.layout())
+}
+
+// parseUnix 把以 unit 为单位的时间戳转换成时间，时间戳的小数部分最多精确到纳秒
+func parseUnix(s string, unit time.Duration) (time.Time, error) {
+	integer, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		integer, fraction = s[:i], s[i+1:]
+	}
+	n, err := strconv.ParseInt(integer, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// 单位是纳秒的 10 的几次方
+	digits := len(strconv.FormatInt(int64(unit), 10)) - 1
+	if len(fraction) > digits {
+		fraction = fraction[:digits]
+	}
+	var frac int64
+	if fraction != "" {
+		fraction += strings.Repeat("0", digits-len(fraction))
+		if frac, err = strconv.ParseInt(fraction, 10, 64); err != nil || frac < 0 {
+			return time.Time{}, fmt.Errorf("无法解析的时间戳 %q", s)
+		}
+		if strings.HasPrefix(integer, "-") {
+			frac = -frac
+		}
+	}
+	return time.Unix(0, n*int64(unit)+frac), nil
+}
+
+func parseSide(s string) (OrderSide, error) {
+	switch strings.ToUpper(s) {
+	case "BUY", "B":
+		return BUY, nil
+	case "SELL", "S":
+		return SELL, nil
+	case "UNKNOWN":
+		return 0, nil
+	}
+	return 0, fmt.Errorf("无法解析的方向 %q", s)
+}
+
+// formatSide 把不知道的方向写成空白，而不是 OrderSide.String 的 UNKNOWN
+func formatSide(side OrderSide) string {
+	if side != BUY && side != SELL {
+		return ""
+	}
+	return side.String()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// csvReader 负责 CSVTickReader 和 CSVBarReader 共同的部分
+type csvReader struct {
+	r        *csv.Reader
+	format   CSVFormat
+	defaults []string
+	line     int
+}
+
+func newCSVReader(r io.Reader, f CSVFormat, defaults []string) csvReader {
+	cr := csv.NewReader(r)
+	if f.Comma != 0 {
+		cr.Comma = f.Comma
+	}
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	return csvReader{r: cr, format: f, defaults: defaults}
+}
+
+// read 会用 set 把下一行的每一列写入对应的字段中
+func (r *csvReader) read(set func(name, value string) error) error {
+	if r.line == 0 {
+		if err := r.readHeader(); err != nil {
+			return err
+		}
+	}
+	record, err := r.r.Read()
+	if err != nil {
+		return err
+	}
+	r.line++
+	for i, name := range r.format.Columns {
+		if i >= len(record) {
+			break
+		}
+		value := strings.TrimSpace(record[i])
+		if name == "" || value == "" {
+			continue
+		}
+		if err := set(name, value); err != nil {
+			return fmt.Errorf("csv 第 %d 行的 %s: %w", r.line, name, err)
+		}
+	}
+	return nil
+}
+
+func (r *csvReader) readHeader() error {
+	if r.format.Header {
+		header, err := r.r.Read()
+		if err != nil {
+			return err
+		}
+		r.line++
+		if r.format.Columns == nil {
+			columns := make([]string, len(header))
+			for i, name := range header {
+				columns[i] = strings.TrimSpace(name)
+			}
+			r.format.Columns = columns
+		}
+	}
+	if r.format.Columns == nil {
+		r.format.Columns = r.defaults
+	}
+	return nil
+}
+
+// CSVTickReader 从 CSV 文件中读取 tick
+type CSVTickReader struct {
+	csvReader
+}
+
+// NewCSVTickReader 返回从 r 中按照 f 的格式读取 tick 的 CSVTickReader
+func NewCSVTickReader(r io.Reader, f CSVFormat) *CSVTickReader {
+	return &CSVTickReader{csvReader: newCSVReader(r, f, TickColumns)}
+}
+
+// Read 返回下一个 tick，读完以后返回 io.EOF
+// 空白的单元格，对应的字段是零值
+func (r *CSVTickReader) Read() (Tick, error) {
+	var t Tick
+	err := r.read(func(name, value string) (err error) {
+		switch name {
+		case "Exchange":
+			t.Exchange = Name(value)
+		case "Symbol":
+			t.Symbol = value
+		case "ID":
+			t.ID, err = strconv.ParseInt(value, 10, 64)
+		case "Date":
+			t.Date, err = r.format.parseTime(value)
+		case "Price":
+			t.Price, err = ParseDecimal(value)
+		case "Volume":
+			t.Volume, err = ParseDecimal(value)
+		case "Side":
+			t.Side, err = parseSide(value)
+		default:
+			err = errNoField
+		}
+		return err
+	})
+	if err != nil {
+		return Tick{}, err
+	}
+	return t, nil
+}
+
+// CSVBarReader 从 CSV 文件中读取 bar
+type CSVBarReader struct {
+	csvReader
+}
+
+// NewCSVBarReader 返回从 r 中按照 f 的格式读取 bar 的 CSVBarReader
+func NewCSVBarReader(r io.Reader, f CSVFormat) *CSVBarReader {
+	return &CSVBarReader{csvReader: newCSVReader(r, f, BarColumns)}
+}
+
+// Read 返回下一个 bar，读完以后返回 io.EOF
+// 空白的单元格，对应的字段是零值
+// Interval 的格式与 time.ParseDuration 的一样，例如 "1m0s"
+func (r *CSVBarReader) Read() (Bar, error) {
+	var b Bar
+	err := r.read(func(name, value string) (err error) {
+		parse := func(f *float64) {
+			*f, err = strconv.ParseFloat(value, 64)
+		}
+		switch name {
+		case "Symbol":
+			b.Symbol = value
+		case "Begin":
+			b.Begin, err = r.format.parseTime(value)
+		case "Interval":
+			b.Interval, err = time.ParseDuration(value)
+		case "Open":
+			parse(&b.Open)
+		case "High":
+			parse(&b.High)
+		case "Low":
+			parse(&b.Low)
+		case "Close":
+			parse(&b.Close)
+		case "Volume":
+			parse(&b.Volume)
+		case "QuoteVolume":
+			parse(&b.QuoteVolume)
+		case "VWAP":
+			parse(&b.VWAP)
+		case "Trades":
+			b.Trades, err = strconv.ParseInt(value, 10, 64)
+		case "TakerBuyVolume":
+			parse(&b.TakerBuyVolume)
+		case "TakerBuyQuoteVolume":
+			parse(&b.TakerBuyQuoteVolume)
+		default:
+			err = errNoField
+		}
+		return err
+	})
+	if err != nil {
+		return Bar{}, err
+	}
+	return b, nil
+}
+
+// csvWriter 负责 CSVTickWriter 和 CSVBarWriter 共同的部分
+type csvWriter struct {
+	w         *csv.Writer
+	format    CSVFormat
+	record    []string
+	isStarted bool
+}
+
+func newCSVWriter(w io.Writer, f CSVFormat, defaults []string) csvWriter {
+	cw := csv.NewWriter(w)
+	if f.Comma != 0 {
+		cw.Comma = f.Comma
+	}
+	if f.Columns == nil {
+		f.Columns = defaults
+	}
+	return csvWriter{w: cw, format: f, record: make([]string, len(f.Columns))}
+}
+
+// write 会用 get 得到每一列的内容，写入一行
+func (w *csvWriter) write(get func(name string) (string, error)) error {
+	if !w.isStarted {
+		w.isStarted = true
+		if w.format.Header {
+			if err := w.w.Write(w.format.Columns); err != nil {
+				return err
+			}
+		}
+	}
+	for i, name := range w.format.Columns {
+		w.record[i] = ""
+		if name == "" {
+			continue
+		}
+		value, err := get(name)
+		if err != nil {
+			return fmt.Errorf("csv 的 %s: %w", name, err)
+		}
+		w.record[i] = value
+	}
+	return w.w.Write(w.record)
+}
+
+// Flush 会把缓存的内容写入底层的 io.Writer，并返回写入时遇到的错误
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// CSVTickWriter 把 tick 写入 CSV 文件
+type CSVTickWriter struct {
+	csvWriter
+}
+
+// NewCSVTickWriter 返回按照 f 的格式向 w 写入 tick 的 CSVTickWriter
+// 写完以后，需要调用 Flush
+func NewCSVTickWriter(w io.Writer, f CSVFormat) *CSVTickWriter {
+	return &CSVTickWriter{csvWriter: newCSVWriter(w, f, TickColumns)}
+}
+
+// Write 把 t 写成一行
+func (w *CSVTickWriter) Write(t Tick) error {
+	return w.write(func(name string) (string, error) {
+		switch name {
+		case "Exchange":
+			return string(t.Exchange), nil
+		case "Symbol":
+			return t.Symbol, nil
+		case "ID":
+			return strconv.FormatInt(t.ID, 10), nil
+		case "Date":
+			return w.format.formatTime(t.Date), nil
+		case "Price":
+			return t.Price.String(), nil
+		case "Volume":
+			return t.Volume.String(), nil
+		case "Side":
+			return formatSide(t.Side), nil
+		}
+		return "", errNoField
+	})
+}
+
+// CSVBarWriter 把 bar 写入 CSV 文件
+type CSVBarWriter struct {
+	csvWriter
+}
+
+// NewCSVBarWriter 返回按照 f 的格式向 w 写入 bar 的 CSVBarWriter
+// 写完以后，需要调用 Flush
+func NewCSVBarWriter(w io.Writer, f CSVFormat) *CSVBarWriter {
+	return &CSVBarWriter{csvWriter: newCSVWriter(w, f, BarColumns)}
+}
+
+// Write 把 b 写成一行
+func (w *CSVBarWriter) Write(b Bar) error {
+	return w.write(func(name string) (string, error) {
+		switch name {
+		case "Symbol":
+			return b.Symbol, nil
+		case "Begin":
+			return w.format.formatTime(b.Begin), nil
+		case "Interval":
+			return b.Interval.String(), nil
+		case "Open":
+			return formatFloat(b.Open), nil
+		case "High":
+			return formatFloat(b.High), nil
+		case "Low":
+			return formatFloat(b.Low), nil
+		case "Close":
+			return formatFloat(b.Close), nil
+		case "Volume":
+			return formatFloat(b.Volume), nil
+		case "QuoteVolume":
+			return formatFloat(b.QuoteVolume), nil
+		case "VWAP":
+			return formatFloat(b.VWAP), nil
+		case "Trades":
+			return strconv.FormatInt(b.Trades, 10), nil
+		case "TakerBuyVolume":
+			return formatFloat(b.TakerBuyVolume), nil
+		case "TakerBuyQuoteVolume":
+			return formatFloat(b.TakerBuyQuoteVolume), nil
+		}
+		return "", errNoField
+	})
+}
@@ -0,0 +1,122 @@
+package exch
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_CSVTickReader(t *testing.T) {
+	Convey("CSVTickReader 可以按照表头读取 tick", t, func() {
+		data := "date,price,,volume,side\n" +
+			"1620000000123,100.5,x,2,buy\n" +
+			"1620000001000,101,x,,SELL\n"
+		r := NewCSVTickReader(strings.NewReader(data), CSVFormat{
+			Columns:    []string{"Date", "Price", "", "Volume", "Side"},
+			TimeFormat: UnixMilli,
+			Header:     true,
+		})
+		tick, err := r.Read()
+		So(err, ShouldBeNil)
+		So(tick.Date.Equal(time.Unix(1620000000, 123e6)), ShouldBeTrue)
+		So(tick.Price, ShouldEqual, NewDecimal(100.5))
+		So(tick.Volume, ShouldEqual, 2*DecimalOne)
+		So(tick.Side, ShouldEqual, BUY)
+		tick, err = r.Read()
+		So(err, ShouldBeNil)
+		So(tick.Volume, ShouldEqual, Decimal(0))
+		So(tick.Side, ShouldEqual, SELL)
+		_, err = r.Read()
+		So(err, ShouldEqual, io.EOF)
+	})
+	Convey("没有 Columns 时，按照表头中的字段名称读取", t, func() {
+		data := "Symbol;Date;Price\nBTCUSDT;2021-05-14 08:00:00;100\n"
+		loc := time.FixedZone("CST", 8*60*60)
+		r := NewCSVTickReader(strings.NewReader(data), CSVFormat{
+			TimeFormat: "2006-01-02 15:04:05",
+			Location:   loc,
+			Header:     true,
+			Comma:      ';',
+		})
+		tick, err := r.Read()
+		So(err, ShouldBeNil)
+		So(tick.Symbol, ShouldEqual, "BTCUSDT")
+		So(tick.Date.Equal(time.Date(2021, 5, 14, 0, 0, 0, 0, time.UTC)), ShouldBeTrue)
+	})
+	Convey("无法解析的内容会返回错误", t, func() {
+		r := NewCSVTickReader(strings.NewReader("Date,Price\nx,1\n"), CSVFormat{Header: true})
+		_, err := r.Read()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "第 2 行的 Date")
+		r = NewCSVTickReader(strings.NewReader("Foo\n1\n"), CSVFormat{Header: true})
+		_, err = r.Read()
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func Test_parseUnix(t *testing.T) {
+	Convey("parseUnix 可以解析带小数的时间戳", t, func() {
+		date, err := parseUnix("1620000000.5", time.Second)
+		So(err, ShouldBeNil)
+		So(date.Equal(time.Unix(1620000000, 5e8)), ShouldBeTrue)
+		date, err = parseUnix("1620000000123456", time.Microsecond)
+		So(err, ShouldBeNil)
+		So(date.Equal(time.Unix(1620000000, 123456e3)), ShouldBeTrue)
+		_, err = parseUnix("abc", time.Second)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func Test_CSVWriter(t *testing.T) {
+	Convey("CSVTickWriter 写入的 tick 可以被 CSVTickReader 读回来", t, func() {
+		date := time.Date(2021, 5, 14, 8, 0, 0, 123, time.UTC)
+		for _, expected := range []Tick{
+			NewTick(1, date, 100.5, 2, TickSide(SELL), TickSymbol("BTCUSDT"), TickExchange(BINANCE)),
+			NewTick(2, date, 100.5, 2, TickSymbol("BTCUSDT")),
+		} {
+			for _, format := range []CSVFormat{
+				{Header: true},
+				{TimeFormat: UnixNano, Location: time.FixedZone("CST", 8*60*60)},
+			} {
+				var buf bytes.Buffer
+				w := NewCSVTickWriter(&buf, format)
+				So(w.Write(expected), ShouldBeNil)
+				So(w.Flush(), ShouldBeNil)
+				actual, err := NewCSVTickReader(&buf, format).Read()
+				So(err, ShouldBeNil)
+				So(actual.Date.Equal(expected.Date), ShouldBeTrue)
+				actual.Date = expected.Date
+				So(actual, ShouldResemble, expected)
+			}
+		}
+		Convey("不知道主动方的 tick，Side 是空白的单元格", func() {
+			var buf bytes.Buffer
+			w := NewCSVTickWriter(&buf, CSVFormat{Columns: []string{"ID", "Side"}})
+			So(w.Write(NewTick(3, date, 100.5, 2)), ShouldBeNil)
+			So(w.Flush(), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "3,\n")
+			actual, err := NewCSVTickReader(strings.NewReader("3,unknown\n"), CSVFormat{Columns: []string{"ID", "Side"}}).Read()
+			So(err, ShouldBeNil)
+			So(actual.Side, ShouldEqual, OrderSide(0))
+		})
+	})
+	Convey("CSVBarWriter 写入的 bar 可以被 CSVBarReader 读回来", t, func() {
+		expected := Bar{Symbol: "BTCUSDT", Begin: time.Date(2021, 5, 14, 8, 0, 0, 0, time.UTC),
+			Interval: time.Minute, Open: 1, High: 2.5, Low: 0.5, Close: 2, Volume: 100,
+			QuoteVolume: 150, VWAP: 1.5, Trades: 7, TakerBuyVolume: 40, TakerBuyQuoteVolume: 62}
+		var buf bytes.Buffer
+		w := NewCSVBarWriter(&buf, CSVFormat{Header: true, TimeFormat: UnixSecond})
+		So(w.Write(expected), ShouldBeNil)
+		So(w.Flush(), ShouldBeNil)
+		So(buf.String(), ShouldStartWith, "Symbol,Begin,Interval,")
+		actual, err := NewCSVBarReader(&buf, CSVFormat{Header: true, TimeFormat: UnixSecond}).Read()
+		So(err, ShouldBeNil)
+		So(actual.Begin.Equal(expected.Begin), ShouldBeTrue)
+		actual.Begin = expected.Begin
+		So(actual, ShouldResemble, expected)
+	})
+}
@@ -0,0 +1,187 @@
+package exch
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Decimal 是保留 8 位小数的定点数，用来表示价格、数量和资产
+// 与 time.Duration 一样，Decimal 的底层是 int64，
+// 可以直接使用 + - 和比较运算符，乘除法需要使用 Mul 和 Div
+// gob 编码时，Decimal 就是一个整数，所以可以精确地还原
+type Decimal int64
+
+const (
+	// Satoshi 是 Decimal 可以表示的最小单位
+	Satoshi Decimal = 1
+	// DecimalOne 是 Decimal 表示的 1
+	DecimalOne = 100000000 * Satoshi
+	// decimalPlaces 是 Decimal 保留的小数位数
+	decimalPlaces = 8
+)
+
+// NewDecimal 把 f 四舍五入到最近的 Decimal
+// f 超出了 Decimal 的表示范围的话，会 panic
+func NewDecimal(f float64) Decimal {
+	r := math.Round(f * float64(DecimalOne))
+	if math.IsNaN(r) || r >= math.MaxInt64 || r < math.MinInt64 {
+		panic("NewDecimal: 超出了 Decimal 的表示范围")
+	}
+	return Decimal(r)
+}
+
+// ParseDecimal 会把十进制的字符串，例如 "-0.30000000"，转换成 Decimal
+// 超过 8 位的小数，只能是 0
+func ParseDecimal(s string) (Decimal, error) {
+	str := s
+	neg := false
+	if len(str) > 0 && (str[0] == '-' || str[0] == '+') {
+		neg = str[0] == '-'
+		str = str[1:]
+	}
+	integer, fraction := str, ""
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		integer, fraction = str[:i], str[i+1:]
+	}
+	if integer == "" && fraction == "" {
+		return 0, errors.New("ParseDecimal: 无法解析 " + strconv.Quote(s))
+	}
+	if len(fraction) > decimalPlaces {
+		if strings.Trim(fraction[decimalPlaces:], "0") != "" {
+			return 0, errors.New("ParseDecimal: " + strconv.Quote(s) + " 的小数超过了 8 位")
+		}
+		fraction = fraction[:decimalPlaces]
+	}
+	fraction += strings.Repeat("0", decimalPlaces-len(fraction))
+	digits := integer + fraction
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, errors.New("ParseDecimal: 无法解析 " + strconv.Quote(s))
+		}
+	}
+	u, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil || u > math.MaxInt64 {
+		return 0, errors.New("ParseDecimal: " + strconv.Quote(s) + " 超出了 Decimal 的表示范围")
+	}
+	if neg {
+		return -Decimal(u), nil
+	}
+	return Decimal(u), nil
+}
+
+// Float64 返回 d 的浮点数近似值
+func (d Decimal) Float64() float64 {
+	return float64(d) / float64(DecimalOne)
+}
+
+// String 返回 d 的十进制表示，不包含末尾多余的 0
+func (d Decimal) String() string {
+	u := uint64(d)
+	sign := ""
+	if d < 0 {
+		u = -u
+		sign = "-"
+	}
+	one := uint64(DecimalOne)
+	integer := strconv.FormatUint(u/one, 10)
+	fraction := strconv.FormatUint(u%one, 10)
+	if fraction == "0" {
+		return sign + integer
+	}
+	fraction = strings.Repeat("0", decimalPlaces-len(fraction)) + fraction
+	return sign + integer + "." + strings.TrimRight(fraction, "0")
+}
+
+// MarshalJSON 把 d 编码成十进制的字符串，例如 "0.1"，
+// 这样其他语言也可以精确地还原 d
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.String())), nil
+}
+
+// UnmarshalJSON 可以解码十进制的字符串，也可以解码 JSON 的数字
+func (d *Decimal) UnmarshalJSON(bs []byte) error {
+	s := string(bs)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	res, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = res
+	return nil
+}
+
+// Mul 返回 d * e，结果四舍五入到 Satoshi
+// 结果超出了 Decimal 的表示范围的话，会 panic
+func (d Decimal) Mul(e Decimal) Decimal {
+	neg := (d < 0) != (e < 0)
+	hi, lo := bits.Mul64(absDecimal(d), absDecimal(e))
+	return quotient(hi, lo, uint64(DecimalOne), neg)
+}
+
+// Div 返回 d / e，结果四舍五入到 Satoshi
+// e 为 0，或者结果超出了 Decimal 的表示范围的话，会 panic
+func (d Decimal) Div(e Decimal) Decimal {
+	if e == 0 {
+		panic("Decimal.Div: 除数为 0")
+	}
+	neg := (d < 0) != (e < 0)
+	hi, lo := bits.Mul64(absDecimal(d), uint64(DecimalOne))
+	return quotient(hi, lo, absDecimal(e), neg)
+}
+
+// MulFloat 返回 d * f，结果四舍五入到 Satoshi
+// 适合 d 乘以费率之类的比例，计算过程使用的是 float64
+func (d Decimal) MulFloat(f float64) Decimal {
+	return NewDecimal(d.Float64() * f)
+}
+
+// MinDecimal 返回 a 和 b 中较小的那个
+func MinDecimal(a, b Decimal) Decimal {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// MaxDecimal 返回 a 和 b 中较大的那个
+func MaxDecimal(a, b Decimal) Decimal {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absDecimal(d Decimal) uint64 {
+	if d < 0 {
+		return uint64(-d)
+	}
+	return uint64(d)
+}
+
+// quotient 返回 (hi, lo) / y 四舍五入后的结果
+// neg 为 true 时，结果是负数
+func quotient(hi, lo, y uint64, neg bool) Decimal {
+	if hi >= y {
+		panic("Decimal: 超出了 Decimal 的表示范围")
+	}
+	q, r := bits.Div64(hi, lo, y)
+	// r >= y - r 时，进位
+	if r >= y-r {
+		q++
+	}
+	if q > math.MaxInt64 {
+		panic("Decimal: 超出了 Decimal 的表示范围")
+	}
+	if neg {
+		return -Decimal(q)
+	}
+	return Decimal(q)
+}
@@ -0,0 +1,111 @@
+package exch
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Decimal(t *testing.T) {
+	Convey("Decimal 是保留 8 位小数的定点数", t, func() {
+		Convey("0.1 + 0.2 正好等于 0.3", func() {
+			So(NewDecimal(0.1)+NewDecimal(0.2), ShouldEqual, NewDecimal(0.3))
+			So((NewDecimal(0.1) + NewDecimal(0.2)).String(), ShouldEqual, "0.3")
+		})
+		Convey("NewDecimal 会四舍五入到 Satoshi", func() {
+			So(NewDecimal(0.000000016), ShouldEqual, 2*Satoshi)
+			So(NewDecimal(-1.5), ShouldEqual, -DecimalOne-DecimalOne/2)
+			So(NewDecimal(0.12345678).Float64(), ShouldEqual, 0.12345678)
+			So(func() { NewDecimal(1e11) }, ShouldPanic)
+		})
+		Convey("String 不包含末尾多余的 0", func() {
+			So(Decimal(0).String(), ShouldEqual, "0")
+			So(Satoshi.String(), ShouldEqual, "0.00000001")
+			So((100 * DecimalOne).String(), ShouldEqual, "100")
+			So(NewDecimal(-12.5).String(), ShouldEqual, "-12.5")
+		})
+		Convey("Mul 和 Div 会四舍五入到 Satoshi", func() {
+			So(NewDecimal(1.5).Mul(NewDecimal(-2)), ShouldEqual, NewDecimal(-3))
+			So(NewDecimal(0.00000001).Mul(NewDecimal(0.5)), ShouldEqual, Satoshi)
+			So(NewDecimal(0.00000001).Mul(NewDecimal(0.49)), ShouldEqual, 0)
+			So(DecimalOne.Div(3*DecimalOne), ShouldEqual, NewDecimal(0.33333333))
+			So(NewDecimal(2).Div(NewDecimal(-3)), ShouldEqual, NewDecimal(-0.66666667))
+			Convey("大数相乘不会溢出中间结果", func() {
+				big := NewDecimal(90000)
+				So(big.Mul(NewDecimal(1000000)), ShouldEqual, NewDecimal(90000000000))
+				So(big.Mul(big).Div(big), ShouldEqual, big)
+			})
+			Convey("结果溢出会 panic", func() {
+				So(func() { NewDecimal(1e10).Mul(NewDecimal(1e10)) }, ShouldPanic)
+				So(func() { DecimalOne.Div(0) }, ShouldPanic)
+			})
+		})
+		Convey("MulFloat 适合乘以比例", func() {
+			So(NewDecimal(20000).MulFloat(0.001), ShouldEqual, NewDecimal(20))
+		})
+		Convey("MinDecimal 和 MaxDecimal", func() {
+			So(MinDecimal(DecimalOne, Satoshi), ShouldEqual, Satoshi)
+			So(MaxDecimal(DecimalOne, Satoshi), ShouldEqual, DecimalOne)
+		})
+	})
+}
+
+func Test_ParseDecimal(t *testing.T) {
+	Convey("ParseDecimal 可以精确地解析十进制字符串", t, func() {
+		for s, expected := range map[string]Decimal{
+			"0.3":           NewDecimal(0.3),
+			"-0.30000000":   NewDecimal(-0.3),
+			"+12":           12 * DecimalOne,
+			".5":            DecimalOne / 2,
+			"7.":            7 * DecimalOne,
+			"0.00000001000": Satoshi,
+		} {
+			d, err := ParseDecimal(s)
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, expected)
+		}
+		Convey("无法解析的字符串会返回错误", func() {
+			for _, s := range []string{"", "-", ".", "1e8", "0.000000001", "1.2.3", "99999999999999"} {
+				_, err := ParseDecimal(s)
+				So(err, ShouldNotBeNil)
+			}
+		})
+		Convey("String 和 ParseDecimal 可以相互转换", func() {
+			d := NewDecimal(-1234.56789012)
+			actual, err := ParseDecimal(d.String())
+			So(err, ShouldBeNil)
+			So(actual, ShouldEqual, d)
+		})
+	})
+}
+
+func Test_Decimal_gob(t *testing.T) {
+	Convey("Decimal 可以精确地通过 gob 还原", t, func() {
+		expected := NewBalances(
+			Asset{Name: "BTC", Free: Satoshi, Locked: NewDecimal(0.3)},
+			Asset{Name: "USDT", Free: 1<<63 - 1, Locked: -Satoshi},
+		)
+		enc := EncFunc()
+		dec := DecBalanceFunc()
+		So(*dec(enc(expected)), ShouldResemble, expected)
+	})
+}
+
+func Test_Decimal_JSON(t *testing.T) {
+	Convey("Decimal 在 JSON 中是十进制的字符串", t, func() {
+		bs, err := json.Marshal(NewDecimal(-0.1))
+		So(err, ShouldBeNil)
+		So(string(bs), ShouldEqual, `"-0.1"`)
+		var d Decimal
+		So(json.Unmarshal(bs, &d), ShouldBeNil)
+		So(d, ShouldEqual, NewDecimal(-0.1))
+		Convey("也可以解码 JSON 的数字", func() {
+			So(json.Unmarshal([]byte("12.5"), &d), ShouldBeNil)
+			So(d, ShouldEqual, NewDecimal(12.5))
+		})
+		Convey("无法解析的字符串会返回错误", func() {
+			So(json.Unmarshal([]byte(`"abc"`), &d), ShouldBeError)
+		})
+	})
+}
@@ -0,0 +1,169 @@
+package exch
+
+import "fmt"
+
+// 以下的 GenXxxBarFunc 按照市场的活跃程度，而不是时间，生成 bar
+// 它们返回的闭包函数与 GenTickBarFunc 的一样，
+// 接收 tick，在满足条件时返回生成的 bar，接收到 NilTick 时，返回还没有完成的最后一个 bar
+// bar 的 Begin 是它的第一个 tick 的时间，Interval 是第一个 tick 到最后一个 tick 的时长
+// 不同 Symbol 的 tick 需要分别生成 bar
+
+// tickAcc 会把 tick 累积成 bar
+type tickAcc struct {
+	bar    Bar
+	count  int
+	volume Decimal
+	dollar Decimal
+	// high 和 low 是 Decimal 格式的 bar.High 和 bar.Low
+	high, low Decimal
+	isEmpty   bool
+}
+
+func newTickAcc() *tickAcc {
+	return &tickAcc{isEmpty: true}
+}
+
+func (a *tickAcc) add(tick Tick) {
+	price := tick.Price.Float64()
+	if a.isEmpty {
+		a.bar = Bar{
+			Symbol: tick.Symbol,
+			Begin:  tick.Date,
+			Open:   price,
+			High:   price,
+			Low:    price,
+		}
+		a.high, a.low = tick.Price, tick.Price
+		a.isEmpty = false
+	}
+	if tick.Date.Before(a.bar.Begin.Add(a.bar.Interval)) {
+		panic("GenBar: Ticks should be sorted in date")
+	}
+	a.bar.Interval = tick.Date.Sub(a.bar.Begin)
+	a.bar.High = maxFloat64(a.bar.High, price)
+	a.bar.Low = minFloat64(a.bar.Low, price)
+	a.bar.Close = price
+	a.bar.addTick(tick)
+	a.high = MaxDecimal(a.high, tick.Price)
+	a.low = MinDecimal(a.low, tick.Price)
+	a.count++
+	a.volume += tick.Volume
+	a.dollar += tick.Price.Mul(tick.Volume)
+}
+
+// flush 返回累积的 bar，并清空 a
+func (a *tickAcc) flush() []Bar {
+	if a.isEmpty {
+		return nil
+	}
+	res := []Bar{a.bar}
+	*a = tickAcc{isEmpty: true}
+	return res
+}
+
+// genAccBarFunc 在 isFull 返回 true 时，返回累积的 bar
+func genAccBarFunc(isFull func(*tickAcc) bool) func(Tick) []Bar {
+	acc := newTickAcc()
+	return func(tick Tick) []Bar {
+		if tick == NilTick {
+			return acc.flush()
+		}
+		acc.add(tick)
+		if isFull(acc) {
+			return acc.flush()
+		}
+		return nil
+	}
+}
+
+// GenCountBarFunc 返回的闭包函数每收到 n 个 tick，生成一个 bar
+func GenCountBarFunc(n int) func(Tick) []Bar {
+	if n <= 0 {
+		panic(fmt.Sprintf("GenCountBarFunc: n 应该大于 0，而不是 %d", n))
+	}
+	return genAccBarFunc(func(a *tickAcc) bool {
+		return a.count >= n
+	})
+}
+
+// GenVolumeBarFunc 返回的闭包函数在累积的成交量达到 volume 时，生成一个 bar
+// tick 不会被拆分，所以 bar 的 Volume 可能会超过 volume
+func GenVolumeBarFunc(volume Decimal) func(Tick) []Bar {
+	if volume <= 0 {
+		panic("GenVolumeBarFunc: volume 应该大于 0，而不是 " + volume.String())
+	}
+	return genAccBarFunc(func(a *tickAcc) bool {
+		return a.volume >= volume
+	})
+}
+
+// GenDollarBarFunc 返回的闭包函数在累积的成交额达到 dollar 时，生成一个 bar
+// 成交额是 tick 的 Price 乘以 Volume，以计价资产计算
+// tick 不会被拆分，所以 bar 的成交额可能会超过 dollar
+func GenDollarBarFunc(dollar Decimal) func(Tick) []Bar {
+	if dollar <= 0 {
+		panic("GenDollarBarFunc: dollar 应该大于 0，而不是 " + dollar.String())
+	}
+	return genAccBarFunc(func(a *tickAcc) bool {
+		return a.dollar >= dollar
+	})
+}
+
+// GenRangeBarFunc 返回的闭包函数在 bar 的最高价与最低价之差达到 size 时，生成一个 bar
+// 价格跳空时，bar 的 High - Low 可能会超过 size
+func GenRangeBarFunc(size Decimal) func(Tick) []Bar {
+	if size <= 0 {
+		panic("GenRangeBarFunc: size 应该大于 0，而不是 " + size.String())
+	}
+	return genAccBarFunc(func(a *tickAcc) bool {
+		return a.high-a.low >= size
+	})
+}
+
+// GenRenkoBarFunc 返回的闭包函数在价格比上一块砖的 Close 高出或者低出 brick 时，生成新的砖
+// 第一个 tick 的价格是第一块砖的起点
+// 砖的 Open 和 Close 是砖的起点和终点，High 和 Low 是它们中的较大值和较小值
+// 价格一次移动了多块砖的话，会同时返回多块砖，
+// 除了第一块以外，其余的砖的 Begin 都是那个 tick 的时间，Interval 和 Volume 都是 0
+// 价格反转一块砖就会生成反向的砖
+// 没有完成的砖不是 bar，所以接收到 NilTick 时，不会返回 bar
+func GenRenkoBarFunc(brick Decimal) func(Tick) []Bar {
+	if brick <= 0 {
+		panic("GenRenkoBarFunc: brick 应该大于 0，而不是 " + brick.String())
+	}
+	acc := newTickAcc()
+	var base Decimal
+	isInited := false
+	return func(tick Tick) []Bar {
+		if tick == NilTick {
+			return nil
+		}
+		if !isInited {
+			base = tick.Price
+			isInited = true
+		}
+		acc.add(tick)
+		var res []Bar
+		for {
+			var next Decimal
+			switch {
+			case tick.Price >= base+brick:
+				next = base + brick
+			case tick.Price <= base-brick:
+				next = base - brick
+			default:
+				return res
+			}
+			bar := Bar{Symbol: tick.Symbol, Begin: tick.Date}
+			if len(res) == 0 {
+				bar = acc.flush()[0]
+			}
+			bar.Open, bar.Close = base.Float64(), next.Float64()
+			bar.High = maxFloat64(bar.Open, bar.Close)
+			bar.Low = minFloat64(bar.Open, bar.Close)
+			bar.updateVWAP()
+			res = append(res, bar)
+			base = next
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package exch
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_infoBars(t *testing.T) {
+	begin := time.Date(2021, 5, 14, 9, 0, 0, 0, time.UTC)
+	tick := func(i int, price, volume float64) Tick {
+		return NewTick(int64(i), begin.Add(time.Duration(i)*time.Second), price, volume)
+	}
+	Convey("GenCountBarFunc 每 n 个 tick 生成一个 bar", t, func() {
+		gb := GenCountBarFunc(3)
+		So(gb(tick(0, 10, 1)), ShouldBeNil)
+		So(gb(tick(1, 12, 1)), ShouldBeNil)
+		bars := gb(tick(2, 9, 1))
+		So(len(bars), ShouldEqual, 1)
+		So(bars[0], ShouldResemble, Bar{Begin: begin, Interval: 2 * time.Second,
+			Open: 10, High: 12, Low: 9, Close: 9, Volume: 3,
+			QuoteVolume: 31, VWAP: 31.0 / 3, Trades: 3})
+		So(gb(tick(3, 11, 1)), ShouldBeNil)
+		bars = gb(NilTick)
+		So(len(bars), ShouldEqual, 1)
+		So(bars[0].Open, ShouldEqual, 11)
+		So(gb(NilTick), ShouldBeNil)
+		So(func() { GenCountBarFunc(0) }, ShouldPanic)
+	})
+	Convey("GenVolumeBarFunc 在成交量达到 volume 时生成 bar", t, func() {
+		gb := GenVolumeBarFunc(NewDecimal(5))
+		So(gb(tick(0, 10, 2)), ShouldBeNil)
+		bars := gb(tick(1, 10, 4))
+		So(len(bars), ShouldEqual, 1)
+		So(bars[0].Volume, ShouldEqual, 6)
+	})
+	Convey("GenDollarBarFunc 在成交额达到 dollar 时生成 bar", t, func() {
+		gb := GenDollarBarFunc(NewDecimal(100))
+		So(gb(tick(0, 10, 5)), ShouldBeNil)
+		So(gb(tick(1, 20, 2)), ShouldBeNil)
+		bars := gb(tick(2, 5, 2))
+		So(len(bars), ShouldEqual, 1)
+		So(bars[0].Volume, ShouldEqual, 9)
+	})
+	Convey("GenRangeBarFunc 在价格波动达到 size 时生成 bar", t, func() {
+		gb := GenRangeBarFunc(NewDecimal(1))
+		So(gb(tick(0, 10, 1)), ShouldBeNil)
+		So(gb(tick(1, 10.5, 1)), ShouldBeNil)
+		So(gb(tick(2, 9.6, 1)), ShouldBeNil)
+		bars := gb(tick(3, 9.5, 1))
+		So(len(bars), ShouldEqual, 1)
+		So(bars[0].High, ShouldEqual, 10.5)
+		So(bars[0].Low, ShouldEqual, 9.5)
+	})
+	Convey("GenRenkoBarFunc 在价格移动 brick 时生成砖", t, func() {
+		gb := GenRenkoBarFunc(NewDecimal(10))
+		So(gb(tick(0, 100, 1)), ShouldBeNil)
+		So(gb(tick(1, 105, 1)), ShouldBeNil)
+		bars := gb(tick(2, 125, 1))
+		So(len(bars), ShouldEqual, 2)
+		So(bars[0], ShouldResemble, Bar{Begin: begin, Interval: 2 * time.Second,
+			Open: 100, High: 110, Low: 100, Close: 110, Volume: 3,
+			QuoteVolume: 330, VWAP: 110, Trades: 3})
+		So(bars[1], ShouldResemble, Bar{Begin: begin.Add(2 * time.Second),
+			Open: 110, High: 120, Low: 110, Close: 120, VWAP: 120})
+		Convey("价格反转一块砖就会生成反向的砖", func() {
+			bars := gb(tick(3, 110, 1))
+			So(len(bars), ShouldEqual, 1)
+			So(bars[0].Open, ShouldEqual, 120)
+			So(bars[0].Close, ShouldEqual, 110)
+			So(gb(NilTick), ShouldBeNil)
+		})
+	})
+}
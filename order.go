@@ -1,9 +1,8 @@
 package exch
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
+	"sync"
 	"time"
 )
 
@@ -28,7 +27,8 @@ func (t OrderSide) String() string {
 	case SELL:
 		return "SELL"
 	default:
-		panic("meet UNKNOWN Order Side")
+		// tick 不知道主动方时，Side 为 0
+		return "UNKNOWN"
 	}
 }
 
@@ -73,20 +73,105 @@ func (t OrderType) String() string {
 	}
 }
 
+// TimeInForce 是订单的有效方式
+type TimeInForce uint8
+
+// TimeInForce 是订单的有效方式
+// 类型值从 iota+1 也就是 1 开始
+// 是为了避开默认的 0 值，0 会被当作 GTC 处理
+const (
+	// GTC 订单会一直有效，直到全部成交或者被撤销
+	GTC TimeInForce = iota + 1
+	// IOC 订单只会与提交后的第一个 tick 撮合，没有成交的部分会被撤销
+	IOC
+	// FOK 订单只有在能够全部成交时才会成交，否则会被撤销
+	FOK
+	// GTD 订单会在 ExpireTime 到期后被撤销
+	GTD
+)
+
+func (t TimeInForce) String() string {
+	switch t {
+	case 0, GTC:
+		// 0 会被当作 GTC 处理
+		return "GTC"
+	case IOC:
+		return "IOC"
+	case FOK:
+		return "FOK"
+	case GTD:
+		return "GTD"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// OrderStatus 是订单的状态
+type OrderStatus uint8
+
+// OrderStatus 是订单的状态
+// 类型值从 iota+1 也就是 1 开始
+// 是为了避开默认的 0 值，0 表示订单还没有被交易所受理
+const (
+	NEW OrderStatus = iota + 1
+	PARTIALLYfilled
+	FILLED
+	CANCELED
+	REJECTED
+	EXPIRED
+)
+
+func (s OrderStatus) String() string {
+	switch s {
+	case NEW:
+		return "NEW"
+	case PARTIALLYfilled:
+		return "PARTIALLY_FILLED"
+	case FILLED:
+		return "FILLED"
+	case CANCELED:
+		return "CANCELED"
+	case REJECTED:
+		return "REJECTED"
+	case EXPIRED:
+		return "EXPIRED"
+	default:
+		// 还没有被交易所受理的订单，Status 为 0
+		return "UNKNOWN"
+	}
+}
+
 // Order 是下单的格式
 type Order struct {
 	Symbol      string
 	AssetName   string
 	CapitalName string
 	// if ID is negative value, means unset
-	// ID is time.Now().Unix()
+	// ID is generated by NewOrderID
 	ID   int64
 	Side OrderSide
 	Type OrderType
-	// 根据 Type 的不同，以下 3 个属性不是全都必须的
-	AssetQuantity   float64
-	AssetPrice      float64
-	CapitalQuantity float64
+	// ClientOrderID 由策略设置，交易中心会拒绝重复的 ClientOrderID
+	// 为空时，不做检查
+	ClientOrderID string
+	// 根据 Type 的不同，以下 4 个属性不是全都必须的
+	AssetQuantity   Decimal
+	AssetPrice      Decimal
+	CapitalQuantity Decimal
+	// StopPrice 是 STOP_LOSS 和 TAKE_PROFIT 系列订单的触发价格
+	StopPrice Decimal
+	// TimeInForce 是订单的有效方式，ExpireTime 是 GTD 订单的到期时间
+	TimeInForce TimeInForce
+	ExpireTime  time.Time
+	// 以下属性由交易所维护，下单时不用设置
+	Status OrderStatus
+	// FilledQuantity 是已经成交的 Asset 数量
+	// AvgPrice 是已经成交部分的均价
+	FilledQuantity Decimal
+	AvgPrice       Decimal
+	// UpdateTime 是 Status 最后一次变化的时间
+	UpdateTime   time.Time
+	RejectReason string
 }
 
 // IsEmpty 用于判断 Order 是否是空订单
@@ -97,7 +182,7 @@ func (o Order) IsEmpty() bool {
 func (o Order) String() string {
 	acid := fmt.Sprintf("[%s-%s:%d]", o.AssetName, o.CapitalName, o.ID)
 	st := fmt.Sprintf("[S:%s,T:%s]", o.Side, o.Type)
-	aac := fmt.Sprintf("[%f:%f:%f]", o.AssetQuantity, o.AssetPrice, o.CapitalQuantity)
+	aac := fmt.Sprintf("[%s:%s:%s]", o.AssetQuantity, o.AssetPrice, o.CapitalQuantity)
 	return acid + st + aac
 }
 
@@ -110,24 +195,85 @@ func NewOrder(symbol, asset, capital string) Order {
 		AssetName:   asset,
 		CapitalName: capital,
 		ID:          -1,
+		TimeInForce: GTC,
 	}
 }
 
-// With 可以生成一个根据 apply 实施的新订单
-func (o Order) With(apply func(*Order)) *Order {
+// With 可以生成一个根据 applies 依次实施的新订单
+func (o Order) With(applies ...func(*Order)) *Order {
 	res := o // deep copy
-	apply(&res)
-	res.ID = time.Now().Unix()
+	for _, apply := range applies {
+		apply(&res)
+	}
+	res.ID = NewOrderID()
 	return &res
 }
 
+// NewOrderID 会为 With 生成的新订单生成 ID
+// NewOrderID 可能会被并发调用，在程序运行中替换它并不安全
+// 回测需要确定的 ID 时，可以使用 backtest.WithIDFunc
+var NewOrderID = MonotonicIDFunc()
+
+// MonotonicIDFunc 返回的函数会以当前时间的纳秒数作为 ID
+// 同一纳秒内，或者时钟回拨时，返回上一个 ID 加 1
+// 所以，返回的 ID 总是严格递增的
+func MonotonicIDFunc() func() int64 {
+	var mutex sync.Mutex
+	var last int64
+	return func() int64 {
+		mutex.Lock()
+		defer mutex.Unlock()
+		id := time.Now().UnixNano()
+		if id <= last {
+			id = last + 1
+		}
+		last = id
+		return id
+	}
+}
+
+// ClientOrderID 会设置订单的 ClientOrderID
+// 例如 o.With(Limit(BUY, 1, 10000), ClientOrderID("my-order-1"))
+func ClientOrderID(id string) func(*Order) {
+	return func(o *Order) {
+		o.ClientOrderID = id
+	}
+}
+
+// InForce 会设置订单的有效方式
+// 例如 o.With(Limit(BUY, 1, 10000), InForce(IOC))
+// GTD 订单请使用 GoodTillDate
+func InForce(tif TimeInForce) func(*Order) {
+	return func(o *Order) {
+		o.TimeInForce = tif
+	}
+}
+
+// GoodTillDate 会把订单设置成在 expire 到期的 GTD 订单
+func GoodTillDate(expire time.Time) func(*Order) {
+	return func(o *Order) {
+		o.TimeInForce = GTD
+		o.ExpireTime = expire
+	}
+}
+
 // Limit 会按照限价单的方式设置订单
+// 这一系列设置函数的 quantity、price 和 stop 都会被四舍五入到 Decimal
 func Limit(side OrderSide, quantity, price float64) func(*Order) {
 	return func(o *Order) {
 		o.Type = LIMIT
 		o.Side = side
-		o.AssetQuantity = quantity
-		o.AssetPrice = price
+		o.AssetQuantity = NewDecimal(quantity)
+		o.AssetPrice = NewDecimal(price)
+	}
+}
+
+// LimitMaker 会按照只做 maker 的限价单的方式设置订单
+// 如果订单在提交时会立即成交，交易所会拒绝这个订单
+func LimitMaker(side OrderSide, quantity, price float64) func(*Order) {
+	return func(o *Order) {
+		Limit(side, quantity, price)(o)
+		o.Type = LIMITmaker
 	}
 }
 
@@ -138,23 +284,75 @@ func Market(side OrderSide, quantity float64) func(*Order) {
 		o.Side = side
 		switch side {
 		case BUY:
-			o.CapitalQuantity = quantity
+			o.CapitalQuantity = NewDecimal(quantity)
 		case SELL:
-			o.AssetQuantity = quantity
+			o.AssetQuantity = NewDecimal(quantity)
 		}
 	}
 }
 
+// StopLoss 会按照止损单的方式设置订单
+// 价格触及 stop 后，订单会变成市价单，所以 quantity 的含义与 Market 一样
+// BUY  止损单会在价格上涨到 stop 时触发
+// SELL 止损单会在价格下跌到 stop 时触发
+func StopLoss(side OrderSide, quantity, stop float64) func(*Order) {
+	return func(o *Order) {
+		Market(side, quantity)(o)
+		o.Type = STOPloss
+		o.StopPrice = NewDecimal(stop)
+	}
+}
+
+// StopLossLimit 会按照限价止损单的方式设置订单
+// 价格触及 stop 后，订单会变成 price 的限价单
+func StopLossLimit(side OrderSide, quantity, stop, price float64) func(*Order) {
+	return func(o *Order) {
+		Limit(side, quantity, price)(o)
+		o.Type = STOPlossLIMIT
+		o.StopPrice = NewDecimal(stop)
+	}
+}
+
+// TakeProfit 会按照止盈单的方式设置订单
+// 价格触及 stop 后，订单会变成市价单，所以 quantity 的含义与 Market 一样
+// BUY  止盈单会在价格下跌到 stop 时触发
+// SELL 止盈单会在价格上涨到 stop 时触发
+func TakeProfit(side OrderSide, quantity, stop float64) func(*Order) {
+	return func(o *Order) {
+		Market(side, quantity)(o)
+		o.Type = TAKEprofit
+		o.StopPrice = NewDecimal(stop)
+	}
+}
+
+// TakeProfitLimit 会按照限价止盈单的方式设置订单
+// 价格触及 stop 后，订单会变成 price 的限价单
+func TakeProfitLimit(side OrderSide, quantity, stop, price float64) func(*Order) {
+	return func(o *Order) {
+		Limit(side, quantity, price)(o)
+		o.Type = TAKEprofitLIMIT
+		o.StopPrice = NewDecimal(stop)
+	}
+}
+
+// DecOrderErrFunc 返回的函数会把序列化成 []byte 的 Order 值转换回来
+// bs 无法转换成 Order 时，返回错误
+func DecOrderErrFunc() func(bs []byte) (*Order, error) {
+	dec := decFunc()
+	return func(bs []byte) (*Order, error) {
+		var order Order
+		err := dec(bs, &order)
+		return &order, err
+	}
+}
+
 // DecOrderFunc 返回的函数会把序列化成 []byte 的 Order 值转换回来
+// bs 无法转换时，返回的是零值，需要处理错误的话，请使用 DecOrderErrFunc
 func DecOrderFunc() func(bs []byte) *Order {
-	var buf bytes.Buffer
-	dec := gob.NewDecoder(&buf)
+	dec := DecOrderErrFunc()
 	return func(bs []byte) *Order {
-		buf.Reset()
-		buf.Write(bs)
-		var order Order
-		dec.Decode(&order)
-		return &order
+		order, _ := dec(bs)
+		return order
 	}
 }
 
@@ -187,6 +385,62 @@ func (o *Order) IsLessThan(a *Order) bool {
 		(o.AssetPrice == a.AssetPrice && o.ID < a.ID)
 }
 
-type cancelOrder struct {
-	ID int64
+// CancelOrder 是撤单的格式
+// 发送到 "cancelOrder" 话题时，按照 ID 撤销单个订单
+// 发送到 "cancelSymbolOrders" 话题时，撤销 Symbol 的全部订单
+type CancelOrder struct {
+	ID     int64
+	Symbol string
+}
+
+// DecCancelOrderErrFunc 返回的函数会把序列化成 []byte 的 CancelOrder 值转换回来
+// bs 无法转换成 CancelOrder 时，返回错误
+func DecCancelOrderErrFunc() func(bs []byte) (CancelOrder, error) {
+	dec := decFunc()
+	return func(bs []byte) (CancelOrder, error) {
+		var c CancelOrder
+		err := dec(bs, &c)
+		return c, err
+	}
+}
+
+// DecCancelOrderFunc 返回的函数会把序列化成 []byte 的 CancelOrder 值转换回来
+// bs 无法转换时，返回的是零值，需要处理错误的话，请使用 DecCancelOrderErrFunc
+func DecCancelOrderFunc() func(bs []byte) CancelOrder {
+	dec := DecCancelOrderErrFunc()
+	return func(bs []byte) CancelOrder {
+		c, _ := dec(bs)
+		return c
+	}
+}
+
+// CancelResult 是撤单的回报
+// 每个被撤销的订单，都会有一个 IsCanceled 为 true 的 CancelResult
+// 没有找到可以撤销的订单时，IsCanceled 为 false，Reason 说明了原因
+type CancelResult struct {
+	ID         int64
+	Symbol     string
+	IsCanceled bool
+	Reason     string
+}
+
+// DecCancelResultErrFunc 返回的函数会把序列化成 []byte 的 CancelResult 值转换回来
+// bs 无法转换成 CancelResult 时，返回错误
+func DecCancelResultErrFunc() func(bs []byte) (CancelResult, error) {
+	dec := decFunc()
+	return func(bs []byte) (CancelResult, error) {
+		var r CancelResult
+		err := dec(bs, &r)
+		return r, err
+	}
+}
+
+// DecCancelResultFunc 返回的函数会把序列化成 []byte 的 CancelResult 值转换回来
+// bs 无法转换时，返回的是零值，需要处理错误的话，请使用 DecCancelResultErrFunc
+func DecCancelResultFunc() func(bs []byte) CancelResult {
+	dec := DecCancelResultErrFunc()
+	return func(bs []byte) CancelResult {
+		r, _ := dec(bs)
+		return r
+	}
 }
@@ -2,8 +2,11 @@ package exch
 
 import (
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/prashantv/gostub"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -95,8 +98,9 @@ func Test_OrderSide_String(t *testing.T) {
 			})
 		}
 	})
-	Convey("遇到未定义的 OrderSide 会 panic", t, func() {
-		So(func() { _ = OrderSide(0).String() }, ShouldPanic)
+	Convey("不知道方向的 OrderSide 是 UNKNOWN", t, func() {
+		So(OrderSide(0).String(), ShouldEqual, "UNKNOWN")
+		So(fmt.Sprint(NewTick(1, time.Time{}, 1, 1)), ShouldNotContainSubstring, "PANIC")
 	})
 }
 
@@ -154,3 +158,153 @@ func Test_Order_IsLessThan(t *testing.T) {
 		})
 	})
 }
+
+func Test_OrderStatus_String(t *testing.T) {
+	Convey("测试 OrderStatus 的字符化", t, func() {
+		tests := []struct {
+			name     string
+			s        OrderStatus
+			expected string
+		}{
+			{"NEW", NEW, "NEW"},
+			{"PARTIALLY_FILLED", PARTIALLYfilled, "PARTIALLY_FILLED"},
+			{"FILLED", FILLED, "FILLED"},
+			{"CANCELED", CANCELED, "CANCELED"},
+			{"REJECTED", REJECTED, "REJECTED"},
+			{"EXPIRED", EXPIRED, "EXPIRED"},
+		}
+		for _, tt := range tests {
+			title := fmt.Sprintf("测试 %s", tt.name)
+			Convey(title, func() {
+				actual := tt.s.String()
+				So(actual, ShouldEqual, tt.expected)
+			})
+		}
+	})
+	Convey("未定义的 OrderStatus 是 UNKNOWN", t, func() {
+		So(OrderStatus(0).String(), ShouldEqual, "UNKNOWN")
+		So(OrderStatus(EXPIRED+1).String(), ShouldEqual, "UNKNOWN")
+	})
+}
+
+func Test_StopOrders(t *testing.T) {
+	Convey("设置触发类订单", t, func() {
+		order := NewOrder("BTCUSDT", "BTC", "USDT")
+		Convey("StopLoss 与 Market 的数量含义一样", func() {
+			mb := order.With(StopLoss(BUY, 10000, 110))
+			So(mb.Type, ShouldEqual, STOPloss)
+			So(mb.CapitalQuantity, ShouldEqual, 10000*DecimalOne)
+			So(mb.StopPrice, ShouldEqual, 110*DecimalOne)
+			ms := order.With(StopLoss(SELL, 100, 90))
+			So(ms.AssetQuantity, ShouldEqual, 100*DecimalOne)
+			So(ms.StopPrice, ShouldEqual, 90*DecimalOne)
+		})
+		Convey("StopLossLimit 与 Limit 的数量含义一样", func() {
+			lb := order.With(StopLossLimit(BUY, 100, 110, 111))
+			So(lb.Type, ShouldEqual, STOPlossLIMIT)
+			So(lb.AssetQuantity, ShouldEqual, 100*DecimalOne)
+			So(lb.AssetPrice, ShouldEqual, 111*DecimalOne)
+			So(lb.StopPrice, ShouldEqual, 110*DecimalOne)
+		})
+		Convey("TakeProfit 与 Market 的数量含义一样", func() {
+			ms := order.With(TakeProfit(SELL, 100, 110))
+			So(ms.Type, ShouldEqual, TAKEprofit)
+			So(ms.AssetQuantity, ShouldEqual, 100*DecimalOne)
+			So(ms.StopPrice, ShouldEqual, 110*DecimalOne)
+		})
+		Convey("TakeProfitLimit 与 Limit 的数量含义一样", func() {
+			ls := order.With(TakeProfitLimit(SELL, 100, 110, 109))
+			So(ls.Type, ShouldEqual, TAKEprofitLIMIT)
+			So(ls.AssetQuantity, ShouldEqual, 100*DecimalOne)
+			So(ls.AssetPrice, ShouldEqual, 109*DecimalOne)
+			So(ls.StopPrice, ShouldEqual, 110*DecimalOne)
+		})
+	})
+}
+
+func Test_LimitMaker(t *testing.T) {
+	Convey("LimitMaker 与 Limit 的数量含义一样", t, func() {
+		order := NewOrder("BTCUSDT", "BTC", "USDT")
+		lb := order.With(LimitMaker(BUY, 100, 10000))
+		So(lb.Type, ShouldEqual, LIMITmaker)
+		So(lb.Side, ShouldEqual, BUY)
+		So(lb.AssetQuantity, ShouldEqual, 100*DecimalOne)
+		So(lb.AssetPrice, ShouldEqual, 10000*DecimalOne)
+	})
+}
+
+func Test_TimeInForce(t *testing.T) {
+	Convey("测试 TimeInForce 的字符化", t, func() {
+		So(GTC.String(), ShouldEqual, "GTC")
+		So(IOC.String(), ShouldEqual, "IOC")
+		So(FOK.String(), ShouldEqual, "FOK")
+		So(GTD.String(), ShouldEqual, "GTD")
+		So(TimeInForce(0).String(), ShouldEqual, "GTC")
+		So(TimeInForce(GTD+1).String(), ShouldEqual, "UNKNOWN")
+	})
+	Convey("设置订单的有效方式", t, func() {
+		order := NewOrder("BTCUSDT", "BTC", "USDT")
+		Convey("默认是 GTC", func() {
+			So(order.With(Limit(BUY, 1, 10000)).TimeInForce, ShouldEqual, GTC)
+		})
+		Convey("InForce 可以与 Limit 一起使用", func() {
+			lb := order.With(Limit(BUY, 1, 10000), InForce(IOC))
+			So(lb.Type, ShouldEqual, LIMIT)
+			So(lb.TimeInForce, ShouldEqual, IOC)
+		})
+		Convey("GoodTillDate 会设置到期时间", func() {
+			expire := time.Now().Add(time.Hour)
+			lb := order.With(Limit(BUY, 1, 10000), GoodTillDate(expire))
+			So(lb.TimeInForce, ShouldEqual, GTD)
+			So(lb.ExpireTime, ShouldEqual, expire)
+		})
+	})
+}
+
+func Test_MonotonicIDFunc(t *testing.T) {
+	Convey("MonotonicIDFunc 生成的 ID 严格递增", t, func() {
+		newID := MonotonicIDFunc()
+		last := newID()
+		for i := 0; i < 1000; i++ {
+			id := newID()
+			So(id, ShouldBeGreaterThan, last)
+			last = id
+		}
+		Convey("并发调用也不会生成重复的 ID", func() {
+			var wg sync.WaitGroup
+			ids := make([][]int64, 8)
+			for i := range ids {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 1000; j++ {
+						ids[i] = append(ids[i], newID())
+					}
+				}(i)
+			}
+			wg.Wait()
+			seen := make(map[int64]bool, 8000)
+			for _, is := range ids {
+				for _, id := range is {
+					seen[id] = true
+				}
+			}
+			So(len(seen), ShouldEqual, 8000)
+		})
+	})
+	Convey("With 会使用 NewOrderID 生成 ID", t, func() {
+		stubs := gostub.Stub(&NewOrderID, func() int64 { return 42 })
+		defer stubs.Reset()
+		order := NewOrder("BTCUSDT", "BTC", "USDT")
+		So(order.With(Limit(BUY, 1, 10000)).ID, ShouldEqual, 42)
+	})
+}
+
+func Test_ClientOrderID(t *testing.T) {
+	Convey("ClientOrderID 会设置订单的 ClientOrderID", t, func() {
+		order := NewOrder("BTCUSDT", "BTC", "USDT")
+		lb := order.With(Limit(BUY, 1, 10000), ClientOrderID("retry-1"))
+		So(lb.ClientOrderID, ShouldEqual, "retry-1")
+		So(order.ClientOrderID, ShouldBeEmpty)
+	})
+}
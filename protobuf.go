@@ -0,0 +1,506 @@
+package exch
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// protobuf 的 wire type
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errProtobuf = errors.New("protobuf: 无法解析的数据")
+
+type protoCodec struct{}
+
+func (protoCodec) Name() string { return "protobuf" }
+
+func (c protoCodec) NewEncoder() func(e interface{}) ([]byte, error) {
+	return func(e interface{}) ([]byte, error) {
+		var w protoWriter
+		switch v := e.(type) {
+		case Tick:
+			w.tick(v)
+		case *Tick:
+			w.tick(*v)
+		case Bar:
+			w.bar(v)
+		case *Bar:
+			w.bar(*v)
+		case Order:
+			w.order(v)
+		case *Order:
+			w.order(*v)
+		case Asset:
+			w.asset(v)
+		case *Asset:
+			w.asset(*v)
+		case Balance:
+			w.balance(v)
+		case *Balance:
+			w.balance(*v)
+		case Trade:
+			w.trade(v)
+		case *Trade:
+			w.trade(*v)
+		case CancelOrder:
+			w.cancelOrder(v)
+		case *CancelOrder:
+			w.cancelOrder(*v)
+		case CancelResult:
+			w.cancelResult(v)
+		case *CancelResult:
+			w.cancelResult(*v)
+		default:
+			return nil, errUnsupported(c, e)
+		}
+		return w.buf, nil
+	}
+}
+
+func (c protoCodec) NewDecoder() func(bs []byte, e interface{}) error {
+	return func(bs []byte, e interface{}) error {
+		switch v := e.(type) {
+		case *Tick:
+			*v = Tick{}
+			return readTick(bs, v)
+		case *Bar:
+			*v = Bar{}
+			return readBar(bs, v)
+		case *Order:
+			*v = Order{}
+			return readOrder(bs, v)
+		case *Asset:
+			*v = Asset{}
+			return readAsset(bs, v)
+		case *Balance:
+			*v = make(Balance, 8)
+			return readBalance(bs, *v)
+		case *Trade:
+			*v = Trade{}
+			return readTrade(bs, v)
+		case *CancelOrder:
+			*v = CancelOrder{}
+			return readCancelOrder(bs, v)
+		case *CancelResult:
+			*v = CancelResult{}
+			return readCancelResult(bs, v)
+		default:
+			return errUnsupported(c, e)
+		}
+	}
+}
+
+// protoWriter 按照 protobuf 的格式写入字段
+// 与 proto3 一样，值为零的字段不会被写入，时间字段除外
+type protoWriter struct {
+	buf []byte
+}
+
+func (w *protoWriter) varint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	w.buf = append(w.buf, tmp[:n]...)
+}
+
+func (w *protoWriter) key(field, wire int) {
+	w.varint(uint64(field<<3 | wire))
+}
+
+func (w *protoWriter) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	w.key(field, wireVarint)
+	w.varint(v)
+}
+
+func (w *protoWriter) int(field int, v int64) {
+	w.uint(field, uint64(v))
+}
+
+// sint 使用 zigzag 编码，让绝对值小的负数也很短
+func (w *protoWriter) sint(field int, v int64) {
+	w.uint(field, uint64(v<<1)^uint64(v>>63))
+}
+
+func (w *protoWriter) bool(field int, b bool) {
+	if b {
+		w.uint(field, 1)
+	}
+}
+
+func (w *protoWriter) double(field int, f float64) {
+	if f == 0 {
+		return
+	}
+	w.key(field, wireFixed64)
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(f))
+	w.buf = append(w.buf, tmp[:]...)
+}
+
+func (w *protoWriter) bytes(field int, bs []byte) {
+	w.key(field, wireBytes)
+	w.varint(uint64(len(bs)))
+	w.buf = append(w.buf, bs...)
+}
+
+func (w *protoWriter) string(field int, s string) {
+	if s != "" {
+		w.bytes(field, []byte(s))
+	}
+}
+
+// time 与 proto3 的 optional 字段一样，用字段是否存在表示时间是否为零值
+// 所以，Unix 纳秒为 0 的 1970-01-01T00:00:00Z 也会被写入
+func (w *protoWriter) time(field int, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	w.key(field, wireVarint)
+	w.varint(uint64(t.UnixNano()))
+}
+
+func (w *protoWriter) tick(t Tick) {
+	w.string(1, t.Symbol)
+	w.int(2, t.ID)
+	w.time(3, t.Date)
+	w.sint(4, int64(t.Price))
+	w.sint(5, int64(t.Volume))
+	w.sint(6, int64(t.Side))
+	w.string(7, string(t.Exchange))
+}
+
+func (w *protoWriter) bar(b Bar) {
+	w.string(1, b.Symbol)
+	w.time(2, b.Begin)
+	w.int(3, int64(b.Interval))
+	w.double(4, b.Open)
+	w.double(5, b.High)
+	w.double(6, b.Low)
+	w.double(7, b.Close)
+	w.double(8, b.Volume)
+	w.double(9, b.QuoteVolume)
+	w.double(10, b.VWAP)
+	w.int(11, b.Trades)
+	w.double(12, b.TakerBuyVolume)
+	w.double(13, b.TakerBuyQuoteVolume)
+}
+
+func (w *protoWriter) order(o Order) {
+	w.string(1, o.Symbol)
+	w.string(2, o.AssetName)
+	w.string(3, o.CapitalName)
+	w.int(4, o.ID)
+	w.sint(5, int64(o.Side))
+	w.uint(6, uint64(o.Type))
+	w.string(7, o.ClientOrderID)
+	w.sint(8, int64(o.AssetQuantity))
+	w.sint(9, int64(o.AssetPrice))
+	w.sint(10, int64(o.CapitalQuantity))
+	w.sint(11, int64(o.StopPrice))
+	w.uint(12, uint64(o.TimeInForce))
+	w.time(13, o.ExpireTime)
+	w.uint(14, uint64(o.Status))
+	w.sint(15, int64(o.FilledQuantity))
+	w.sint(16, int64(o.AvgPrice))
+	w.time(17, o.UpdateTime)
+	w.string(18, o.RejectReason)
+}
+
+func (w *protoWriter) asset(a Asset) {
+	w.string(1, a.Name)
+	w.sint(2, int64(a.Free))
+	w.sint(3, int64(a.Locked))
+}
+
+func (w *protoWriter) balance(b Balance) {
+	names := make([]string, 0, len(b))
+	for name := range b {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var aw protoWriter
+		aw.asset(b[name])
+		w.bytes(1, aw.buf)
+	}
+}
+
+func (w *protoWriter) trade(t Trade) {
+	w.int(1, t.OrderID)
+	w.string(2, t.Symbol)
+	w.string(3, t.AssetName)
+	w.string(4, t.CapitalName)
+	w.sint(5, int64(t.Side))
+	w.sint(6, int64(t.Price))
+	w.sint(7, int64(t.Quantity))
+	w.sint(8, int64(t.Fee))
+	w.string(9, t.FeeAsset)
+	w.time(10, t.Date)
+	w.bool(11, t.IsMaker)
+}
+
+func (w *protoWriter) cancelOrder(c CancelOrder) {
+	w.int(1, c.ID)
+	w.string(2, c.Symbol)
+}
+
+func (w *protoWriter) cancelResult(r CancelResult) {
+	w.int(1, r.ID)
+	w.string(2, r.Symbol)
+	w.bool(3, r.IsCanceled)
+	w.string(4, r.Reason)
+}
+
+// protoValue 是读取到的一个字段的值
+// wireBytes 类型的字段，值在 bs 中，其他类型的字段，值在 u 中
+type protoValue struct {
+	u  uint64
+	bs []byte
+}
+
+func (v protoValue) int() int64 { return int64(v.u) }
+
+func (v protoValue) sint() int64 { return int64(v.u>>1) ^ -int64(v.u&1) }
+
+func (v protoValue) decimal() Decimal { return Decimal(v.sint()) }
+
+func (v protoValue) double() float64 { return math.Float64frombits(v.u) }
+
+func (v protoValue) string() string { return string(v.bs) }
+
+// time 只会用于存在的字段，不存在的时间字段保持零值
+func (v protoValue) time() time.Time { return time.Unix(0, v.int()) }
+
+// readFields 会依次读取 bs 中的字段，并交给 fn 处理
+// fn 不认识的字段会被忽略，这样新增的字段不会影响旧的程序
+func readFields(bs []byte, fn func(field int, v protoValue)) error {
+	for len(bs) > 0 {
+		key, n := binary.Uvarint(bs)
+		if n <= 0 {
+			return errProtobuf
+		}
+		bs = bs[n:]
+		var v protoValue
+		switch key & 7 {
+		case wireVarint:
+			v.u, n = binary.Uvarint(bs)
+			if n <= 0 {
+				return errProtobuf
+			}
+			bs = bs[n:]
+		case wireFixed64:
+			if len(bs) < 8 {
+				return errProtobuf
+			}
+			v.u, bs = binary.LittleEndian.Uint64(bs), bs[8:]
+		case wireFixed32:
+			if len(bs) < 4 {
+				return errProtobuf
+			}
+			v.u, bs = uint64(binary.LittleEndian.Uint32(bs)), bs[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(bs)
+			if n <= 0 || uint64(len(bs)-n) < size {
+				return errProtobuf
+			}
+			bs = bs[n:]
+			v.bs, bs = bs[:size], bs[size:]
+		default:
+			return errProtobuf
+		}
+		fn(int(key>>3), v)
+	}
+	return nil
+}
+
+func readTick(bs []byte, t *Tick) error {
+	return readFields(bs, func(field int, v protoValue) {
+		switch field {
+		case 1:
+			t.Symbol = v.string()
+		case 2:
+			t.ID = v.int()
+		case 3:
+			t.Date = v.time()
+		case 4:
+			t.Price = v.decimal()
+		case 5:
+			t.Volume = v.decimal()
+		case 6:
+			t.Side = OrderSide(v.sint())
+		case 7:
+			t.Exchange = Name(v.string())
+		}
+	})
+}
+
+func readBar(bs []byte, b *Bar) error {
+	return readFields(bs, func(field int, v protoValue) {
+		switch field {
+		case 1:
+			b.Symbol = v.string()
+		case 2:
+			b.Begin = v.time()
+		case 3:
+			b.Interval = time.Duration(v.int())
+		case 4:
+			b.Open = v.double()
+		case 5:
+			b.High = v.double()
+		case 6:
+			b.Low = v.double()
+		case 7:
+			b.Close = v.double()
+		case 8:
+			b.Volume = v.double()
+		case 9:
+			b.QuoteVolume = v.double()
+		case 10:
+			b.VWAP = v.double()
+		case 11:
+			b.Trades = v.int()
+		case 12:
+			b.TakerBuyVolume = v.double()
+		case 13:
+			b.TakerBuyQuoteVolume = v.double()
+		}
+	})
+}
+
+func readOrder(bs []byte, o *Order) error {
+	return readFields(bs, func(field int, v protoValue) {
+		switch field {
+		case 1:
+			o.Symbol = v.string()
+		case 2:
+			o.AssetName = v.string()
+		case 3:
+			o.CapitalName = v.string()
+		case 4:
+			o.ID = v.int()
+		case 5:
+			o.Side = OrderSide(v.sint())
+		case 6:
+			o.Type = OrderType(v.u)
+		case 7:
+			o.ClientOrderID = v.string()
+		case 8:
+			o.AssetQuantity = v.decimal()
+		case 9:
+			o.AssetPrice = v.decimal()
+		case 10:
+			o.CapitalQuantity = v.decimal()
+		case 11:
+			o.StopPrice = v.decimal()
+		case 12:
+			o.TimeInForce = TimeInForce(v.u)
+		case 13:
+			o.ExpireTime = v.time()
+		case 14:
+			o.Status = OrderStatus(v.u)
+		case 15:
+			o.FilledQuantity = v.decimal()
+		case 16:
+			o.AvgPrice = v.decimal()
+		case 17:
+			o.UpdateTime = v.time()
+		case 18:
+			o.RejectReason = v.string()
+		}
+	})
+}
+
+func readAsset(bs []byte, a *Asset) error {
+	return readFields(bs, func(field int, v protoValue) {
+		switch field {
+		case 1:
+			a.Name = v.string()
+		case 2:
+			a.Free = v.decimal()
+		case 3:
+			a.Locked = v.decimal()
+		}
+	})
+}
+
+func readBalance(bs []byte, b Balance) error {
+	var err error
+	e := readFields(bs, func(field int, v protoValue) {
+		if field != 1 || err != nil {
+			return
+		}
+		var a Asset
+		if err = readAsset(v.bs, &a); err == nil {
+			b[a.Name] = a
+		}
+	})
+	if e != nil {
+		return e
+	}
+	return err
+}
+
+func readTrade(bs []byte, t *Trade) error {
+	return readFields(bs, func(field int, v protoValue) {
+		switch field {
+		case 1:
+			t.OrderID = v.int()
+		case 2:
+			t.Symbol = v.string()
+		case 3:
+			t.AssetName = v.string()
+		case 4:
+			t.CapitalName = v.string()
+		case 5:
+			t.Side = OrderSide(v.sint())
+		case 6:
+			t.Price = v.decimal()
+		case 7:
+			t.Quantity = v.decimal()
+		case 8:
+			t.Fee = v.decimal()
+		case 9:
+			t.FeeAsset = v.string()
+		case 10:
+			t.Date = v.time()
+		case 11:
+			t.IsMaker = v.u != 0
+		}
+	})
+}
+
+func readCancelOrder(bs []byte, c *CancelOrder) error {
+	return readFields(bs, func(field int, v protoValue) {
+		switch field {
+		case 1:
+			c.ID = v.int()
+		case 2:
+			c.Symbol = v.string()
+		}
+	})
+}
+
+func readCancelResult(bs []byte, r *CancelResult) error {
+	return readFields(bs, func(field int, v protoValue) {
+		switch field {
+		case 1:
+			r.ID = v.int()
+		case 2:
+			r.Symbol = v.string()
+		case 3:
+			r.IsCanceled = v.u != 0
+		case 4:
+			r.Reason = v.string()
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package exch
+
+import "fmt"
+
+// SymbolInfo 记录了交易对的交易规则
+// 值为 0 的规则不做检查
+type SymbolInfo struct {
+	Exchange    Name
+	Symbol      string
+	AssetName   string
+	CapitalName string
+	// TickSize 是价格的最小变动单位，MinPrice 和 MaxPrice 是价格的范围
+	// 对应 binance 的 PRICE_FILTER
+	TickSize           Decimal
+	MinPrice, MaxPrice Decimal
+	// StepSize 是数量的最小变动单位，MinQuantity 和 MaxQuantity 是数量的范围
+	// 对应 binance 的 LOT_SIZE
+	StepSize                 Decimal
+	MinQuantity, MaxQuantity Decimal
+	// MinNotional 是订单的最小金额，以 Capital 计价
+	// 对应 binance 的 MIN_NOTIONAL
+	MinNotional Decimal
+}
+
+// NewOrder 返回 s 的交易对的订单模板，用法与 exch.NewOrder 一样
+func (s SymbolInfo) NewOrder() Order {
+	return NewOrder(s.Symbol, s.AssetName, s.CapitalName)
+}
+
+// Check 会检查 o 是否符合 s 的交易规则，不符合的话，返回原因
+// 市价单的金额按照 lastPrice 估算，lastPrice 为 0 时，不检查市价单的金额
+// 使用 Capital 下单的市价单，只检查金额，不检查数量
+func (s SymbolInfo) Check(o Order, lastPrice Decimal) error {
+	if o.AssetPrice != 0 {
+		if err := s.checkPrice(o.AssetPrice); err != nil {
+			return err
+		}
+	}
+	if o.StopPrice != 0 {
+		if err := s.checkPrice(o.StopPrice); err != nil {
+			return err
+		}
+	}
+	if o.AssetQuantity != 0 {
+		if err := s.checkQuantity(o.AssetQuantity); err != nil {
+			return err
+		}
+	}
+	return s.checkNotional(o, lastPrice)
+}
+
+func (s SymbolInfo) checkPrice(price Decimal) error {
+	switch {
+	case s.MinPrice != 0 && price < s.MinPrice:
+		return fmt.Errorf("PRICE_FILTER: 价格 %s 低于 %s", price, s.MinPrice)
+	case s.MaxPrice != 0 && price > s.MaxPrice:
+		return fmt.Errorf("PRICE_FILTER: 价格 %s 高于 %s", price, s.MaxPrice)
+	case s.TickSize != 0 && (price-s.MinPrice)%s.TickSize != 0:
+		return fmt.Errorf("PRICE_FILTER: 价格 %s 不符合 TickSize %s", price, s.TickSize)
+	}
+	return nil
+}
+
+func (s SymbolInfo) checkQuantity(quantity Decimal) error {
+	switch {
+	case s.MinQuantity != 0 && quantity < s.MinQuantity:
+		return fmt.Errorf("LOT_SIZE: 数量 %s 低于 %s", quantity, s.MinQuantity)
+	case s.MaxQuantity != 0 && quantity > s.MaxQuantity:
+		return fmt.Errorf("LOT_SIZE: 数量 %s 高于 %s", quantity, s.MaxQuantity)
+	case s.StepSize != 0 && (quantity-s.MinQuantity)%s.StepSize != 0:
+		return fmt.Errorf("LOT_SIZE: 数量 %s 不符合 StepSize %s", quantity, s.StepSize)
+	}
+	return nil
+}
+
+func (s SymbolInfo) checkNotional(o Order, lastPrice Decimal) error {
+	if s.MinNotional == 0 {
+		return nil
+	}
+	notional := o.CapitalQuantity
+	if o.AssetQuantity != 0 {
+		// 限价单按照限价计算，触发类的市价单按照触发价格估算
+		price := o.AssetPrice
+		if price == 0 {
+			price = o.StopPrice
+		}
+		if price == 0 {
+			price = lastPrice
+		}
+		if price == 0 {
+			return nil
+		}
+		notional = o.AssetQuantity.Mul(price)
+	}
+	if notional < s.MinNotional {
+		return fmt.Errorf("MIN_NOTIONAL: 金额 %s 低于 %s", notional, s.MinNotional)
+	}
+	return nil
+}
+
+// FloorStep 返回不大于 quantity 的 StepSize 的整数倍
+// StepSize 为 0 时，返回 quantity
+func (s SymbolInfo) FloorStep(quantity Decimal) Decimal {
+	if s.StepSize == 0 || quantity <= 0 {
+		return quantity
+	}
+	return quantity - quantity%s.StepSize
+}
+
+// Symbols 是交易规则的登记表，按照交易所的 Name 和 Symbol 查找 SymbolInfo
+type Symbols map[Name]map[string]SymbolInfo
+
+// NewSymbols returns a new Symbols
+func NewSymbols(infos ...SymbolInfo) Symbols {
+	s := make(Symbols, 4)
+	return s.Add(infos...)
+}
+
+// Add 会登记 infos，已经登记过的 SymbolInfo 会被替换
+func (s Symbols) Add(infos ...SymbolInfo) Symbols {
+	for _, info := range infos {
+		if _, ok := s[info.Exchange]; !ok {
+			s[info.Exchange] = make(map[string]SymbolInfo, 64)
+		}
+		s[info.Exchange][info.Symbol] = info
+	}
+	return s
+}
+
+// Get 返回 name 交易所的 symbol 的交易规则
+func (s Symbols) Get(name Name, symbol string) (SymbolInfo, bool) {
+	info, ok := s[name][symbol]
+	return info, ok
+}
@@ -0,0 +1,91 @@
+package exch
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestSymbolInfo() SymbolInfo {
+	return SymbolInfo{
+		Exchange:    BINANCE,
+		Symbol:      "BTCUSDT",
+		AssetName:   "BTC",
+		CapitalName: "USDT",
+		TickSize:    NewDecimal(0.01),
+		MinPrice:    NewDecimal(0.01),
+		MaxPrice:    NewDecimal(1000000),
+		StepSize:    NewDecimal(0.001),
+		MinQuantity: NewDecimal(0.001),
+		MaxQuantity: NewDecimal(9000),
+		MinNotional: NewDecimal(10),
+	}
+}
+
+func Test_SymbolInfo_Check(t *testing.T) {
+	Convey("SymbolInfo.Check 会检查订单是否符合交易规则", t, func() {
+		s := newTestSymbolInfo()
+		o := s.NewOrder()
+		Convey("符合规则的订单没有错误", func() {
+			So(s.Check(*o.With(Limit(BUY, 0.5, 10000.01)), 0), ShouldBeNil)
+			So(s.Check(*o.With(Market(BUY, 10)), 0), ShouldBeNil)
+		})
+		Convey("PRICE_FILTER", func() {
+			So(s.Check(*o.With(Limit(BUY, 1, 10000.001)), 0), ShouldBeError)
+			So(s.Check(*o.With(Limit(BUY, 1, 2000000)), 0), ShouldBeError)
+			So(s.Check(*o.With(StopLoss(SELL, 1, 9000.005)), 0), ShouldBeError)
+		})
+		Convey("LOT_SIZE", func() {
+			So(s.Check(*o.With(Limit(BUY, 0.0005, 100000)), 0), ShouldBeError)
+			So(s.Check(*o.With(Limit(BUY, 0.0015, 100000)), 0), ShouldBeError)
+			So(s.Check(*o.With(Market(SELL, 10000)), 0), ShouldBeError)
+		})
+		Convey("MIN_NOTIONAL", func() {
+			So(s.Check(*o.With(Limit(BUY, 0.001, 9999.99)), 0), ShouldBeError)
+			So(s.Check(*o.With(Market(BUY, 9.99)), 0), ShouldBeError)
+			Convey("市价单按照 lastPrice 估算金额", func() {
+				ms := *o.With(Market(SELL, 0.001))
+				So(s.Check(ms, 0), ShouldBeNil)
+				So(s.Check(ms, NewDecimal(9000)), ShouldBeError)
+				So(s.Check(ms, NewDecimal(10000)), ShouldBeNil)
+			})
+		})
+		Convey("值为 0 的规则不做检查", func() {
+			So(SymbolInfo{}.Check(*o.With(Limit(BUY, 0.0001234, 0.0001)), 0), ShouldBeNil)
+		})
+	})
+}
+
+func Test_SymbolInfo_FloorStep(t *testing.T) {
+	Convey("FloorStep 会向下取整到 StepSize 的整数倍", t, func() {
+		s := newTestSymbolInfo()
+		So(s.FloorStep(NewDecimal(1.23456)), ShouldEqual, NewDecimal(1.234))
+		So(s.FloorStep(NewDecimal(0.0009)), ShouldEqual, Decimal(0))
+		So(SymbolInfo{}.FloorStep(NewDecimal(1.23456)), ShouldEqual, NewDecimal(1.23456))
+	})
+}
+
+func Test_Symbols(t *testing.T) {
+	Convey("Symbols 按照交易所和 Symbol 登记交易规则", t, func() {
+		s := newTestSymbolInfo()
+		symbols := NewSymbols(s)
+		info, ok := symbols.Get(BINANCE, "BTCUSDT")
+		So(ok, ShouldBeTrue)
+		So(info, ShouldResemble, s)
+		_, ok = symbols.Get(BINANCE, "ETHUSDT")
+		So(ok, ShouldBeFalse)
+		_, ok = symbols.Get(LOCAL, "BTCUSDT")
+		So(ok, ShouldBeFalse)
+		Convey("再次登记会替换原有的规则", func() {
+			s.MinNotional = NewDecimal(5)
+			symbols.Add(s)
+			info, _ = symbols.Get(BINANCE, "BTCUSDT")
+			So(info.MinNotional, ShouldEqual, NewDecimal(5))
+		})
+		Convey("nil 的 Symbols 也可以查找", func() {
+			var empty Symbols
+			_, ok := empty.Get(BINANCE, "BTCUSDT")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
@@ -1,8 +1,6 @@
 package exch
 
 import (
-	"bytes"
-	"encoding/gob"
 	"time"
 )
 
@@ -11,37 +9,75 @@ import (
 // 要么直接使用 Tick，
 // 要么提供转换到 Tick 函数，
 type Tick struct {
-	// Exchange Name
-	// Symbol    string // like "BTCUSDT"
+	// Exchange 为空的 tick 不区分交易所
+	Exchange Name
+	// Symbol 为空的 tick 不区分交易对
+	Symbol string // like "BTCUSDT"
 	// Asset  string // like "BTC"
 	ID     int64
 	Date   time.Time
-	Price  float64
-	Volume float64
-	// Type   string
+	Price  Decimal
+	Volume Decimal
+	// Side 是主动成交的一方，也就是 taker 的方向
+	// BUY 表示买方主动成交，卖方是挂单的 maker，SELL 则相反
+	// 零值表示不知道是哪一方主动成交的
+	Side OrderSide
 }
 
 // NewTick returns a new tick
-func NewTick(id int64, date time.Time, price, volume float64) Tick {
-	return Tick{
+// price 和 volume 会被四舍五入到 Decimal
+// options 可以设置 tick 的其他字段，例如 TickSide(exch.BUY)
+func NewTick(id int64, date time.Time, price, volume float64, options ...func(*Tick)) Tick {
+	tick := Tick{
 		ID:     id,
 		Date:   date,
-		Price:  price,
-		Volume: volume,
+		Price:  NewDecimal(price),
+		Volume: NewDecimal(volume),
+	}
+	for _, option := range options {
+		option(&tick)
+	}
+	return tick
+}
+
+// TickSide 会把 tick 的主动成交方设置为 side
+func TickSide(side OrderSide) func(*Tick) {
+	return func(t *Tick) {
+		t.Side = side
 	}
 }
 
-// DecTickFunc 返回的函数会把序列化成 []byte 的 Balances 值转换回来
+// TickSymbol 会把 tick 的 Symbol 设置为 symbol
+func TickSymbol(symbol string) func(*Tick) {
+	return func(t *Tick) {
+		t.Symbol = symbol
+	}
+}
+
+// TickExchange 会把 tick 的 Exchange 设置为 name
+func TickExchange(name Name) func(*Tick) {
+	return func(t *Tick) {
+		t.Exchange = name
+	}
+}
+
+// DecTickErrFunc 返回的函数会把序列化成 []byte 的 Tick 值转换回来
+// bs 无法转换成 Tick 时，返回错误
+func DecTickErrFunc() func(bs []byte) (Tick, error) {
+	dec := decFunc()
+	return func(bs []byte) (Tick, error) {
+		var tick Tick
+		err := dec(bs, &tick)
+		return tick, err
+	}
+}
+
+// DecTickFunc 返回的函数会把序列化成 []byte 的 Tick 值转换回来
+// bs 无法转换时，返回的是零值，需要处理错误的话，请使用 DecTickErrFunc
 func DecTickFunc() func(bs []byte) Tick {
-	var bb bytes.Buffer
-	dec := gob.NewDecoder(&bb)
+	dec := DecTickErrFunc()
 	return func(bs []byte) Tick {
-		bb.Reset()
-		bb.Write(bs)
-		var tick Tick
-		// dec.Decode 只有在输入不是指针时候，才会报错
-		// 显然 &balances 肯定是一个指针
-		dec.Decode(&tick)
+		tick, _ := dec(bs)
 		return tick
 	}
 }
@@ -24,3 +24,36 @@ func Test_DecTickFunc(t *testing.T) {
 		})
 	})
 }
+
+func Test_DecTickErrFunc(t *testing.T) {
+	Convey("无法反向序列化的 Tick 会返回错误", t, func() {
+		dec := DecTickErrFunc()
+		_, err := dec([]byte("not a tick"))
+		So(err, ShouldBeError)
+		_, err = dec(nil)
+		So(err, ShouldBeError)
+		Convey("出错以后，依然可以反向序列化正确的 Tick", func() {
+			expected := NewTick(110, time.Now(), 122, 100)
+			actual, err := dec(EncFunc()(expected))
+			So(err, ShouldBeNil)
+			So(actual.Price, ShouldEqual, expected.Price)
+			So(actual.Date.Equal(expected.Date), ShouldBeTrue)
+		})
+	})
+}
+
+func Test_NewTick(t *testing.T) {
+	Convey("NewTick 可以设置 tick 的其他字段", t, func() {
+		date := time.Now()
+		tick := NewTick(1, date, 100, 2, TickSide(BUY), TickSymbol("BTCUSDT"), TickExchange(BINANCE))
+		So(tick, ShouldResemble, Tick{
+			Exchange: BINANCE,
+			Symbol:   "BTCUSDT",
+			ID:       1,
+			Date:     date,
+			Price:    100 * DecimalOne,
+			Volume:   2 * DecimalOne,
+			Side:     BUY,
+		})
+	})
+}
@@ -5,13 +5,95 @@ import (
 )
 
 // BeginFunc 会根据 time 和 interval 计算 time 所在周期的开始时间
+// 返回的时间在 time 之后的话，表示 time 处于休市的时间，
+// GenTickBarFunc 和 GenBarBarFunc 会忽略这样的 tick 和 bar
 type BeginFunc func(time.Time, time.Duration) time.Time
 
 // Begin 会根据 time 和 interval 计算 time 所在周期的开始时间
 // 当 interval 的单位
 //   为分钟或秒时，推荐值为 1,2,3,4,5,6,10,12,15,20,30,60
 //   为小时时，   推荐值为 1,2,3,4,6,8,12,24
+// Begin 在 UTC 中计算，日线从 UTC 的零点开始
 // NOTICE: 由于每个月的时间长度不一致，无法计算月线的起始日期。年线同理。
+// 需要月线、年线，或者需要按照当地时间划分日线的话，请使用 CalendarBegin
 func Begin(date time.Time, interval time.Duration) time.Time {
 	return date.Add(-interval / 2).Round(interval)
 }
+
+// 日历周期的 interval
+// CalendarBegin 返回的 BeginFunc 会把 Month、Quarter 和 Year 当作日历上的月、季度和年，
+// 而不是固定的时长，所以生成的 bar 的 Interval 只是名义上的长度
+const (
+	Day     = 24 * time.Hour
+	Week    = 7 * Day
+	Month   = 30 * Day
+	Quarter = 3 * Month
+	Year    = 365 * Day
+)
+
+// CalendarBegin 返回的 BeginFunc 会在 loc 时区的日历上计算周期的开始时间
+// 每天从当地时间的 dayStart 开始，例如，外汇的交易日从纽约时间的 17:00 开始
+// 周线从 weekStart 开始，月线、季线和年线从每月 1 日、每季度第一个月的 1 日和 1 月 1 日开始
+// 它们的开始时间也都是 dayStart
+// interval 为 Week、Month、Quarter 和 Year 时，按照日历计算
+// interval 为 Day 的整数倍时，从 1970-01-01 开始，每 interval 天一个周期
+// interval 能够整除 Day 时，每天从 dayStart 开始，每 interval 一个周期
+// 其他的 interval 会 panic
+func CalendarBegin(loc *time.Location, dayStart time.Duration, weekStart time.Weekday) BeginFunc {
+	// at 返回 loc 中 y 年 m 月 d 日的 dayStart
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, int(dayStart), loc)
+	}
+	return func(date time.Time, interval time.Duration) time.Time {
+		// 按照当地的钟表时间减去 dayStart 以后，得到的就是交易日的日期
+		// 直接减去时长的话，在夏令时切换的那一天会差一个小时
+		local := date.In(loc)
+		y, m, d := local.Date()
+		h, mi, sec := local.Clock()
+		wall := time.Date(y, m, d, h, mi, sec, local.Nanosecond(), time.UTC).Add(-dayStart)
+		y, m, d = wall.Date()
+		switch {
+		case interval == Year:
+			return at(y, 1, 1)
+		case interval == Quarter:
+			return at(y, (m-1)/3*3+1, 1)
+		case interval == Month:
+			return at(y, m, 1)
+		case interval == Week:
+			back := (int(wall.Weekday()) - int(weekStart) + 7) % 7
+			return at(y, m, d-back)
+		case interval%Day == 0:
+			// 按照日历上的天数计算，不受夏令时的影响
+			days := int(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / int64(Day/time.Second))
+			n := int(interval / Day)
+			// 1970 年以前的 days 是负数，需要向下取整，而不是向零取整
+			if days < 0 {
+				days -= n - 1
+			}
+			return at(1970, 1, 1+days/n*n)
+		case Day%interval == 0:
+			day := at(y, m, d)
+			return day.Add(date.Sub(day) / interval * interval)
+		default:
+			panic("CalendarBegin: 不支持的 interval " + interval.String())
+		}
+	}
+}
+
+// nextBegin 返回 begin 所在的周期的下一个周期的开始时间
+// begin 加上 interval 以后，通常已经落在下一个周期中，
+// 还在本周期中的话，例如月线和有夜盘的日线，就每次再向后 interval 的一半
+// 落在休市时的话，Calendar.Begin 会返回下一次开盘的时间，需要再用它计算一次所在的周期，
+// 因为有夜盘的话，周期可能在下一次开盘以前就开始了，例如，星期一的日线从上星期五的夜盘开始
+func nextBegin(bf BeginFunc, begin time.Time, interval time.Duration) time.Time {
+	for date := begin.Add(interval); ; date = date.Add(interval / 2) {
+		next := bf(date, interval)
+		if next.After(date) {
+			date = next
+			next = bf(date, interval)
+		}
+		if next.After(begin) {
+			return next
+		}
+	}
+}
@@ -146,3 +146,77 @@ func Test_Begin(t *testing.T) {
 		})
 	})
 }
+
+func Test_CalendarBegin(t *testing.T) {
+	Convey("CalendarBegin 在当地的日历上计算周期的开始时间", t, func() {
+		shanghai, err := time.LoadLocation("Asia/Shanghai")
+		So(err, ShouldBeNil)
+		newYork, err := time.LoadLocation("America/New_York")
+		So(err, ShouldBeNil)
+		cn := CalendarBegin(shanghai, 0, time.Monday)
+		// 2020-05-14 是星期四
+		date := time.Date(2020, 5, 14, 3, 30, 0, 0, shanghai)
+		Convey("日线从当地时间的零点开始", func() {
+			So(cn(date, Day), ShouldEqual, time.Date(2020, 5, 14, 0, 0, 0, 0, shanghai))
+			So(Begin(date, Day), ShouldEqual, time.Date(2020, 5, 13, 8, 0, 0, 0, shanghai))
+		})
+		Convey("日内的周期从 dayStart 开始", func() {
+			So(cn(date, 4*time.Hour), ShouldEqual, time.Date(2020, 5, 14, 0, 0, 0, 0, shanghai))
+			So(cn(date, time.Hour), ShouldEqual, time.Date(2020, 5, 14, 3, 0, 0, 0, shanghai))
+		})
+		Convey("周线从 weekStart 开始", func() {
+			So(cn(date, Week), ShouldEqual, time.Date(2020, 5, 11, 0, 0, 0, 0, shanghai))
+			sunday := CalendarBegin(shanghai, 0, time.Sunday)
+			So(sunday(date, Week), ShouldEqual, time.Date(2020, 5, 10, 0, 0, 0, 0, shanghai))
+		})
+		Convey("月线、季线和年线按照日历计算", func() {
+			So(cn(date, Month), ShouldEqual, time.Date(2020, 5, 1, 0, 0, 0, 0, shanghai))
+			So(cn(date, Quarter), ShouldEqual, time.Date(2020, 4, 1, 0, 0, 0, 0, shanghai))
+			So(cn(date, Year), ShouldEqual, time.Date(2020, 1, 1, 0, 0, 0, 0, shanghai))
+		})
+		Convey("多日的周期从 1970-01-01 开始计算", func() {
+			So(cn(date, 2*Day), ShouldEqual, time.Date(2020, 5, 14, 0, 0, 0, 0, shanghai))
+			So(cn(date.Add(Day), 2*Day), ShouldEqual, time.Date(2020, 5, 14, 0, 0, 0, 0, shanghai))
+			Convey("1970 年以前的周期，也向更早的时间对齐", func() {
+				utc := CalendarBegin(time.UTC, 0, time.Monday)
+				So(utc(time.Date(1969, 12, 31, 12, 0, 0, 0, time.UTC), 2*Day),
+					ShouldEqual, time.Date(1969, 12, 30, 0, 0, 0, 0, time.UTC))
+				So(utc(time.Date(1969, 12, 29, 12, 0, 0, 0, time.UTC), 3*Day),
+					ShouldEqual, time.Date(1969, 12, 29, 0, 0, 0, 0, time.UTC))
+				So(utc(time.Date(1969, 12, 28, 12, 0, 0, 0, time.UTC), 3*Day),
+					ShouldEqual, time.Date(1969, 12, 26, 0, 0, 0, 0, time.UTC))
+			})
+		})
+		Convey("不支持的 interval 会 panic", func() {
+			So(func() { cn(date, 7*time.Hour) }, ShouldPanic)
+		})
+		Convey("外汇的交易日从纽约时间的 17:00 开始", func() {
+			fx := CalendarBegin(newYork, 17*time.Hour, time.Sunday)
+			So(fx(time.Date(2020, 5, 14, 16, 59, 0, 0, newYork), Day),
+				ShouldEqual, time.Date(2020, 5, 13, 17, 0, 0, 0, newYork))
+			So(fx(time.Date(2020, 5, 14, 17, 0, 0, 0, newYork), Day),
+				ShouldEqual, time.Date(2020, 5, 14, 17, 0, 0, 0, newYork))
+			Convey("夏令时切换的那一天，依然从 17:00 开始", func() {
+				// 2020-03-08 凌晨 2 点开始夏令时
+				So(fx(time.Date(2020, 3, 8, 18, 0, 0, 0, newYork), Day),
+					ShouldEqual, time.Date(2020, 3, 8, 17, 0, 0, 0, newYork))
+				So(fx(time.Date(2020, 3, 8, 16, 0, 0, 0, newYork), Day),
+					ShouldEqual, time.Date(2020, 3, 7, 17, 0, 0, 0, newYork))
+				So(nextBegin(fx, time.Date(2020, 3, 7, 17, 0, 0, 0, newYork), Day),
+					ShouldEqual, time.Date(2020, 3, 8, 17, 0, 0, 0, newYork))
+			})
+		})
+	})
+}
+
+func Test_nextBegin(t *testing.T) {
+	Convey("nextBegin 返回下一个周期的开始时间", t, func() {
+		cn := CalendarBegin(time.UTC, 0, time.Monday)
+		jan := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		So(nextBegin(cn, jan, Month), ShouldEqual, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC))
+		So(nextBegin(cn, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), Month), ShouldEqual, time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC))
+		So(nextBegin(cn, jan, Quarter), ShouldEqual, time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC))
+		So(nextBegin(cn, jan, Year), ShouldEqual, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+		So(nextBegin(Begin, jan, time.Minute), ShouldEqual, jan.Add(time.Minute))
+	})
+}
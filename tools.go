@@ -23,6 +23,8 @@ func minFloat64(a, b float64) float64 {
 // 这些写成闭包的形式，而不是 enc
 // 是为了提高速度
 // 运行压力测试即可知道，提速了 6 倍
+// 返回值是 bb 内容的副本，因为 bb 的底层数组会被下一次调用覆盖，
+// 而同一批发布的多个 message 需要各自的 payload
 func EncFunc() func(interface{}) []byte {
 	var bb bytes.Buffer
 	enc := gob.NewEncoder(&bb)
@@ -32,6 +34,21 @@ func EncFunc() func(interface{}) []byte {
 		if err != nil {
 			panic("gob encode error:" + err.Error())
 		}
-		return bb.Bytes()
+		res := make([]byte, bb.Len())
+		copy(res, bb.Bytes())
+		return res
+	}
+}
+
+// decFunc 返回的函数能够把 EncFunc 生成的 []byte 转换到 e 中
+// e 需要是指针
+// 与 EncFunc 一样，写成闭包的形式，是为了复用 gob.Decoder
+func decFunc() func(bs []byte, e interface{}) error {
+	var bb bytes.Buffer
+	dec := gob.NewDecoder(&bb)
+	return func(bs []byte, e interface{}) error {
+		bb.Reset()
+		bb.Write(bs)
+		return dec.Decode(e)
 	}
 }
@@ -1 +1,53 @@
 package exch
+
+import (
+	"fmt"
+	"time"
+)
+
+// Trade 记录了订单的一次成交
+// 订单每次部分成交或完全成交，都会产生一个 Trade
+type Trade struct {
+	OrderID     int64
+	Symbol      string
+	AssetName   string
+	CapitalName string
+	Side        OrderSide
+	// Price 是成交价格，Quantity 是成交的 Asset 数量
+	Price    Decimal
+	Quantity Decimal
+	// Fee 是以 FeeAsset 计价的手续费
+	Fee      Decimal
+	FeeAsset string
+	// Date 是成交时的模拟时间，也就是撮合的 tick 的时间
+	Date    time.Time
+	IsMaker bool
+}
+
+func (t Trade) String() string {
+	id := fmt.Sprintf("[%s:%d]", t.Symbol, t.OrderID)
+	st := fmt.Sprintf("[S:%s,M:%t]", t.Side, t.IsMaker)
+	pq := fmt.Sprintf("[%s:%s:%s%s]", t.Price, t.Quantity, t.Fee, t.FeeAsset)
+	return id + st + pq + t.Date.String()
+}
+
+// DecTradeErrFunc 返回的函数会把序列化成 []byte 的 Trade 值转换回来
+// bs 无法转换成 Trade 时，返回错误
+func DecTradeErrFunc() func(bs []byte) (Trade, error) {
+	dec := decFunc()
+	return func(bs []byte) (Trade, error) {
+		var trade Trade
+		err := dec(bs, &trade)
+		return trade, err
+	}
+}
+
+// DecTradeFunc 返回的函数会把序列化成 []byte 的 Trade 值转换回来
+// bs 无法转换时，返回的是零值，需要处理错误的话，请使用 DecTradeErrFunc
+func DecTradeFunc() func(bs []byte) Trade {
+	dec := DecTradeErrFunc()
+	return func(bs []byte) Trade {
+		trade, _ := dec(bs)
+		return trade
+	}
+}
@@ -0,0 +1,34 @@
+package exch
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_DecTradeFunc(t *testing.T) {
+	Convey("反向序列化 Trade", t, func() {
+		expected := Trade{
+			OrderID:     1,
+			Symbol:      "BTCUSDT",
+			AssetName:   "BTC",
+			CapitalName: "USDT",
+			Side:        BUY,
+			Price:       10000 * DecimalOne,
+			Quantity:    DecimalOne,
+			Fee:         NewDecimal(0.001),
+			FeeAsset:    "BTC",
+			Date:        time.Now(),
+			IsMaker:     true,
+		}
+		enc := EncFunc()
+		dec := DecTradeFunc()
+		actual := dec(enc(expected))
+		Convey("具体的值，应该相同", func() {
+			So(actual.Date.Equal(expected.Date), ShouldBeTrue)
+			actual.Date = expected.Date
+			So(actual, ShouldResemble, expected)
+		})
+	})
+}